@@ -1,14 +0,0 @@
-package config
-
-// This file includes configs for the run program settings
-
-var (
-	archReadableFiles = []string{
-		"/lib/x86_64-linux-gnu/",
-		"/usr/lib/x86_64-linux-gnu/",
-	}
-
-	archSyscallAllows = []string{}
-
-	archSyscallTraces = []string{}
-)
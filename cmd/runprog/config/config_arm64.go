@@ -1,16 +0,0 @@
-package config
-
-// This file includes configs for the run program settings
-
-var (
-	archReadableFiles = []string{
-		"/lib/aarch64-linux-gnu/",
-		"/usr/lib/aarch64-linux-gnu/",
-	}
-
-	archSyscallAllows = []string{
-		"newfstatat",
-	}
-
-	archSyscallTraces = []string{}
-)
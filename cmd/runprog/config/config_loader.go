@@ -1,47 +1,96 @@
 package config
 
-import "github.com/zqzqsb/sandbox/runner/ptrace/filehandler"
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
 
-// GetConf return file access check set, syscall counter, allow and traced syscall arrays and new args
+	"github.com/zqzqsb/sandbox/runner/ptrace"
+	"github.com/zqzqsb/sandbox/runner/ptrace/filehandler"
+)
+
+// GetConf return file access check set, syscall counter, allow, traced and errno syscall arrays and new args
 func GetConf(pType, workPath string, args, addRead, addWrite []string,
-	allowProc bool) ([]string, []string, []string, *filehandler.Handler) {
+	allowProc bool) ([]string, []string, []string, map[syscall.Errno][]string, *filehandler.Handler) {
 	var (
 		fs    = filehandler.NewFileSets()
 		sc    = filehandler.NewSyscallCounter()
-		allow = append(append([]string{}, defaultSyscallAllows...), archSyscallAllows...)
-		trace = append(append([]string{}, defaultSyscallTraces...), archSyscallTraces...)
+		allow = append(append([]string{}, defaultSyscallAllows...), archConfig.Syscall.ExtraAllow...)
+		trace = append(append([]string{}, defaultSyscallTraces...), archConfig.Syscall.ExtraTrace...)
+		errno = make(map[syscall.Errno][]string)
 	)
 
 	fs.Readable.AddRange(defaultReadableFiles, workPath)
-	fs.Readable.AddRange(archReadableFiles, workPath)
+	fs.Readable.AddRange(archConfig.FileAccess.ExtraRead, workPath)
 	fs.Writable.AddRange(defaultWritableFiles, workPath)
 	fs.AddFilePermission(args[0], filehandler.FilePermRead)
 	fs.AddFilePermission(workPath, filehandler.FilePermRead)
+	// runtimes such as node resolve the real path of the program, which
+	// lstat's every ancestor of the work path
+	for d := filepath.Dir(workPath); d != "/" && d != "."; d = filepath.Dir(d) {
+		fs.AddFilePermission(d, filehandler.FilePermStat)
+	}
+	fs.AddFilePermission("/", filehandler.FilePermStat)
 
 	fs.Readable.AddRange(addRead, workPath)
 	fs.Writable.AddRange(addWrite, workPath)
 
 	if c, o := runptraceConfig[pType]; o {
 		allow = append(allow, c.Syscall.ExtraAllow...)
-		trace = append(trace, c.Syscall.ExtraBan...)
+		trace = append(trace, c.Syscall.ExtraTrace...)
+		errno[ptrace.BanRet] = append(errno[ptrace.BanRet], c.Syscall.ExtraBan...)
+		for e, names := range c.Syscall.ExtraErrno {
+			errno[e] = append(errno[e], names...)
+		}
 		sc.AddRange(c.Syscall.ExtraCount)
 		fs.Readable.AddRange(c.FileAccess.ExtraRead, workPath)
 		fs.Writable.AddRange(c.FileAccess.ExtraWrite, workPath)
 		fs.Statable.AddRange(c.FileAccess.ExtraStat, workPath)
-		fs.SoftBan.AddRange(c.FileAccess.ExtraBan, workPath)
-		args = append(c.RunCommand, args...)
+		fs.SoftBan.AddRange(expandAncestors(c.FileAccess.ExtraBan, workPath), workPath)
+		args = append(append([]string{}, c.RunCommand...), args...)
 	}
 	if allowProc {
 		allow = append(allow, defaultProcSyscalls...)
 	}
 	allow, trace = cleanTrace(allow, trace)
+	allow, trace, errno = cleanErrno(allow, trace, errno)
 
-	return args, allow, trace, &filehandler.Handler{
+	return args, allow, trace, errno, &filehandler.Handler{
 		FileSet:        fs,
 		SyscallCounter: sc,
 	}
 }
 
+// expandAncestors replaces each "*/name" with name in the work path and in
+// every ancestor of it, which is where runtimes such as node look up their
+// package.json. The other names are returned unchanged
+func expandAncestors(names []string, workPath string) []string {
+	rt := make([]string, 0, len(names))
+	for _, n := range names {
+		base, ok := strings.CutPrefix(n, "*/")
+		if !ok {
+			rt = append(rt, n)
+			continue
+		}
+		for d := workPath; ; d = filepath.Dir(d) {
+			rt = append(rt, filepath.Join(d, base))
+			if d == "/" || d == "." {
+				break
+			}
+		}
+	}
+	return rt
+}
+
+// GetProgramConfig returns the config of the program type, ok is false for
+// unknown types. GetConf covers the file and syscall sets, this gives the
+// seccomp rules and mounts
+func GetProgramConfig(pType string) (c ProgramConfig, ok bool) {
+	c, ok = runptraceConfig[pType]
+	return c, ok
+}
+
 func keySetToSlice(m map[string]bool) []string {
 	rt := make([]string, 0, len(m))
 	for k := range m {
@@ -64,3 +113,36 @@ func cleanTrace(allow, trace []string) ([]string, []string) {
 	}
 	return keySetToSlice(allowMap), keySetToSlice(traceMap)
 }
+
+func cleanErrno(allow, trace []string, errno map[syscall.Errno][]string) ([]string, []string, map[syscall.Errno][]string) {
+	// make sure banned syscalls are neither allowed nor traced, and each
+	// syscall appears in only one errno group (the smallest errno wins)
+	errnos := make([]syscall.Errno, 0, len(errno))
+	for e := range errno {
+		errnos = append(errnos, e)
+	}
+	sort.Slice(errnos, func(i, j int) bool { return errnos[i] < errnos[j] })
+
+	errnoMap := make(map[string]bool)
+	for _, e := range errnos {
+		var rt []string
+		for _, s := range errno[e] {
+			if !errnoMap[s] {
+				errnoMap[s] = true
+				rt = append(rt, s)
+			}
+		}
+		errno[e] = rt
+	}
+	return removeSet(allow, errnoMap), removeSet(trace, errnoMap), errno
+}
+
+func removeSet(names []string, m map[string]bool) []string {
+	rt := make([]string, 0, len(names))
+	for _, s := range names {
+		if !m[s] {
+			rt = append(rt, s)
+		}
+	}
+	return rt
+}
@@ -1,19 +1,39 @@
 package config
 
+import (
+	"syscall"
+
+	"github.com/zqzqsb/sandbox/pkg/seccomp/libseccomp"
+)
+
 // ProgramConfig defines the extra config apply to program type
 type ProgramConfig struct {
 	Syscall    SyscallConfig
 	FileAccess FileAccessConfig
+	Mounts     []MountConfig
 	RunCommand []string
 }
 
 // SyscallConfig defines extra syscallConfig apply to program type
+// ExtraBan syscalls fail with ptrace.BanRet and ExtraErrno syscalls fail with
+// the given errno; both are compiled into the seccomp filter so they work for
+// every runner. ExtraRules are argument-conditional rules added to the
+// seccomp filter, e.g. allowing clone only with CLONE_THREAD
 type SyscallConfig struct {
-	ExtraAllow, ExtraBan []string
-	ExtraCount           map[string]int
+	ExtraAllow, ExtraTrace, ExtraBan []string
+	ExtraErrno                       map[syscall.Errno][]string
+	ExtraCount                       map[string]int
+	ExtraRules                       []libseccomp.Rule
 }
 
 // FileAccessConfig defines extra file access permission for the program type
 type FileAccessConfig struct {
 	ExtraRead, ExtraWrite, ExtraStat, ExtraBan []string
 }
+
+// MountConfig defines an extra bind mount for the ns and container runners,
+// Target is relative to the new root
+type MountConfig struct {
+	Source, Target string
+	Readonly       bool
+}
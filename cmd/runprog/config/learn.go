@@ -0,0 +1,159 @@
+package config
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// minCollapse is the number of entries of a directory at which the learned
+// paths are collapsed to the directory
+const minCollapse = 3
+
+// broadDirs are never collapsed to since they would allow far more than the
+// program needed; /proc/self/ would also allow reopening fds via /proc/self/fd
+var broadDirs = map[string]bool{
+	"/": true, "/bin/": true, "/dev/": true, "/etc/": true, "/home/": true,
+	"/lib/": true, "/lib64/": true, "/opt/": true, "/proc/": true,
+	"/proc/self/": true, "/root/": true, "/run/": true, "/sys/": true,
+	"/tmp/": true, "/usr/": true, "/usr/bin/": true, "/usr/lib/": true,
+	"/usr/local/": true, "/usr/local/bin/": true, "/usr/local/lib/": true,
+	"/usr/share/": true, "/var/": true,
+}
+
+var procPid = regexp.MustCompile(`^/proc/[0-9]+(/|$)`)
+
+// LearnedConfig builds the config of a learning run (runprog -learn) from the
+// syscalls and paths the program needed beyond its program type
+//
+// Read and stat paths are collapsed to their directory when the directory
+// has at least 3 of them, paths under workPath become relative and
+// /proc/<pid> becomes /proc/self. Written paths are kept as they are.
+func LearnedConfig(allow, trace, read, write, stat []string, workPath string) ProgramConfig {
+	read = collapsePaths(normalizePaths(read, workPath))
+	write = normalizePaths(write, workPath)
+	stat = collapsePaths(normalizePaths(stat, workPath))
+
+	// stat is allowed on readable and writable files
+	covered := make([]string, 0, len(read)+len(write))
+	covered = append(append(covered, read...), write...)
+	stat = removeCovered(stat, covered)
+	read = removeCovered(read, write)
+
+	return ProgramConfig{
+		Syscall: SyscallConfig{
+			ExtraAllow: sortedSet(allow),
+			ExtraTrace: sortedSet(trace),
+		},
+		FileAccess: FileAccessConfig{
+			ExtraRead:  read,
+			ExtraWrite: write,
+			ExtraStat:  stat,
+		},
+	}
+}
+
+// normalizePaths maps the observed paths to profile paths
+func normalizePaths(paths []string, workPath string) []string {
+	rt := make([]string, 0, len(paths))
+	for _, p := range paths {
+		p = procPid.ReplaceAllString(filepath.Clean(p), "/proc/self$1")
+		if rel, err := filepath.Rel(workPath, p); err == nil && !strings.HasPrefix(rel, "..") {
+			if rel == "." {
+				continue // the work path is always readable
+			}
+			p = "./" + rel
+		}
+		rt = append(rt, p)
+	}
+	return sortedSet(rt)
+}
+
+// collapsePaths replaces the entries of a directory by the directory (with a
+// trailing "/") when there are at least minCollapse of them, repeating until
+// nothing changes so that collapsed directories count towards their parent
+func collapsePaths(paths []string) []string {
+	set := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		set[p] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		children := make(map[string][]string)
+		for p := range set {
+			dir := parentDir(p)
+			if dir != "" && !broadDirs[dir] && dir != "./" {
+				children[dir] = append(children[dir], p)
+			}
+		}
+		for dir, c := range children {
+			if len(c) < minCollapse {
+				continue
+			}
+			for _, p := range c {
+				delete(set, p)
+			}
+			set[dir] = true
+			changed = true
+		}
+	}
+	rt := make([]string, 0, len(set))
+	for p := range set {
+		rt = append(rt, p)
+	}
+	return removeCovered(sortedSet(rt), nil)
+}
+
+// parentDir returns the directory containing p with a trailing "/"
+func parentDir(p string) string {
+	p = strings.TrimSuffix(p, "/")
+	i := strings.LastIndex(p, "/")
+	if i < 0 {
+		return ""
+	}
+	return p[:i+1]
+}
+
+// removeCovered removes the paths that are in or are one of the directories
+// of paths, and the paths covered by the entries of other
+func removeCovered(paths, other []string) []string {
+	all := append(append([]string{}, paths...), other...)
+	rt := make([]string, 0, len(paths))
+	for _, p := range paths {
+		covered := false
+		for _, d := range all {
+			// "dir/" also covers "dir" itself, as in FileSet
+			if d != p && strings.HasSuffix(d, "/") && strings.HasPrefix(p+"/", d) {
+				covered = true
+				break
+			}
+		}
+		if !covered && !contains(other, p) {
+			rt = append(rt, p)
+		}
+	}
+	return rt
+}
+
+func contains(a []string, s string) bool {
+	for _, e := range a {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedSet(a []string) []string {
+	m := make(map[string]bool, len(a))
+	for _, s := range a {
+		m[s] = true
+	}
+	rt := keySetToSlice(m)
+	sort.Strings(rt)
+	if len(rt) == 0 {
+		return nil
+	}
+	return rt
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLearnedConfig(t *testing.T) {
+	c := LearnedConfig(
+		[]string{"futex", "clone3", "futex"},
+		[]string{"statx"},
+		[]string{
+			"/usr/lib/jvm/java-17/lib/modules",
+			"/usr/lib/jvm/java-17/lib/server/libjvm.so",
+			"/usr/lib/jvm/java-17/lib/libjava.so",
+			"/usr/lib/jvm/java-17/lib/libjli.so",
+			"/usr/lib/jvm/java-17/release",
+			"/proc/1234/maps",
+			"/proc/self/status",
+			"/proc/self/stat",
+			"/etc/passwd",
+			"/etc/group",
+			"/etc/hosts",
+			"/w/Main.class",
+			"/w/out.txt",
+		},
+		[]string{"/w/out.txt"},
+		[]string{"/usr/lib/jvm/java-17", "/usr/lib/jvm/java-17/lib/server", "/opt/x"},
+		"/w",
+	)
+	want := ProgramConfig{
+		Syscall: SyscallConfig{
+			ExtraAllow: []string{"clone3", "futex"},
+			ExtraTrace: []string{"statx"},
+		},
+		FileAccess: FileAccessConfig{
+			ExtraRead: []string{
+				"./Main.class",
+				"/etc/group", "/etc/hosts", "/etc/passwd",
+				"/proc/self/maps", "/proc/self/stat", "/proc/self/status",
+				"/usr/lib/jvm/java-17/lib/",
+				"/usr/lib/jvm/java-17/release",
+			},
+			ExtraWrite: []string{"./out.txt"},
+			ExtraStat:  []string{"/opt/x", "/usr/lib/jvm/java-17"},
+		},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("unexpected config\n got %#v\nwant %#v", c, want)
+	}
+}
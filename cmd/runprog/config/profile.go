@@ -0,0 +1,773 @@
+package config
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/elastic/go-seccomp-bpf/arch"
+	"github.com/zqzqsb/sandbox/pkg/seccomp/libseccomp"
+	"golang.org/x/sys/unix"
+)
+
+// Profiles are JSON files describing a ProgramConfig. The file name without
+// the .json extension is the program type:
+//
+//	{
+//	  "extends": "python3",
+//	  "syscall": {
+//	    "allow": ["futex"],
+//	    "trace": [],
+//	    "ban": ["socket"],
+//	    "errno": {"ENOSYS": ["io_uring_setup"]},
+//	    "count": {"set_tid_address": 1},
+//	    "rules": [
+//	      {"name": "clone", "action": "allow", "conditions": [
+//	        {"arg": 0, "op": "&==", "mask": "0x10000", "value": "0x10000"}
+//	      ]}
+//	    ]
+//	  },
+//	  "file": {"read": ["/usr/lib/python3/"], "write": [], "stat": ["/usr"], "ban": ["*/package.json"]},
+//	  "mounts": [{"source": "/etc/python3", "target": "/etc/python3", "writable": false}],
+//	  "runCommand": ["/usr/bin/python3", "-I", "-B"],
+//	  "arch": {
+//	    "arm64": {"syscall": {"allow": ["fstatat"]}, "file": {"read": ["/usr/lib/aarch64-linux-gnu/"]}}
+//	  }
+//	}
+//
+// "extends" names another profile in the same directory or a built-in program
+// type. Lists, counts and rules are added to the parent's, and runCommand
+// replaces the parent's when set. The "arch" section for runtime.GOARCH is
+// added last. Only JSON is supported since the module has no YAML dependency.
+//
+// A rule applies its action ("allow", "kill" or an errno name) when all of its
+// conditions hold; the ops are those of libseccomp.Op ("==", "!=", ">", ">=",
+// "<", "<=", "&=="). Values above 2^53 must be written as strings, which may be
+// hex. Mounts are bind mounts for the ns and container runners, read-only unless
+// writable is set, and are skipped when the source does not exist. A "ban"
+// entry "*/name" bans name in the work path and in each of its ancestors.
+//
+// The built-in profiles in the profiles directory are loaded at init.
+
+// archConfig is the per-architecture part of the default config, loaded from
+// the embedded arch.json
+var archConfig = mustParseArchConfig()
+
+//go:embed arch.json
+var archJSON []byte
+
+//go:embed profiles/*.json
+var builtinProfiles embed.FS
+
+func init() {
+	sub, err := fs.Sub(builtinProfiles, "profiles")
+	if err == nil {
+		err = loadProfiles(sub)
+	}
+	if err != nil {
+		panic("built-in profiles: " + err.Error())
+	}
+}
+
+type profile struct {
+	Extends    string                 `json:"extends,omitempty"`
+	Syscall    *syscallProfile        `json:"syscall,omitempty"`
+	File       *fileProfile           `json:"file,omitempty"`
+	Mounts     []mountProfile         `json:"mounts,omitempty"`
+	RunCommand []string               `json:"runCommand,omitempty"`
+	Arch       map[string]archProfile `json:"arch,omitempty"`
+}
+
+type archProfile struct {
+	Syscall *syscallProfile `json:"syscall,omitempty"`
+	File    *fileProfile    `json:"file,omitempty"`
+}
+
+type syscallProfile struct {
+	Allow []string            `json:"allow,omitempty"`
+	Trace []string            `json:"trace,omitempty"`
+	Ban   []string            `json:"ban,omitempty"`
+	Errno map[string][]string `json:"errno,omitempty"`
+	Count map[string]int      `json:"count,omitempty"`
+	Rules []ruleProfile       `json:"rules,omitempty"`
+}
+
+type ruleProfile struct {
+	Name       string             `json:"name"`
+	Action     string             `json:"action"`
+	Conditions []conditionProfile `json:"conditions,omitempty"`
+}
+
+// conditionProfile values are JSON numbers or strings parsed by argValue
+type conditionProfile struct {
+	Arg   uint        `json:"arg"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+	Mask  interface{} `json:"mask,omitempty"`
+}
+
+type mountProfile struct {
+	Source   string `json:"source"`
+	Target   string `json:"target,omitempty"`
+	Writable bool   `json:"writable,omitempty"`
+}
+
+type fileProfile struct {
+	Read  []string `json:"read,omitempty"`
+	Write []string `json:"write,omitempty"`
+	Stat  []string `json:"stat,omitempty"`
+	Ban   []string `json:"ban,omitempty"`
+}
+
+// LoadProfiles loads the *.json profiles in dir and registers them as program
+// types, replacing built-in types of the same name
+//
+// Errors name the file and the offending key, e.g.
+// "python3.json: syscall.allow[2]: unknown syscall \"fork2\""
+func LoadProfiles(dir string) error {
+	return loadProfiles(os.DirFS(dir))
+}
+
+// loadProfiles loads the *.json profiles at the top of fsys
+func loadProfiles(fsys fs.FS) error {
+	files, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return err
+	}
+
+	profiles := make(map[string]*profile, len(files))
+	for _, f := range files {
+		b, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return err
+		}
+		p, err := parseProfile(b)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path.Base(f), err)
+		}
+		profiles[strings.TrimSuffix(path.Base(f), ".json")] = p
+	}
+
+	resolved := make(map[string]ProgramConfig, len(profiles))
+	for name := range profiles {
+		c, err := resolveProfile(name, profiles, resolved, nil)
+		if err != nil {
+			return err
+		}
+		resolved[name] = c
+	}
+	for name, c := range resolved {
+		runptraceConfig[name] = c
+	}
+	return nil
+}
+
+// resolveProfile returns the config of the profile with its parents merged in
+func resolveProfile(name string, profiles map[string]*profile, resolved map[string]ProgramConfig, visiting []string) (ProgramConfig, error) {
+	if c, ok := resolved[name]; ok {
+		return c, nil
+	}
+	p, ok := profiles[name]
+	if !ok {
+		if c, ok := runptraceConfig[name]; ok {
+			return c, nil
+		}
+		return ProgramConfig{}, fmt.Errorf("%s.json: extends: unknown profile %q", visiting[len(visiting)-1], name)
+	}
+	for _, v := range visiting {
+		if v == name {
+			return ProgramConfig{}, fmt.Errorf("%s.json: extends: inheritance cycle %s", name, strings.Join(append(visiting, name), " -> "))
+		}
+	}
+
+	var c ProgramConfig
+	if p.Extends != "" {
+		parent, err := resolveProfile(p.Extends, profiles, resolved, append(visiting, name))
+		if err != nil {
+			return ProgramConfig{}, err
+		}
+		c = c.merge(parent)
+	}
+	c = c.merge(p.config(runtime.GOARCH))
+	resolved[name] = c
+	return c, nil
+}
+
+// config converts the profile to ProgramConfig, including the section of goarch
+func (p *profile) config(goarch string) ProgramConfig {
+	c := ProgramConfig{RunCommand: p.RunCommand}
+	for _, m := range p.Mounts {
+		target := m.Target
+		if target == "" {
+			target = m.Source
+		}
+		c.Mounts = append(c.Mounts, MountConfig{
+			Source:   m.Source,
+			Target:   strings.TrimPrefix(target, "/"),
+			Readonly: !m.Writable,
+		})
+	}
+	c = c.merge(sectionConfig(p.Syscall, p.File))
+	if a, ok := p.Arch[goarch]; ok {
+		c = c.merge(sectionConfig(a.Syscall, a.File))
+	}
+	return c
+}
+
+func sectionConfig(s *syscallProfile, f *fileProfile) ProgramConfig {
+	if s == nil {
+		s = new(syscallProfile)
+	}
+	if f == nil {
+		f = new(fileProfile)
+	}
+	c := ProgramConfig{
+		Syscall: SyscallConfig{
+			ExtraAllow: s.Allow,
+			ExtraTrace: s.Trace,
+			ExtraBan:   s.Ban,
+			ExtraCount: s.Count,
+		},
+		FileAccess: FileAccessConfig{
+			ExtraRead:  f.Read,
+			ExtraWrite: f.Write,
+			ExtraStat:  f.Stat,
+			ExtraBan:   f.Ban,
+		},
+	}
+	if len(s.Errno) > 0 {
+		c.Syscall.ExtraErrno = make(map[syscall.Errno][]string, len(s.Errno))
+		for name, names := range s.Errno {
+			c.Syscall.ExtraErrno[errnoValues[name]] = names
+		}
+	}
+	for _, r := range s.Rules {
+		c.Syscall.ExtraRules = append(c.Syscall.ExtraRules, r.rule())
+	}
+	return c
+}
+
+// rule converts a validated rule profile to libseccomp.Rule
+func (r ruleProfile) rule() libseccomp.Rule {
+	action, _ := ruleAction(r.Action)
+	rule := libseccomp.Rule{Name: r.Name, Action: action}
+	for _, c := range r.Conditions {
+		value, _ := argValue(c.Value)
+		mask, _ := argValue(c.Mask)
+		rule.Conditions = append(rule.Conditions, libseccomp.Condition{
+			Arg:   c.Arg,
+			Op:    ruleOps[c.Op],
+			Value: value,
+			Mask:  mask,
+		})
+	}
+	return rule
+}
+
+// ruleAction parses "allow", "kill" or an errno name
+func ruleAction(s string) (libseccomp.Action, bool) {
+	switch s {
+	case "allow":
+		return libseccomp.ActionAllow, true
+	case "kill":
+		return libseccomp.ActionKill, true
+	}
+	if e, ok := errnoValues[s]; ok {
+		return libseccomp.ActionErrno.WithReturnCode(int16(e)), true
+	}
+	return 0, false
+}
+
+// ruleOps maps the names of libseccomp.Op to the ops
+var ruleOps = func() map[string]libseccomp.Op {
+	m := make(map[string]libseccomp.Op)
+	for o := libseccomp.OpEqual; o <= libseccomp.OpMaskedEqual; o++ {
+		m[o.String()] = o
+	}
+	return m
+}()
+
+// argValue parses a condition value, which is either an integral JSON number
+// no greater than 2^53 or a string accepted by strconv.ParseUint with base 0
+func argValue(v interface{}) (uint64, bool) {
+	switch v := v.(type) {
+	case nil:
+		return 0, true
+	case float64:
+		if v < 0 || v != math.Trunc(v) || v > 1<<53 {
+			return 0, false
+		}
+		return uint64(v), true
+	case string:
+		n, err := strconv.ParseUint(v, 0, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// merge returns a new config with o added to c, c is not modified
+func (c ProgramConfig) merge(o ProgramConfig) ProgramConfig {
+	r := ProgramConfig{
+		Syscall: SyscallConfig{
+			ExtraAllow: concat(c.Syscall.ExtraAllow, o.Syscall.ExtraAllow),
+			ExtraTrace: concat(c.Syscall.ExtraTrace, o.Syscall.ExtraTrace),
+			ExtraBan:   concat(c.Syscall.ExtraBan, o.Syscall.ExtraBan),
+		},
+		FileAccess: FileAccessConfig{
+			ExtraRead:  concat(c.FileAccess.ExtraRead, o.FileAccess.ExtraRead),
+			ExtraWrite: concat(c.FileAccess.ExtraWrite, o.FileAccess.ExtraWrite),
+			ExtraStat:  concat(c.FileAccess.ExtraStat, o.FileAccess.ExtraStat),
+			ExtraBan:   concat(c.FileAccess.ExtraBan, o.FileAccess.ExtraBan),
+		},
+		Mounts:     append(append([]MountConfig(nil), c.Mounts...), o.Mounts...),
+		RunCommand: c.RunCommand,
+	}
+	r.Syscall.ExtraRules = append(append([]libseccomp.Rule(nil), c.Syscall.ExtraRules...), o.Syscall.ExtraRules...)
+	if len(o.RunCommand) > 0 {
+		r.RunCommand = o.RunCommand
+	}
+	if len(c.Syscall.ExtraErrno)+len(o.Syscall.ExtraErrno) > 0 {
+		r.Syscall.ExtraErrno = make(map[syscall.Errno][]string)
+		for _, m := range []map[syscall.Errno][]string{c.Syscall.ExtraErrno, o.Syscall.ExtraErrno} {
+			for e, names := range m {
+				r.Syscall.ExtraErrno[e] = concat(r.Syscall.ExtraErrno[e], names)
+			}
+		}
+	}
+	if len(c.Syscall.ExtraCount)+len(o.Syscall.ExtraCount) > 0 {
+		r.Syscall.ExtraCount = make(map[string]int)
+		for _, m := range []map[string]int{c.Syscall.ExtraCount, o.Syscall.ExtraCount} {
+			for k, v := range m {
+				r.Syscall.ExtraCount[k] = v
+			}
+		}
+	}
+	return r
+}
+
+func concat(a, b []string) []string {
+	if len(a)+len(b) == 0 {
+		return nil
+	}
+	return append(append(make([]string, 0, len(a)+len(b)), a...), b...)
+}
+
+// parseProfile validates and decodes a profile
+func parseProfile(b []byte) (*profile, error) {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	if err := validateProfile(v); err != nil {
+		return nil, err
+	}
+	p := new(profile)
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// MarshalProfile encodes c as a profile extending the program type extends,
+// which LoadProfiles reads back as the same config
+func MarshalProfile(extends string, c ProgramConfig) ([]byte, error) {
+	p := profile{
+		Extends: extends,
+		Syscall: &syscallProfile{
+			Allow: c.Syscall.ExtraAllow,
+			Trace: c.Syscall.ExtraTrace,
+			Ban:   c.Syscall.ExtraBan,
+			Count: c.Syscall.ExtraCount,
+		},
+		File: &fileProfile{
+			Read:  c.FileAccess.ExtraRead,
+			Write: c.FileAccess.ExtraWrite,
+			Stat:  c.FileAccess.ExtraStat,
+			Ban:   c.FileAccess.ExtraBan,
+		},
+		RunCommand: c.RunCommand,
+	}
+	for e, names := range c.Syscall.ExtraErrno {
+		name := unix.ErrnoName(e)
+		if name == "" {
+			return nil, fmt.Errorf("unknown errno %d", e)
+		}
+		if p.Syscall.Errno == nil {
+			p.Syscall.Errno = make(map[string][]string)
+		}
+		p.Syscall.Errno[name] = names
+	}
+	for _, r := range c.Syscall.ExtraRules {
+		rp, err := marshalRule(r)
+		if err != nil {
+			return nil, err
+		}
+		p.Syscall.Rules = append(p.Syscall.Rules, rp)
+	}
+	for _, m := range c.Mounts {
+		p.Mounts = append(p.Mounts, mountProfile{
+			Source:   m.Source,
+			Target:   "/" + m.Target,
+			Writable: !m.Readonly,
+		})
+	}
+	if reflect.DeepEqual(*p.Syscall, syscallProfile{}) {
+		p.Syscall = nil
+	}
+	if reflect.DeepEqual(*p.File, fileProfile{}) {
+		p.File = nil
+	}
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func marshalRule(r libseccomp.Rule) (ruleProfile, error) {
+	rp := ruleProfile{Name: r.Name}
+	switch r.Action.Action() {
+	case libseccomp.ActionAllow:
+		rp.Action = "allow"
+	case libseccomp.ActionKill:
+		rp.Action = "kill"
+	case libseccomp.ActionErrno:
+		e := syscall.Errno(r.Action.ReturnCode())
+		if e == 0 {
+			e = syscall.EPERM
+		}
+		rp.Action = unix.ErrnoName(e)
+	}
+	if _, ok := ruleAction(rp.Action); !ok {
+		return ruleProfile{}, fmt.Errorf("%s: unsupported rule action %v", r.Name, r.Action)
+	}
+	for _, c := range r.Conditions {
+		cp := conditionProfile{Arg: c.Arg, Op: c.Op.String(), Value: fmt.Sprintf("%#x", c.Value)}
+		if c.Op == libseccomp.OpMaskedEqual {
+			cp.Mask = fmt.Sprintf("%#x", c.Mask)
+		}
+		rp.Conditions = append(rp.Conditions, cp)
+	}
+	return rp, nil
+}
+
+func mustParseArchConfig() ProgramConfig {
+	p, err := parseProfile(archJSON)
+	if err != nil {
+		panic("arch.json: " + err.Error())
+	}
+	return p.config(runtime.GOARCH)
+}
+
+// errnoValues maps errno names such as "ENOSYS" to their values
+var errnoValues = func() map[string]syscall.Errno {
+	m := make(map[string]syscall.Errno)
+	for e := syscall.Errno(1); e < 4096; e++ {
+		if n := unix.ErrnoName(e); n != "" {
+			m[n] = e
+		}
+	}
+	return m
+}()
+
+// validator checks the value at path, returning an error that names the path
+type validator func(path string, v interface{}) error
+
+func validateProfile(v interface{}) error {
+	native, err := arch.GetInfo("")
+	if err != nil {
+		return err
+	}
+	return object(map[string]validator{
+		"extends":    stringValue,
+		"syscall":    syscallSection(native),
+		"file":       fileSection,
+		"mounts":     mountList,
+		"runCommand": stringList,
+		"arch":       archSections,
+	})("", v)
+}
+
+func archSections(path string, v interface{}) error {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return pathError(path, "expected object")
+	}
+	for _, k := range sortedKeys(m) {
+		info, err := arch.GetInfo(k)
+		if err != nil {
+			return pathError(join(path, k), "unsupported architecture")
+		}
+		err = object(map[string]validator{
+			"syscall": syscallSection(info),
+			"file":    fileSection,
+		})(join(path, k), m[k])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func syscallSection(info *arch.Info) validator {
+	names := syscallNames(info)
+	return object(map[string]validator{
+		"allow": names,
+		"trace": names,
+		"ban":   names,
+		"errno": func(path string, v interface{}) error {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return pathError(path, "expected object")
+			}
+			for _, k := range sortedKeys(m) {
+				if _, ok := errnoValues[k]; !ok {
+					return pathError(join(path, k), "unknown errno")
+				}
+				if err := names(join(path, k), m[k]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		"count": func(path string, v interface{}) error {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return pathError(path, "expected object")
+			}
+			for _, k := range sortedKeys(m) {
+				if _, ok := info.SyscallNames[k]; !ok {
+					return pathError(join(path, k), fmt.Sprintf("unknown syscall on %s", info.Name))
+				}
+				n, ok := m[k].(float64)
+				if !ok || n < 0 || n != math.Trunc(n) || n > math.MaxInt32 {
+					return pathError(join(path, k), "expected non-negative integer")
+				}
+			}
+			return nil
+		},
+		"rules": ruleList(info),
+	})
+}
+
+// ruleList checks that v is an array of rules on syscalls of the architecture
+func ruleList(info *arch.Info) validator {
+	condition := object(map[string]validator{
+		"arg": func(path string, v interface{}) error {
+			if n, ok := v.(float64); !ok || n < 0 || n > 5 || n != math.Trunc(n) {
+				return pathError(path, "expected argument index 0-5")
+			}
+			return nil
+		},
+		"op": func(path string, v interface{}) error {
+			if _, ok := ruleOps[fmt.Sprint(v)]; !ok {
+				return pathError(path, fmt.Sprintf("unknown op %v", v))
+			}
+			return nil
+		},
+		"value": conditionValue,
+		"mask":  conditionValue,
+	})
+	rule := object(map[string]validator{
+		"name": func(path string, v interface{}) error {
+			if err := stringValue(path, v); err != nil {
+				return err
+			}
+			if _, ok := info.SyscallNames[v.(string)]; !ok {
+				return pathError(path, fmt.Sprintf("unknown syscall %q on %s", v, info.Name))
+			}
+			return nil
+		},
+		"action": func(path string, v interface{}) error {
+			if _, ok := ruleAction(fmt.Sprint(v)); !ok {
+				return pathError(path, fmt.Sprintf("unknown action %v", v))
+			}
+			return nil
+		},
+		"conditions": func(path string, v interface{}) error {
+			a, ok := v.([]interface{})
+			if !ok {
+				return pathError(path, "expected array")
+			}
+			for i, c := range a {
+				p := fmt.Sprintf("%s[%d]", path, i)
+				if err := condition(p, c); err != nil {
+					return err
+				}
+				if err := required(p, c, "arg", "op", "value"); err != nil {
+					return err
+				}
+				if c.(map[string]interface{})["op"] == libseccomp.OpMaskedEqual.String() {
+					if err := required(p, c, "mask"); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	})
+	return func(path string, v interface{}) error {
+		a, ok := v.([]interface{})
+		if !ok {
+			return pathError(path, "expected array")
+		}
+		for i, r := range a {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			if err := rule(p, r); err != nil {
+				return err
+			}
+			if err := required(p, r, "name", "action"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func conditionValue(path string, v interface{}) error {
+	if _, ok := argValue(v); !ok || v == nil {
+		return pathError(path, "expected integer or integer string")
+	}
+	return nil
+}
+
+// mountList checks that v is an array of mounts with absolute paths
+func mountList(path string, v interface{}) error {
+	absPath := func(path string, v interface{}) error {
+		if s, ok := v.(string); !ok || !strings.HasPrefix(s, "/") {
+			return pathError(path, "expected absolute path")
+		}
+		return nil
+	}
+	mount := object(map[string]validator{
+		"source": absPath,
+		"target": absPath,
+		"writable": func(path string, v interface{}) error {
+			if _, ok := v.(bool); !ok {
+				return pathError(path, "expected boolean")
+			}
+			return nil
+		},
+	})
+	a, ok := v.([]interface{})
+	if !ok {
+		return pathError(path, "expected array")
+	}
+	for i, m := range a {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		if err := mount(p, m); err != nil {
+			return err
+		}
+		if err := required(p, m, "source"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// required checks that the object v has all the keys
+func required(path string, v interface{}, keys ...string) error {
+	m := v.(map[string]interface{})
+	for _, k := range keys {
+		if _, ok := m[k]; !ok {
+			return pathError(join(path, k), "missing")
+		}
+	}
+	return nil
+}
+
+var fileSection = object(map[string]validator{
+	"read":  stringList,
+	"write": stringList,
+	"stat":  stringList,
+	"ban":   stringList,
+})
+
+// object checks that v is an object with only the given keys
+func object(keys map[string]validator) validator {
+	return func(path string, v interface{}) error {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return pathError(path, "expected object")
+		}
+		for _, k := range sortedKeys(m) {
+			check, ok := keys[k]
+			if !ok {
+				return pathError(join(path, k), "unknown key")
+			}
+			if err := check(join(path, k), m[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func stringValue(path string, v interface{}) error {
+	if _, ok := v.(string); !ok {
+		return pathError(path, "expected string")
+	}
+	return nil
+}
+
+// stringList checks that v is an array of strings
+func stringList(path string, v interface{}) error {
+	a, ok := v.([]interface{})
+	if !ok {
+		return pathError(path, "expected array of strings")
+	}
+	for i, e := range a {
+		if err := stringValue(fmt.Sprintf("%s[%d]", path, i), e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syscallNames checks that v is an array of syscall names of the architecture
+func syscallNames(info *arch.Info) validator {
+	return func(path string, v interface{}) error {
+		if err := stringList(path, v); err != nil {
+			return err
+		}
+		for i, e := range v.([]interface{}) {
+			if _, ok := info.SyscallNames[e.(string)]; !ok {
+				return pathError(fmt.Sprintf("%s[%d]", path, i), fmt.Sprintf("unknown syscall %q on %s", e, info.Name))
+			}
+		}
+		return nil
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func pathError(path, msg string) error {
+	if path == "" {
+		return fmt.Errorf("%s", msg)
+	}
+	return fmt.Errorf("%s: %s", path, msg)
+}
@@ -0,0 +1,430 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/elastic/go-seccomp-bpf/arch"
+	"github.com/zqzqsb/sandbox/pkg/seccomp/libseccomp"
+	"github.com/zqzqsb/sandbox/runner"
+	"github.com/zqzqsb/sandbox/runner/ptrace"
+)
+
+// loadTestProfiles writes the profiles to a temp dir and loads them, the
+// built-in program types are restored when the test finishes
+func loadTestProfiles(t *testing.T, profiles map[string]string) error {
+	t.Helper()
+
+	saved := make(map[string]ProgramConfig, len(runptraceConfig))
+	for k, v := range runptraceConfig {
+		saved[k] = v
+	}
+	t.Cleanup(func() { runptraceConfig = saved })
+
+	dir := t.TempDir()
+	for name, content := range profiles {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return LoadProfiles(dir)
+}
+
+func TestArchProfile(t *testing.T) {
+	p, err := parseProfile(archJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, goarch := range []string{"amd64", "arm64", "arm"} {
+		if c := p.config(goarch); len(c.FileAccess.ExtraRead) == 0 {
+			t.Errorf("%s: missing arch readable files", goarch)
+		}
+	}
+	if c := p.config("arm"); !reflect.DeepEqual(c.Syscall.ExtraTrace, []string{"lstat64", "stat64"}) {
+		t.Errorf("arm: unexpected trace %v", c.Syscall.ExtraTrace)
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	err := loadTestProfiles(t, map[string]string{
+		"base.json": `{
+			"syscall": {"allow": ["futex"], "count": {"set_tid_address": 1}},
+			"file": {"read": ["/usr/lib/"]},
+			"runCommand": ["/usr/bin/env"]
+		}`,
+		"lang.json": `{
+			"extends": "base",
+			"syscall": {
+				"ban": ["socket"],
+				"errno": {"ENOSYS": ["io_uring_setup"]},
+				"count": {"set_tid_address": 2},
+				"rules": [{"name": "clone", "action": "allow", "conditions": [
+					{"arg": 0, "op": "&==", "mask": "0x10000", "value": 65536}
+				]}]
+			},
+			"file": {"read": ["/opt/lang/"]},
+			"mounts": [{"source": "/opt/lang"}, {"source": "/var/lang", "target": "/var/cache/lang", "writable": true}],
+			"runCommand": ["/opt/lang/bin/lang", "-q"],
+			"arch": {
+				"amd64": {"file": {"read": ["/opt/lang/amd64/"]}},
+				"arm64": {"file": {"read": ["/opt/lang/arm64/"]}}
+			}
+		}`,
+		"py.json":     `{"extends": "python3", "syscall": {"allow": ["pipe2"]}}`,
+		"ignored.txt": `not a profile`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, ok := runptraceConfig["lang"]
+	if !ok {
+		t.Fatal("lang not loaded")
+	}
+	if !reflect.DeepEqual(c.Syscall.ExtraAllow, []string{"futex"}) {
+		t.Errorf("unexpected allow %v", c.Syscall.ExtraAllow)
+	}
+	if !reflect.DeepEqual(c.Syscall.ExtraBan, []string{"socket"}) {
+		t.Errorf("unexpected ban %v", c.Syscall.ExtraBan)
+	}
+	if !reflect.DeepEqual(c.Syscall.ExtraErrno, map[syscall.Errno][]string{syscall.ENOSYS: {"io_uring_setup"}}) {
+		t.Errorf("unexpected errno %v", c.Syscall.ExtraErrno)
+	}
+	if c.Syscall.ExtraCount["set_tid_address"] != 2 {
+		t.Errorf("unexpected count %v", c.Syscall.ExtraCount)
+	}
+	if !reflect.DeepEqual(c.RunCommand, []string{"/opt/lang/bin/lang", "-q"}) {
+		t.Errorf("unexpected run command %v", c.RunCommand)
+	}
+	if read := c.FileAccess.ExtraRead; len(read) < 2 || read[0] != "/usr/lib/" || read[1] != "/opt/lang/" {
+		t.Errorf("unexpected read %v", read)
+	}
+	rules := []libseccomp.Rule{{Name: "clone", Action: libseccomp.ActionAllow, Conditions: []libseccomp.Condition{
+		{Arg: 0, Op: libseccomp.OpMaskedEqual, Value: syscall.CLONE_THREAD, Mask: syscall.CLONE_THREAD},
+	}}}
+	if !reflect.DeepEqual(c.Syscall.ExtraRules, rules) {
+		t.Errorf("unexpected rules %v", c.Syscall.ExtraRules)
+	}
+	mounts := []MountConfig{
+		{Source: "/opt/lang", Target: "opt/lang", Readonly: true},
+		{Source: "/var/lang", Target: "var/cache/lang"},
+	}
+	if !reflect.DeepEqual(c.Mounts, mounts) {
+		t.Errorf("unexpected mounts %v", c.Mounts)
+	}
+
+	// 继承内置的程序类型
+	py := runptraceConfig["py"]
+	if !reflect.DeepEqual(py.RunCommand, runptraceConfig["python3"].RunCommand) {
+		t.Errorf("unexpected run command %v", py.RunCommand)
+	}
+	if allow := py.Syscall.ExtraAllow; allow[len(allow)-1] != "pipe2" {
+		t.Errorf("unexpected allow %v", allow)
+	}
+	if _, ok := runptraceConfig["ignored"]; ok {
+		t.Error("non-json file loaded")
+	}
+}
+
+func TestLoadProfilesErrors(t *testing.T) {
+	tests := []struct {
+		profiles map[string]string
+		err      string
+	}{
+		{map[string]string{"a.json": `{"syscall": {"alow": []}}`}, "a.json: syscall.alow: unknown key"},
+		{map[string]string{"a.json": `{"syscall": {"allow": ["read", "no_such_call"]}}`}, `a.json: syscall.allow[1]: unknown syscall "no_such_call"`},
+		{map[string]string{"a.json": `{"syscall": {"count": {"futex": -1}}}`}, "a.json: syscall.count.futex: expected non-negative integer"},
+		{map[string]string{"a.json": `{"syscall": {"errno": {"ENOPE": ["socket"]}}}`}, "a.json: syscall.errno.ENOPE: unknown errno"},
+		{map[string]string{"a.json": `{"file": {"read": "/usr"}}`}, "a.json: file.read: expected array of strings"},
+		{map[string]string{"a.json": `{"arch": {"vax": {}}}`}, "a.json: arch.vax: unsupported architecture"},
+		{map[string]string{"a.json": `{"arch": {"arm": {"syscall": {"allow": ["arch_prctl"]}}}}`}, `a.json: arch.arm.syscall.allow[0]: unknown syscall "arch_prctl" on arm`},
+		{map[string]string{"a.json": `{"extends": "nope"}`}, `a.json: extends: unknown profile "nope"`},
+		{map[string]string{"a.json": `{"extends": "b"}`, "b.json": `{"extends": "a"}`}, "inheritance cycle"},
+		{map[string]string{"a.json": `[]`}, "a.json: expected object"},
+		{map[string]string{"a.json": `{"syscall": {"rules": [{"name": "clone", "action": "trace"}]}}`}, "a.json: syscall.rules[0].action: unknown action trace"},
+		{map[string]string{"a.json": `{"syscall": {"rules": [{"action": "allow"}]}}`}, "a.json: syscall.rules[0].name: missing"},
+		{map[string]string{"a.json": `{"syscall": {"rules": [{"name": "clone", "action": "EPERM", "conditions": [{"arg": 6, "op": "==", "value": 1}]}]}}`}, "syscall.rules[0].conditions[0].arg: expected argument index 0-5"},
+		{map[string]string{"a.json": `{"syscall": {"rules": [{"name": "clone", "action": "allow", "conditions": [{"arg": 0, "op": "=~", "value": 1}]}]}}`}, "syscall.rules[0].conditions[0].op: unknown op =~"},
+		{map[string]string{"a.json": `{"syscall": {"rules": [{"name": "clone", "action": "allow", "conditions": [{"arg": 0, "op": "&==", "value": 1}]}]}}`}, "syscall.rules[0].conditions[0].mask: missing"},
+		{map[string]string{"a.json": `{"syscall": {"rules": [{"name": "clone", "action": "allow", "conditions": [{"arg": 0, "op": "==", "value": "0xzz"}]}]}}`}, "syscall.rules[0].conditions[0].value: expected integer or integer string"},
+		{map[string]string{"a.json": `{"mounts": [{"source": "etc/java"}]}`}, "a.json: mounts[0].source: expected absolute path"},
+	}
+	for _, tc := range tests {
+		err := loadTestProfiles(t, tc.profiles)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("expected error %q, got %v", tc.err, err)
+		}
+	}
+}
+
+func TestMarshalProfile(t *testing.T) {
+	c := ProgramConfig{
+		Syscall: SyscallConfig{
+			ExtraAllow: []string{"futex"},
+			ExtraTrace: []string{"statx"},
+			ExtraBan:   []string{"socket"},
+			ExtraErrno: map[syscall.Errno][]string{syscall.ENOSYS: {"clone3"}},
+			ExtraCount: map[string]int{"set_tid_address": 1},
+			ExtraRules: []libseccomp.Rule{
+				{Name: "clone", Action: libseccomp.ActionAllow, Conditions: []libseccomp.Condition{
+					{Arg: 0, Op: libseccomp.OpMaskedEqual, Value: syscall.CLONE_THREAD, Mask: syscall.CLONE_THREAD},
+				}},
+				{Name: "prctl", Action: libseccomp.ActionErrno.WithReturnCode(int16(syscall.EINVAL)), Conditions: []libseccomp.Condition{
+					{Arg: 0, Op: libseccomp.OpNotEqual, Value: 1 << 60},
+				}},
+			},
+		},
+		FileAccess: FileAccessConfig{
+			ExtraRead:  []string{"./", "/usr/lib/jvm/"},
+			ExtraWrite: []string{"/tmp/out"},
+			ExtraStat:  []string{"/usr"},
+			ExtraBan:   []string{"*/package.json"},
+		},
+		Mounts:     []MountConfig{{Source: "/etc/java", Target: "etc/java", Readonly: true}},
+		RunCommand: []string{"/usr/bin/java"},
+	}
+	b, err := MarshalProfile("", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadTestProfiles(t, map[string]string{"x.json": string(b)}); err != nil {
+		t.Fatalf("%v\n%s", err, b)
+	}
+	if got := runptraceConfig["x"]; !reflect.DeepEqual(got, c) {
+		t.Errorf("unexpected config\n got %#v\nwant %#v\n%s", got, c, b)
+	}
+
+	b, err = MarshalProfile("python3", ProgramConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "{\n  \"extends\": \"python3\"\n}\n" {
+		t.Errorf("unexpected empty profile %q", b)
+	}
+
+	c.Syscall.ExtraRules = []libseccomp.Rule{{Name: "clone", Action: libseccomp.ActionTrace}}
+	if _, err := MarshalProfile("", c); err == nil {
+		t.Error("expected error for trace rule")
+	}
+}
+
+// TestBuiltinProfiles checks the built-in profiles on every architecture since
+// init panics on an architecture where one of them is invalid
+func TestBuiltinProfiles(t *testing.T) {
+	files, err := fs.Glob(builtinProfiles, "profiles/*.json")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no built-in profiles: %v", err)
+	}
+	for _, f := range files {
+		b, err := builtinProfiles.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v map[string]interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		for _, goarch := range []string{"amd64", "arm64", "arm"} {
+			info, err := arch.GetInfo(goarch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s, ok := v["syscall"]; ok {
+				if err := syscallSection(info)("syscall", s); err != nil {
+					t.Errorf("%s on %s: %v", f, goarch, err)
+				}
+			}
+		}
+		name := strings.TrimSuffix(filepath.Base(f), ".json")
+		if _, ok := runptraceConfig[name]; !ok {
+			t.Errorf("%s not loaded", name)
+		}
+	}
+}
+
+// TestBuiltinProfilesInherit checks what the JVM, PyPy and Ruby profiles get
+// from the profiles they extend, since TestBuiltinProfilesRun skips them when
+// their runtimes are not installed
+func TestBuiltinProfilesInherit(t *testing.T) {
+	native, _ := GetProgramConfig("native")
+	java, _ := GetProgramConfig("java")
+	tests := []struct {
+		pType, run string
+		parent     ProgramConfig
+	}{
+		{"java", "/usr/bin/java", native},
+		{"kotlin", "/usr/bin/java", java},
+		{"pypy3", "/usr/bin/pypy3", native},
+		{"ruby", "/usr/bin/ruby", native},
+	}
+	for _, tc := range tests {
+		c, ok := GetProgramConfig(tc.pType)
+		if !ok {
+			t.Errorf("%s not loaded", tc.pType)
+			continue
+		}
+		if len(c.RunCommand) == 0 || c.RunCommand[0] != tc.run {
+			t.Errorf("%s: unexpected run command %v", tc.pType, c.RunCommand)
+		}
+		for _, s := range tc.parent.Syscall.ExtraAllow {
+			if !contains(c.Syscall.ExtraAllow, s) {
+				t.Errorf("%s: %s of the parent not allowed", tc.pType, s)
+			}
+		}
+		if len(c.Syscall.ExtraRules) < len(tc.parent.Syscall.ExtraRules) ||
+			len(c.Mounts) < len(tc.parent.Mounts) {
+			t.Errorf("%s: rules or mounts of the parent missing", tc.pType)
+		}
+	}
+}
+
+// TestBanAncestors checks that "*/name" bans name only in the work path and
+// its ancestors
+func TestBanAncestors(t *testing.T) {
+	_, _, _, _, h := GetConf("node", "/w/a", []string{"/w/a/hello.js"}, nil, nil, false)
+	for name, want := range map[string]bool{
+		"/w/a/package.json":                      true,
+		"/w/package.json":                        true,
+		"/package.json":                          true,
+		"/w/a/b/package.json":                    false,
+		"/usr/lib/node_modules/npm/package.json": false,
+	} {
+		if got := h.FileSet.IsSoftBanFile(name); got != want {
+			t.Errorf("IsSoftBanFile(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// TestBuiltinProfilesRun runs a hello program of each runtime under the ptrace
+// runner with the filter runprog builds, runtimes not installed are skipped
+func TestBuiltinProfilesRun(t *testing.T) {
+	tests := []struct {
+		pType string
+		need  string
+		// build prepares the program in dir and returns its args
+		build func(t *testing.T, dir string) []string
+	}{
+		{"node", "", func(t *testing.T, dir string) []string {
+			return []string{copyTestdata(t, dir, "hello.js")}
+		}},
+		{"go", "go", func(t *testing.T, dir string) []string {
+			out := filepath.Join(dir, "hello")
+			buildTestdata(t, "go", "build", "-o", out, "./testdata/hello")
+			return []string{out}
+		}},
+		{"rust", "rustc", func(t *testing.T, dir string) []string {
+			out := filepath.Join(dir, "hello")
+			buildTestdata(t, "rustc", "-O", "-o", out, "testdata/hello.rs")
+			return []string{out}
+		}},
+		{"java", "javac", func(t *testing.T, dir string) []string {
+			buildTestdata(t, "javac", "-d", dir, "testdata/Main.java")
+			return []string{"-cp", dir, "Main"}
+		}},
+		{"kotlin", "kotlinc", func(t *testing.T, dir string) []string {
+			out := filepath.Join(dir, "hello.jar")
+			buildTestdata(t, "kotlinc", "testdata/hello.kt", "-include-runtime", "-d", out)
+			return []string{"-jar", out}
+		}},
+		{"pypy3", "", func(t *testing.T, dir string) []string {
+			return []string{copyTestdata(t, dir, "hello.py")}
+		}},
+		{"ruby", "", func(t *testing.T, dir string) []string {
+			return []string{copyTestdata(t, dir, "hello.rb")}
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.pType, func(t *testing.T) {
+			c, _ := GetProgramConfig(tc.pType)
+			if len(c.RunCommand) > 0 {
+				if _, err := os.Stat(c.RunCommand[0]); err != nil {
+					t.Skipf("%s not installed", c.RunCommand[0])
+				}
+			}
+			if tc.need != "" {
+				if _, err := exec.LookPath(tc.need); err != nil {
+					t.Skipf("%s not installed", tc.need)
+				}
+			}
+
+			dir := t.TempDir()
+			args, allow, trace, errno, h := GetConf(tc.pType, dir, tc.build(t, dir), nil, nil, false)
+			b := libseccomp.Builder{
+				Allow:   allow,
+				Trace:   trace,
+				Errno:   errno,
+				Rules:   c.Syscall.ExtraRules,
+				Default: libseccomp.ActionKill,
+			}
+			filter, err := b.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stdin := filepath.Join(dir, "stdin")
+			if err := os.WriteFile(stdin, []byte("world\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			in, err := os.Open(stdin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer in.Close()
+			out, err := os.CreateTemp(t.TempDir(), "out")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer out.Close()
+
+			r := &ptrace.Runner{
+				Args:    args,
+				Env:     []string{"PATH=/usr/local/bin:/usr/bin:/bin"},
+				WorkDir: dir,
+				Files:   []uintptr{in.Fd(), out.Fd(), out.Fd()},
+				Limit:   runner.Limit{TimeLimit: 10e9, MemoryLimit: 1 << 30},
+				Seccomp: filter,
+				Handler: h,
+			}
+			result := r.Run(context.Background())
+			content, err := os.ReadFile(out.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Status != runner.StatusNormal {
+				t.Fatalf("unexpected result %v: %q", result, content)
+			}
+			if string(content) != "hello world\n" {
+				t.Errorf("unexpected output %q", content)
+			}
+		})
+	}
+}
+
+func copyTestdata(t *testing.T, dir, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func buildTestdata(t *testing.T, name string, args ...string) {
+	t.Helper()
+	cmd := exec.Command(name, args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%s: %v\n%s", name, err, out)
+	}
+}
@@ -0,0 +1,10 @@
+import java.util.Scanner;
+
+public class Main {
+    public static void main(String[] args) throws Exception {
+        String s = new Scanner(System.in).next();
+        Thread t = new Thread(() -> System.out.println("hello " + s));
+        t.start();
+        t.join();
+    }
+}
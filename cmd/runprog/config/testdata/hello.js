@@ -0,0 +1,2 @@
+const s = require("fs").readFileSync(0, "utf8").trim();
+setTimeout(() => console.log("hello " + s), 1);
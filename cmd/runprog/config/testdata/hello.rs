@@ -0,0 +1,8 @@
+use std::io::Read;
+
+fn main() {
+    let mut s = String::new();
+    std::io::stdin().read_to_string(&mut s).unwrap();
+    let h = std::thread::spawn(move || s.trim().to_string());
+    println!("hello {}", h.join().unwrap());
+}
@@ -0,0 +1,28 @@
+// Command hello reads a word and greets it after some work on several goroutines
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+func main() {
+	var s string
+	fmt.Scan(&s)
+
+	var wg sync.WaitGroup
+	sum := make([]int, 4)
+	for i := range sum {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1e7; j++ {
+				sum[i] += j
+			}
+		}()
+	}
+	wg.Wait()
+	time.Sleep(time.Millisecond)
+	fmt.Println("hello", s)
+}
@@ -38,3 +38,9 @@ func closeFiles(files []*os.File) {
 		}
 	}
 }
+
+// isPipe returns whether f is a pipe
+func isPipe(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeNamedPipe != 0
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/zqzqsb/sandbox/cmd/runprog/config"
+	"github.com/zqzqsb/sandbox/ptracer"
+	"github.com/zqzqsb/sandbox/runner/ptrace"
+)
+
+// learnHandler is the ptrace handler of -learn. It allows what the handler of
+// the program type would have killed and records it; soft bans of the
+// program type still apply since they are part of the type's policy.
+type learnHandler struct {
+	ptrace.Handler
+
+	mu       sync.Mutex
+	traced   map[string]bool // syscalls that reached the tracer
+	checked  map[string]bool // syscalls passed to CheckSyscall
+	allow    map[string]bool // syscalls the program type does not allow
+	read     map[string]bool
+	write    map[string]bool
+	stat     map[string]bool
+	baseline map[string]bool // syscalls traced by the program type
+}
+
+func newLearnHandler(h ptrace.Handler, trace []string) *learnHandler {
+	l := &learnHandler{
+		Handler:  h,
+		traced:   make(map[string]bool),
+		checked:  make(map[string]bool),
+		allow:    make(map[string]bool),
+		read:     make(map[string]bool),
+		write:    make(map[string]bool),
+		stat:     make(map[string]bool),
+		baseline: make(map[string]bool),
+	}
+	for _, s := range trace {
+		l.baseline[s] = true
+	}
+	return l
+}
+
+// ObserveSyscall implements ptrace.SyscallObserver
+func (l *learnHandler) ObserveSyscall(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.traced[name] = true
+}
+
+func (l *learnHandler) record(set map[string]bool, key string, action ptracer.TraceAction) ptracer.TraceAction {
+	if action != ptracer.TraceKill {
+		return action
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	set[key] = true
+	return ptracer.TraceAllow
+}
+
+func (l *learnHandler) CheckRead(fn string) ptracer.TraceAction {
+	return l.record(l.read, fn, l.Handler.CheckRead(fn))
+}
+
+func (l *learnHandler) CheckWrite(fn string) ptracer.TraceAction {
+	return l.record(l.write, fn, l.Handler.CheckWrite(fn))
+}
+
+func (l *learnHandler) CheckStat(fn string) ptracer.TraceAction {
+	return l.record(l.stat, fn, l.Handler.CheckStat(fn))
+}
+
+// CheckSyscall allows the syscalls the program type does not have, which the
+// filehandler soft bans and the filter kills outside of -learn
+func (l *learnHandler) CheckSyscall(name string) ptracer.TraceAction {
+	l.mu.Lock()
+	l.checked[name] = true
+	l.mu.Unlock()
+
+	action := l.Handler.CheckSyscall(name)
+	if action == ptracer.TraceAllow {
+		return action
+	}
+	return l.record(l.allow, name, ptracer.TraceKill)
+}
+
+// config returns the learned config: syscalls the tracer handled as file
+// access are traced, the others are allowed
+func (l *learnHandler) config(workPath string) config.ProgramConfig {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var trace []string
+	for s := range l.traced {
+		if !l.checked[s] && !l.baseline[s] {
+			trace = append(trace, s)
+		}
+	}
+	return config.LearnedConfig(keys(l.allow), trace, keys(l.read), keys(l.write), keys(l.stat), workPath)
+}
+
+func keys(m map[string]bool) []string {
+	rt := make([]string, 0, len(m))
+	for k := range m {
+		rt = append(rt, k)
+	}
+	sort.Strings(rt)
+	return rt
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"time"
 
 	"github.com/zqzqsb/sandbox/runner"
 )
@@ -39,7 +42,7 @@ func getStatus(s runner.Status) int {
 		return int(StatusNormal)
 	case runner.StatusInvalid:
 		return int(StatusInvalid)
-	case runner.StatusTimeLimitExceeded:
+	case runner.StatusTimeLimitExceeded, runner.StatusWallTimeLimitExceeded:
 		return int(StatusTLE)
 	case runner.StatusMemoryLimitExceeded:
 		return int(StatusMLE)
@@ -59,3 +62,49 @@ func debug(v ...interface{}) {
 		fmt.Fprintln(os.Stderr, v...)
 	}
 }
+
+// cgroupUsage is the resource usage collected from cgroup with -cgroup
+type cgroupUsage struct {
+	CPU    time.Duration `json:"cpu"`
+	Memory runner.Size   `json:"memory"`
+}
+
+// writeResult writes the result as uoj/run_program does:
+// "<status> <time ms> <memory KiB> <exit status>"
+// where time is the CPU time accounted by -time-policy
+func writeResult(w io.Writer, s runner.Status, rt *runner.Result) error {
+	t := rt.Time
+	if rt.TimePolicy != runner.TimePolicyUser {
+		t = rt.CPUTime
+	}
+	_, err := fmt.Fprintf(w, "%d %d %d %d\n", getStatus(s),
+		int(t.Round(time.Millisecond)/time.Millisecond), uint64(rt.Memory)>>10, rt.ExitStatus)
+	return err
+}
+
+// writeJSONResult writes the full result as a JSON object (-res-format json)
+// together with the uoj status code and the cgroup usage, if collected
+func writeJSONResult(w io.Writer, s runner.Status, rt *runner.Result, cg *cgroupUsage) error {
+	b, err := json.Marshal(rt)
+	if err != nil {
+		return err
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	if m["uojStatus"], err = json.Marshal(getStatus(s)); err != nil {
+		return err
+	}
+	if cg != nil {
+		if m["cgroup"], err = json.Marshal(cg); err != nil {
+			return err
+		}
+	}
+	b, err = json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -19,12 +19,14 @@ import (
 	"github.com/zqzqsb/sandbox/pkg/forkexec"
 	"github.com/zqzqsb/sandbox/pkg/memfd"
 	"github.com/zqzqsb/sandbox/pkg/mount"
+	"github.com/zqzqsb/sandbox/pkg/pipe"
 	"github.com/zqzqsb/sandbox/pkg/rlimit"
 	"github.com/zqzqsb/sandbox/pkg/seccomp"
 	"github.com/zqzqsb/sandbox/pkg/seccomp/libseccomp"
 	"github.com/zqzqsb/sandbox/runner"
 	"github.com/zqzqsb/sandbox/runner/ptrace"
 	"github.com/zqzqsb/sandbox/runner/ptrace/filehandler"
+	"github.com/zqzqsb/sandbox/runner/unotify"
 	"github.com/zqzqsb/sandbox/runner/unshare"
 	"golang.org/x/sys/unix"
 )
@@ -32,11 +34,17 @@ import (
 var (
 	addReadable, addWritable, addRawReadable, addRawWritable       arrayFlags
 	allowProc, unsafe, showDetails, useCGroup, memfile, cred, nucg bool
+	loopback                                                       bool
 	timeLimit, realTimeLimit, memoryLimit, outputLimit, stackLimit uint64
 	inputFileName, outputFileName, errorFileName, workPath, runt   string
 
-	pType, result string
-	args          []string
+	pType, result, resFormat, profileDir, learnFile string
+	args                                            []string
+
+	timePolicy runner.TimePolicy
+
+	// cgroup usage of the run, set by start with -cgroup
+	cgUsage *cgroupUsage
 )
 
 // container init
@@ -45,9 +53,14 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "seccomp" {
+		os.Exit(seccompMain(os.Args[2:]))
+	}
+
 	flag.Usage = printUsage
 	flag.Uint64Var(&timeLimit, "tl", 1, "Set time limit (in second)")
 	flag.Uint64Var(&realTimeLimit, "rtl", 0, "Set real time limit (in second)")
+	flag.TextVar(&timePolicy, "time-policy", runner.TimePolicyUser, "Set the CPU time compared against the time limit (user, user+system, cgroup)")
 	flag.Uint64Var(&memoryLimit, "ml", 256, "Set memory limit (in mb)")
 	flag.Uint64Var(&outputLimit, "ol", 64, "Set output limit (in mb)")
 	flag.Uint64Var(&stackLimit, "sl", 1024, "Set stack limit (in mb)")
@@ -57,6 +70,7 @@ func main() {
 	flag.StringVar(&workPath, "work-path", "", "Set the work path of the program")
 	flag.StringVar(&pType, "type", "default", "Set the program type (for some program such as python)")
 	flag.StringVar(&result, "res", "stdout", "Set the file name for output the result")
+	flag.StringVar(&resFormat, "res-format", "text", "Set the format of the result (text, json)")
 	flag.Var(&addReadable, "add-readable", "Add a readable file")
 	flag.Var(&addWritable, "add-writable", "Add a writable file")
 	flag.BoolVar(&unsafe, "unsafe", false, "Don't check dangerous syscalls")
@@ -66,9 +80,12 @@ func main() {
 	flag.Var(&addRawWritable, "add-writable-raw", "Add a writable file (don't transform to its real path)")
 	flag.BoolVar(&useCGroup, "cgroup", false, "Use cgroup to colloct resource usage")
 	flag.BoolVar(&memfile, "memfd", false, "Use memfd as exec file")
-	flag.StringVar(&runt, "runner", "ptrace", "Runner for the program (ptrace, ns, container)")
+	flag.StringVar(&runt, "runner", "ptrace", "Runner for the program (ptrace, unotify, ns, container)")
 	flag.BoolVar(&cred, "cred", false, "Generate credential for containers (uid=10000)")
 	flag.BoolVar(&nucg, "nucg", false, "don't unshare cgroup")
+	flag.BoolVar(&loopback, "loopback", false, "Unshare network with only loopback up and allow AF_UNIX / AF_INET / AF_INET6 sockets (ns, container)")
+	flag.StringVar(&profileDir, "profiles", "", "Load program types from the JSON profiles in this directory")
+	flag.StringVar(&learnFile, "learn", "", "Allow and record what the program needs beyond its type, and write it to this file as a JSON profile (ptrace only)")
 	flag.Parse()
 
 	args = flag.Args()
@@ -85,6 +102,10 @@ func main() {
 	if workPath == "" {
 		workPath, _ = os.Getwd()
 	}
+	if resFormat != "text" && resFormat != "json" {
+		debug("Invalid result format:", resFormat)
+		printUsage()
+	}
 
 	var (
 		f   *os.File
@@ -114,21 +135,29 @@ func main() {
 	}
 	debug("setupTime: ", rt.SetUpTime)
 	debug("runningTime: ", rt.RunningTime)
+	c := runner.StatusNormal
 	if err != nil {
 		debug(err)
-		c, ok := err.(runner.Status)
-		if !ok {
+		var ok bool
+		if c, ok = err.(runner.Status); !ok {
+			// Handle fatal error from trace
 			c = runner.StatusRunnerError
+			rt.Status = c
+			if rt.Error == "" {
+				rt.Error = err.Error()
+			}
 		}
-		// Handle fatal error from trace
-		fmt.Fprintf(f, "%d %d %d %d\n", getStatus(c),
-			int(rt.Time.Round(time.Millisecond)/time.Millisecond), uint64(rt.Memory)>>10, rt.ExitStatus)
-		if c == runner.StatusRunnerError {
-			os.Exit(1)
-		}
+	}
+	if resFormat == "json" {
+		err = writeJSONResult(f, c, rt, cgUsage)
 	} else {
-		fmt.Fprintf(f, "%d %d %d %d\n", 0,
-			int(rt.Time.Round(time.Millisecond)/time.Millisecond), uint64(rt.Memory)>>10, rt.ExitStatus)
+		err = writeResult(f, c, rt)
+	}
+	if err != nil {
+		debug("Failed to write result:", err)
+	}
+	if c == runner.StatusRunnerError {
+		os.Exit(1)
 	}
 }
 
@@ -148,11 +177,28 @@ func start() (*runner.Result, error) {
 		err      error
 		execFile uintptr
 		rt       runner.Result
+		learn    *learnHandler
 	)
 
+	if learnFile != "" && runt != "ptrace" {
+		return nil, fmt.Errorf("-learn requires the ptrace runner")
+	}
+	if timePolicy == runner.TimePolicyCgroup && !useCGroup {
+		return nil, fmt.Errorf("-time-policy cgroup requires -cgroup")
+	}
+	if loopback && runt != "ns" && runt != "container" {
+		return nil, fmt.Errorf("-loopback requires the ns or container runner")
+	}
+
+	if profileDir != "" {
+		if err := config.LoadProfiles(profileDir); err != nil {
+			return nil, fmt.Errorf("failed to load profiles: %v", err)
+		}
+	}
+
 	addRead := filehandler.GetExtraSet(addReadable, addRawReadable)
 	addWrite := filehandler.GetExtraSet(addWritable, addRawWritable)
-	args, allow, trace, h := config.GetConf(pType, workPath, args, addRead, addWrite, allowProc)
+	args, allow, trace, errno, h := config.GetConf(pType, workPath, args, addRead, addWrite, allowProc)
 
 	mb := mount.NewBuilder().
 		// basic exec and lib
@@ -179,6 +225,12 @@ func start() (*runner.Result, error) {
 		WithTmpfs("tmp", "size=8m,nr_inodes=4k").
 		FilterNotExist()
 
+	// runtime files outside the default mounts, e.g. /etc/java-17-openjdk
+	pc, knownType := config.GetProgramConfig(pType)
+	for _, m := range pc.Mounts {
+		mb.WithBind(m.Source, m.Target, m.Readonly)
+	}
+
 	mt, err := mb.FilterNotExist().Build()
 	if err != nil {
 		return nil, err
@@ -230,6 +282,11 @@ func start() (*runner.Result, error) {
 		defer execf.Close()
 		execFile = execf.Fd()
 		debug("memfd: ", execFile)
+
+		// execveat(fd, "", AT_EMPTY_PATH) is checked against the path of the memfd itself
+		if link, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", execFile)); err == nil {
+			h.FileSet.Readable.Add(link)
+		}
 	}
 
 	// open input / output / err files
@@ -249,6 +306,23 @@ func start() (*runner.Result, error) {
 		}
 	}
 
+	// RLIMIT_FSIZE does not apply to pipes, so stdout / stderr inherited as
+	// pipes are read through pipe.Buffer and limited by runner.OutputLimit.
+	// The output is copied to them as it is read, up to the output limit
+	var outputs []*pipe.Buffer
+	for i, std := range []*os.File{os.Stdout, os.Stderr} {
+		if files[i+1] != nil || !isPipe(std) {
+			continue
+		}
+		b, err := pipe.NewTeeBuffer(int64(outputLimit<<20), std)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create output pipe: %v", err)
+		}
+		defer b.W.Close()
+		fds[i+1] = b.W.Fd()
+		outputs = append(outputs, b)
+	}
+
 	rlims := rlimit.RLimits{
 		CPU:         timeLimit,
 		CPUHard:     realTimeLimit,
@@ -260,19 +334,8 @@ func start() (*runner.Result, error) {
 	}
 	debug("rlimit: ", rlims)
 
-	actionDefault := libseccomp.ActionKill
-	if showDetails {
-		actionDefault = libseccomp.ActionTrace
-	}
-	if runt != "ptrace" {
-		allow = append(allow, trace...)
-		trace = nil
-	}
-	builder := libseccomp.Builder{
-		Allow:   allow,
-		Trace:   trace,
-		Default: actionDefault,
-	}
+	// -learn traces everything not allowed so that it reaches the learn handler
+	builder := newBuilder(runt, allow, trace, errno, pc.Syscall.ExtraRules, loopback, showDetails || learnFile != "")
 	// do not build filter for container unsafe since seccomp is not compatible with aarch64 syscalls
 	var filter seccomp.Filter
 	if !unsafe || runt != "container" {
@@ -285,6 +348,15 @@ func start() (*runner.Result, error) {
 	limit := runner.Limit{
 		TimeLimit:   time.Duration(timeLimit) * time.Second,
 		MemoryLimit: runner.Size(memoryLimit << 20),
+		TimePolicy:  timePolicy,
+
+		WallTimeLimit: time.Duration(realTimeLimit) * time.Second,
+	}
+	if cg != nil {
+		limit.CgroupCPUUsage = func() (time.Duration, error) {
+			cpu, err := cg.CPUUsage()
+			return time.Duration(cpu), err
+		}
 	}
 
 	if runt == "container" {
@@ -308,6 +380,7 @@ func start() (*runner.Result, error) {
 			Stderr:        stderr,
 			CredGenerator: credG,
 			CloneFlags:    uintptr(cloneFlag),
+			Loopback:      loopback,
 		}
 
 		m, err := b.Build()
@@ -332,6 +405,7 @@ func start() (*runner.Result, error) {
 				RLimits:  rlims.PrepareRLimit(),
 				Seccomp:  filter,
 				SyncFunc: syncFunc,
+				Limit:    limit,
 			},
 		}
 	} else if runt == "ns" {
@@ -355,9 +429,30 @@ func start() (*runner.Result, error) {
 			SyncFunc:    syncFunc,
 			HostName:    "run_program",
 			DomainName:  "run_program",
+			Loopback:    loopback,
 		}
 	} else if runt == "ptrace" {
+		var handler ptrace.Handler = h
+		if learnFile != "" {
+			learn = newLearnHandler(h, trace)
+			handler = learn
+		}
 		r = &ptrace.Runner{
+			Args:        args,
+			Env:         []string{pathEnv},
+			ExecFile:    execFile,
+			WorkDir:     workPath,
+			RLimits:     rlims.PrepareRLimit(),
+			Limit:       limit,
+			Files:       fds,
+			Seccomp:     filter,
+			ShowDetails: showDetails,
+			Unsafe:      unsafe,
+			Handler:     handler,
+			SyncFunc:    syncFunc,
+		}
+	} else if runt == "unotify" {
+		r = &unotify.Runner{
 			Args:        args,
 			Env:         []string{pathEnv},
 			ExecFile:    execFile,
@@ -374,6 +469,9 @@ func start() (*runner.Result, error) {
 	} else {
 		return nil, fmt.Errorf("invalid runner type: %s", runt)
 	}
+	if len(outputs) > 0 {
+		r = &runner.OutputLimit{Runner: r, Buffers: outputs}
+	}
 
 	// gracefully shutdown
 	sig := make(chan os.Signal, 1)
@@ -381,7 +479,8 @@ func start() (*runner.Result, error) {
 
 	// Run tracer
 	sTime := time.Now()
-	c, cancel := context.WithTimeout(context.Background(), time.Duration(int64(realTimeLimit)*int64(time.Second)))
+	// the runners enforce the real time limit by limit.WallTimeLimit
+	c, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	s := make(chan runner.Result, 1)
@@ -407,6 +506,20 @@ func start() (*runner.Result, error) {
 
 	debug("results:", rt, err)
 
+	if learn != nil {
+		extends := ""
+		if knownType {
+			extends = pType
+		}
+		b, err := config.MarshalProfile(extends, learn.config(workPath))
+		if err != nil {
+			return nil, fmt.Errorf("learn: %v", err)
+		}
+		if err := os.WriteFile(learnFile, b, 0644); err != nil {
+			return nil, fmt.Errorf("learn: %v", err)
+		}
+	}
+
 	if useCGroup {
 		cpu, err := cg.CPUUsage()
 		if err != nil {
@@ -418,6 +531,7 @@ func start() (*runner.Result, error) {
 			return nil, fmt.Errorf("cgroup memory: %v", err)
 		}
 		debug("cgroup: cpu: ", cpu, " memory: ", memory)
+		cgUsage = &cgroupUsage{CPU: time.Duration(cpu), Memory: runner.Size(memory)}
 		rt.Time = time.Duration(cpu)
 		if memory > 0 {
 			rt.Memory = runner.Size(memory)
@@ -442,3 +556,39 @@ func (c *credGen) Get() syscall.Credential {
 		Gid: n,
 	}
 }
+
+// newBuilder creates the seccomp filter builder for the runner type, rules
+// are the argument-conditional rules of the program type and loopback adds the
+// socket syscalls and rules of -loopback
+func newBuilder(runt string, allow, trace []string, errno map[syscall.Errno][]string,
+	rules []libseccomp.Rule, loopback, showDetails bool) libseccomp.Builder {
+	if loopback {
+		allow = append(append([]string(nil), allow...), libseccomp.LoopbackSyscalls...)
+		rules = append(append([]libseccomp.Rule(nil), rules...), libseccomp.LoopbackRules()...)
+	}
+	actionDefault := libseccomp.ActionKill
+	if showDetails {
+		actionDefault = libseccomp.ActionTrace
+	}
+	var notify []string
+	switch runt {
+	case "ptrace":
+	case "unotify":
+		// the traced syscalls are handled by the user notification supervisor
+		notify, trace = trace, nil
+		if showDetails {
+			actionDefault = libseccomp.ActionNotify
+		}
+	default:
+		allow = append(allow, trace...)
+		trace = nil
+	}
+	return libseccomp.Builder{
+		Allow:   allow,
+		Trace:   trace,
+		Notify:  notify,
+		Errno:   errno,
+		Rules:   rules,
+		Default: actionDefault,
+	}
+}
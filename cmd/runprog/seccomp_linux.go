@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zqzqsb/sandbox/cmd/runprog/config"
+	"github.com/zqzqsb/sandbox/pkg/seccomp"
+	"github.com/zqzqsb/sandbox/pkg/seccomp/libseccomp"
+)
+
+// seccompMain implements the "seccomp" subcommand, which prints the seccomp
+// policy enforced for a program type and runner, or the difference between
+// two of them
+//
+//	runprog seccomp -type python3 -runner ns
+//	runprog seccomp -type default -runner ptrace -diff-type compiler
+func seccompMain(args []string) int {
+	var (
+		pType, runt, diffType, diffRunner string
+		profiles                          string
+		allowProc, details, loopback      bool
+	)
+	fs := flag.NewFlagSet("seccomp", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s seccomp [options]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&pType, "type", "default", "Set the program type")
+	fs.StringVar(&runt, "runner", "ptrace", "Runner for the program (ptrace, unotify, ns, container)")
+	fs.BoolVar(&allowProc, "allow-proc", false, "Allow fork, exec... etc.")
+	fs.BoolVar(&details, "show-trace-details", false, "Use the filter built for -show-trace-details")
+	fs.BoolVar(&loopback, "loopback", false, "Use the filter built for -loopback (ns, container)")
+	fs.StringVar(&diffType, "diff-type", "", "Compare with the policy of this program type")
+	fs.StringVar(&diffRunner, "diff-runner", "", "Compare with the policy of this runner")
+	fs.StringVar(&profiles, "profiles", "", "Load program types from the JSON profiles in this directory")
+	fs.Parse(args)
+
+	if profiles != "" {
+		if err := config.LoadProfiles(profiles); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to load profiles:", err)
+			return 1
+		}
+	}
+
+	p, err := getPolicy(pType, runt, allowProc, loopback, details)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if diffType == "" && diffRunner == "" {
+		fmt.Print(p)
+		return 0
+	}
+
+	if diffType == "" {
+		diffType = pType
+	}
+	if diffRunner == "" {
+		diffRunner = runt
+	}
+	q, err := getPolicy(diffType, diffRunner, allowProc, loopback, details)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("--- %s/%s\n+++ %s/%s\n", pType, runt, diffType, diffRunner)
+	for _, c := range seccomp.Diff(p, q) {
+		fmt.Println(c)
+	}
+	return 0
+}
+
+// getPolicy builds the filter runprog uses for the program type and runner
+// and disassembles it
+func getPolicy(pType, runt string, allowProc, loopback, details bool) (*seccomp.Policy, error) {
+	switch runt {
+	case "ptrace", "unotify", "ns", "container":
+	default:
+		return nil, fmt.Errorf("invalid runner type: %s", runt)
+	}
+	if loopback && runt != "ns" && runt != "container" {
+		return nil, fmt.Errorf("-loopback requires the ns or container runner")
+	}
+	workPath, _ := os.Getwd()
+	_, allow, trace, errno, _ := config.GetConf(pType, workPath, []string{"program"}, nil, nil, allowProc)
+
+	pc, _ := config.GetProgramConfig(pType)
+	builder := newBuilder(runt, allow, trace, errno, pc.Syscall.ExtraRules, loopback, details)
+	filter, err := builder.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create seccomp filter %v", err)
+	}
+	return libseccomp.Disassemble(filter)
+}
@@ -3,11 +3,15 @@ package container
 import (
 	"context"
 	"errors"
+	"io"
 	"os"
 	"runtime"
+	"sync"
 	"syscall"
 	"testing"
+	"time"
 
+	"github.com/zqzqsb/sandbox/pkg/mount"
 	"github.com/zqzqsb/sandbox/runner"
 )
 
@@ -87,6 +91,41 @@ func TestContainerSetCred(t *testing.T) {
 	}
 }
 
+func TestContainerSetCredRoot(t *testing.T) {
+	t.Parallel()
+	if os.Geteuid() != 0 {
+		t.Skip("root required for this test")
+	}
+	m := getEnv(t, credgen{})
+
+	p := make([]int, 2)
+	if err := syscall.Pipe2(p, syscall.O_CLOEXEC); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(p[0])
+
+	// explicitly requests the container root instead of ContainerUID / GID
+	root := 0
+	r := m.Execve(context.TODO(), ExecveParam{
+		Args:  []string{"/bin/sh", "-c", "id -u; id -g"},
+		Env:   []string{"PATH=/bin:/usr/bin"},
+		Files: []uintptr{0, uintptr(p[1]), uintptr(p[1])},
+		UID:   &root,
+		GID:   &root,
+	})
+	syscall.Close(p[1])
+	if r.Status != runner.StatusNormal {
+		t.Fatal(r.Status, r.Error)
+	}
+	out, err := io.ReadAll(os.NewFile(uintptr(p[0]), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "0\n0\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
 func TestContainerNotExists(t *testing.T) {
 	t.Parallel()
 	m := getEnv(t, nil)
@@ -115,6 +154,205 @@ func TestContainerSyncFuncFail(t *testing.T) {
 	}
 }
 
+func TestContainerConcurrentExecve(t *testing.T) {
+	t.Parallel()
+	m := getEnv(t, nil)
+
+	// the first one is killed while the second one is running
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	var killed runner.Result
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		killed = m.Execve(ctx, ExecveParam{
+			Args: []string{"/bin/sleep", "5"},
+			Env:  []string{"PATH=/bin"},
+		})
+	}()
+	r := m.Execve(context.TODO(), ExecveParam{
+		Args: []string{"/bin/sleep", "1"},
+		Env:  []string{"PATH=/bin"},
+	})
+	wg.Wait()
+	if r.Status != runner.StatusNormal {
+		t.Fatal(r.Status, r.Error)
+	}
+	if killed.Status != runner.StatusWallTimeLimitExceeded {
+		t.Fatal(killed.Status, killed.Error)
+	}
+
+	// the container is still usable
+	r = m.Execve(context.TODO(), ExecveParam{
+		Args: []string{"/bin/true"},
+		Env:  []string{"PATH=/bin"},
+	})
+	if r.Status != runner.StatusNormal {
+		t.Fatal(r.Status, r.Error)
+	}
+}
+
+func TestContainerExecveOptions(t *testing.T) {
+	t.Parallel()
+	m := getEnv(t, nil)
+
+	// the script path is relative to the per-exec work dir
+	script := "pwd\numask\necho $FOO\n"
+	if err := m.CopyIn("/w/build", makeTar(t, map[string]string{"run.sh": script})); err != nil {
+		t.Fatal(err)
+	}
+
+	p := make([]int, 2)
+	if err := syscall.Pipe2(p, syscall.O_CLOEXEC); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(p[0])
+	umask := os.FileMode(027)
+	r := m.Execve(context.TODO(), ExecveParam{
+		Args:     []string{"/bin/sh", "run.sh"},
+		Env:      []string{"PATH=/bin", "FOO=bar"},
+		Files:    []uintptr{0, uintptr(p[1]), uintptr(p[1])},
+		WorkDir:  "build",
+		Umask:    &umask,
+		ClearEnv: true,
+	})
+	syscall.Close(p[1])
+	if r.Status != runner.StatusNormal {
+		t.Fatal(r.Status, r.Error)
+	}
+	out, err := io.ReadAll(os.NewFile(uintptr(p[0]), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "/w/build\n0027\nbar\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	// uid is not mapped without CredGenerator
+	uid := 2000
+	r = m.Execve(context.TODO(), ExecveParam{
+		Args: []string{"/bin/true"},
+		Env:  []string{"PATH=/bin"},
+		UID:  &uid,
+	})
+	if r.Status != runner.StatusRunnerError {
+		t.Fatal(r.Status, r.Error)
+	}
+}
+
+func TestContainerProcesses(t *testing.T) {
+	t.Parallel()
+	b := &Builder{
+		Root:   t.TempDir(),
+		Mounts: mount.NewDefaultBuilder().WithProc().WithTmpfs("w", "").WithTmpfs("tmp", "").FilterNotExist().Mounts,
+		Stderr: os.Stderr,
+	}
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		m.Destroy()
+	})
+
+	// processes are counted through /proc, each of them lives long enough to
+	// be seen by the walk
+	r := m.Execve(context.TODO(), ExecveParam{
+		Args: []string{"/bin/sh", "-c", "sleep 0.1 | sleep 0.1"},
+		Env:  []string{PathEnv},
+	})
+	if r.Status != runner.StatusNormal {
+		t.Fatal(r.Status, r.Error)
+	}
+	if r.Processes != 3 {
+		t.Fatalf("expected 3 processes, got %d", r.Processes)
+	}
+}
+
+func TestContainerKillGracePeriod(t *testing.T) {
+	t.Parallel()
+	m := getEnv(t, nil)
+
+	run := func(script string, grace time.Duration) (runner.Result, string) {
+		p := make([]int, 2)
+		if err := syscall.Pipe2(p, syscall.O_CLOEXEC); err != nil {
+			t.Fatal(err)
+		}
+		out := os.NewFile(uintptr(p[0]), "out")
+		defer out.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(300*time.Millisecond, cancel)
+		r := m.Execve(ctx, ExecveParam{
+			Args:            []string{"/bin/sh", "-c", script},
+			Env:             []string{"PATH=/bin"},
+			Files:           []uintptr{0, uintptr(p[1])},
+			KillGracePeriod: grace,
+		})
+		syscall.Close(p[1])
+		b, _ := io.ReadAll(out)
+		return r, string(b)
+	}
+
+	// exits in the grace period after SIGTERM
+	r, out := run(`trap "echo flushed; exit 3" TERM; while :; do sleep 0.05; done`, 5*time.Second)
+	if r.Status != runner.StatusTimeLimitExceeded || r.KillPhase != runner.KillPhaseSignal || r.ExitStatus != 3 {
+		t.Fatal(r.Status, r.KillPhase, r.ExitStatus, r.Error)
+	}
+	if out != "flushed\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	// ignores SIGTERM and killed after the grace period
+	r, _ = run(`trap "" TERM; sleep 5`, 200*time.Millisecond)
+	if r.Status != runner.StatusTimeLimitExceeded || r.KillPhase != runner.KillPhaseKill || r.ExitStatus != int(syscall.SIGKILL) {
+		t.Fatal(r.Status, r.KillPhase, r.ExitStatus, r.Error)
+	}
+	if r.WallTime > 2*time.Second {
+		t.Fatal("not killed after the grace period", r.WallTime)
+	}
+
+	// exited by itself
+	r, _ = run(`true`, time.Second)
+	if r.Status != runner.StatusNormal || r.KillPhase != runner.KillPhaseNone {
+		t.Fatal(r.Status, r.KillPhase, r.Error)
+	}
+}
+
+func TestContainerLoopback(t *testing.T) {
+	t.Parallel()
+	tmpDir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Remove(tmpDir)
+	})
+	m, err := (&Builder{
+		Root:     tmpDir,
+		Stderr:   os.Stderr,
+		Loopback: true,
+	}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		m.Destroy()
+	})
+
+	param := ExecveParam{
+		Args: []string{"/bin/sh", "-c", "ip link show lo | grep -q LOOPBACK,UP"},
+		Env:  []string{PathEnv},
+	}
+	if r := m.Execve(context.TODO(), param); r.Status != runner.StatusNormal {
+		t.Fatal("lo is not up", r.Status, r.Error)
+	}
+	if r := getEnv(t, nil).Execve(context.TODO(), param); r.Status != runner.StatusNonzeroExitStatus {
+		t.Fatal("lo is up without Loopback", r.Status, r.Error)
+	}
+}
+
 func getEnv(t *testing.T, credGen CredGenerator) Environment {
 	tmpDir, err := os.MkdirTemp("", "")
 	if err != nil {
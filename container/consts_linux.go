@@ -11,6 +11,12 @@ const (
 	cmdOk
 	cmdKill
 	cmdConf
+	cmdCopyIn
+	cmdCopyOut
+	cmdStat
+	cmdReadDir
+	cmdMkdir
+	cmdRestore
 
 	initArg = "container_init"
 
@@ -21,6 +27,9 @@ const (
 	containerWD   = "/w"
 
 	containerMaxProc = 1
+
+	defaultCopyMaxSize  = 256 << 20
+	defaultCopyMaxFiles = 4096
 )
 
 var defaultSymLinks = []SymbolicLink{
@@ -5,17 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/zqzqsb/sandbox/pkg/unixsocket"
 )
 
-func (c *containerServer) handlePing() error {
-	return c.sendReply(reply{}, unixsocket.Msg{})
+func (c *containerServer) handlePing(id uint64) error {
+	return c.sendReply(id, reply{}, unixsocket.Msg{})
 }
 
-func (c *containerServer) handleConf(conf *confCmd) error {
+func (c *containerServer) handleConf(id uint64, conf *confCmd) error {
 	if conf != nil {
 		c.containerConfig = conf.Conf
 		if err := initContainer(conf.Conf); err != nil {
@@ -32,13 +31,16 @@ func (c *containerServer) handleConf(conf *confCmd) error {
 			return err
 		}
 		c.defaultEnv = env
+		if c.snapshot, err = takeSnapshot(conf.Conf); err != nil {
+			return err
+		}
 	}
-	return c.sendReply(reply{}, unixsocket.Msg{})
+	return c.sendReply(id, reply{}, unixsocket.Msg{})
 }
 
-func (c *containerServer) handleOpen(open []OpenCmd) error {
+func (c *containerServer) handleOpen(id uint64, open []OpenCmd) error {
 	if len(open) == 0 {
-		return c.sendErrorReply("open: no open parameter received")
+		return c.sendErrorReply(id, "open: no open parameter received")
 	}
 
 	// open files
@@ -47,35 +49,87 @@ func (c *containerServer) handleOpen(open []OpenCmd) error {
 	for _, o := range open {
 		outFile, err := os.OpenFile(o.Path, o.Flag, o.Perm)
 		if err != nil {
-			return c.sendErrorReply("open: %v", err)
+			return c.sendErrorReply(id, "open: %v", err)
 		}
 		fileToClose = append(fileToClose, outFile)
 		fds = append(fds, int(outFile.Fd()))
 	}
 
-	return c.sendReplyFiles(reply{}, unixsocket.Msg{Fds: fds}, fileToClose)
+	return c.sendReplyFiles(id, reply{}, unixsocket.Msg{Fds: fds}, fileToClose)
 }
 
-func (c *containerServer) handleDelete(delete *deleteCmd) error {
+func (c *containerServer) handleDelete(id uint64, delete *deleteCmd) error {
 	if delete == nil {
-		return c.sendErrorReply("delete: no parameter provided")
+		return c.sendErrorReply(id, "delete: no parameter provided")
 	}
 	if err := os.Remove(delete.Path); err != nil {
-		return c.sendErrorReply("delete: %v", err)
+		return c.sendErrorReply(id, "delete: %v", err)
 	}
-	return c.sendReply(reply{}, unixsocket.Msg{})
+	return c.sendReply(id, reply{}, unixsocket.Msg{})
 }
 
-func (c *containerServer) handleReset() error {
-	for _, m := range c.Mounts {
-		if !m.IsTmpFs() {
-			continue
-		}
-		if err := removeContents(filepath.Join("/", m.Target)); err != nil {
-			return c.sendErrorReply("reset: %v %v", m.Target, err)
+func (c *containerServer) handleReset(id uint64) error {
+	if err := c.lockIdle(); err != nil {
+		return c.sendErrorReply(id, "reset: %v", err)
+	}
+	defer c.runMu.Unlock()
+
+	if _, err := c.resetMounts(); err != nil {
+		return c.sendErrorReply(id, "reset: %v", err)
+	}
+	return c.sendReply(id, reply{}, unixsocket.Msg{})
+}
+
+func (c *containerServer) handleStat(id uint64, stat *statCmd) error {
+	if stat == nil {
+		return c.sendErrorReply(id, "stat: no parameter provided")
+	}
+	fi, err := os.Lstat(stat.Path)
+	if err != nil {
+		return c.sendErrorReply(id, "stat: %v", err)
+	}
+	return c.sendReply(id, reply{FileInfo: []FileInfo{toFileInfo(fi)}}, unixsocket.Msg{})
+}
+
+func (c *containerServer) handleReadDir(id uint64, stat *statCmd) error {
+	if stat == nil {
+		return c.sendErrorReply(id, "readdir: no parameter provided")
+	}
+	entries, err := os.ReadDir(stat.Path)
+	if err != nil {
+		return c.sendErrorReply(id, "readdir: %v", err)
+	}
+	if len(entries) > c.CopyMaxFiles {
+		return c.sendErrorReply(id, "readdir: too many entries %d > %d", len(entries), c.CopyMaxFiles)
+	}
+	ret := make([]FileInfo, 0, len(entries))
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil {
+			return c.sendErrorReply(id, "readdir: %v", err)
 		}
+		ret = append(ret, toFileInfo(fi))
+	}
+	return c.sendReply(id, reply{FileInfo: ret}, unixsocket.Msg{})
+}
+
+func (c *containerServer) handleMkdir(id uint64, mkdir *mkdirCmd) error {
+	if mkdir == nil {
+		return c.sendErrorReply(id, "mkdir: no parameter provided")
+	}
+	if err := os.MkdirAll(mkdir.Path, mkdir.Perm); err != nil {
+		return c.sendErrorReply(id, "mkdir: %v", err)
+	}
+	return c.sendReply(id, reply{}, unixsocket.Msg{})
+}
+
+func toFileInfo(fi os.FileInfo) FileInfo {
+	return FileInfo{
+		Name:    fi.Name(),
+		Size:    fi.Size(),
+		Mode:    fi.Mode(),
+		ModTime: fi.ModTime(),
 	}
-	return c.sendReply(reply{}, unixsocket.Msg{})
 }
 
 // readDotEnv attempts to read /.env file and save as default environment variables
@@ -0,0 +1,260 @@
+package container
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/zqzqsb/sandbox/pkg/unixsocket"
+)
+
+// copyLimit counts the size and the entries of a copy against the limits
+type copyLimit struct {
+	maxSize  int64
+	maxFiles int
+
+	size  int64
+	files int
+}
+
+func (c *containerServer) newCopyLimit() *copyLimit {
+	return &copyLimit{
+		maxSize:  int64(c.CopyMaxSize),
+		maxFiles: c.CopyMaxFiles,
+	}
+}
+
+func (l *copyLimit) add(size int64) error {
+	l.files++
+	if l.files > l.maxFiles {
+		return fmt.Errorf("too many files (max %d)", l.maxFiles)
+	}
+	l.size += size
+	if l.size > l.maxSize {
+		return fmt.Errorf("total size exceeds %d bytes", l.maxSize)
+	}
+	return nil
+}
+
+// handleCopyIn acks once the fd is received and extracts in the background,
+// so that the serve loop is not blocked by the host writing the stream
+func (c *containerServer) handleCopyIn(id uint64, copyIn *copyInCmd, msg unixsocket.Msg) error {
+	if copyIn == nil || len(msg.Fds) != 1 {
+		closeFds(msg.Fds)
+		return c.sendErrorReply(id, "copyin: expected parameter and a fd")
+	}
+	f := os.NewFile(uintptr(msg.Fds[0]), "copyin")
+	if err := c.sendReply(id, reply{}, unixsocket.Msg{}); err != nil {
+		f.Close()
+		return err
+	}
+	c.startCopy()
+	go func() {
+		err := extractTar(copyIn.Dst, f, c.newCopyLimit())
+		f.Close()
+		// finished before the reply, so restore right after it is not refused
+		c.finishCopy()
+		if err != nil {
+			c.sendErrorReply(id, "copyin: %v", err)
+			return
+		}
+		c.sendReply(id, reply{}, unixsocket.Msg{})
+	}()
+	return nil
+}
+
+// startCopy is called in serve before a copy goes to the background, so that
+// a later restore or reset sees it
+func (c *containerServer) startCopy() {
+	c.runMu.Lock()
+	c.copying++
+	c.runMu.Unlock()
+}
+
+func (c *containerServer) finishCopy() {
+	c.runMu.Lock()
+	c.copying--
+	c.runMu.Unlock()
+}
+
+// handleCopyOut acks once the fd is received and archives in the background
+func (c *containerServer) handleCopyOut(id uint64, copyOut *copyOutCmd, msg unixsocket.Msg) error {
+	if copyOut == nil || len(msg.Fds) != 1 {
+		closeFds(msg.Fds)
+		return c.sendErrorReply(id, "copyout: expected parameter and a fd")
+	}
+	f := os.NewFile(uintptr(msg.Fds[0]), "copyout")
+	if err := c.sendReply(id, reply{}, unixsocket.Msg{}); err != nil {
+		f.Close()
+		return err
+	}
+	c.startCopy()
+	go func() {
+		err := writeTar(f, copyOut.Paths, c.newCopyLimit())
+		f.Close()
+		c.finishCopy()
+		if err != nil {
+			c.sendErrorReply(id, "copyout: %v", err)
+			return
+		}
+		c.sendReply(id, reply{}, unixsocket.Msg{})
+	}()
+	return nil
+}
+
+// extractTar extracts regular files, directories and symbolic links of the
+// tar stream into dst. Entries must stay inside of dst, and are never written
+// through a symbolic link
+func extractTar(dst string, r io.Reader, limit *copyLimit) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(hdr.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid path %q", hdr.Name)
+		}
+		if name == "." {
+			continue
+		}
+		var size int64
+		if hdr.Typeflag == tar.TypeReg {
+			size = hdr.Size
+		}
+		if err := limit.add(size); err != nil {
+			return err
+		}
+		if err := mkdirNoSymlink(dst, filepath.Dir(name)); err != nil {
+			return err
+		}
+		target := filepath.Join(dst, name)
+		perm := hdr.FileInfo().Mode().Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := mkdirNoSymlink(dst, name); err != nil {
+				return err
+			}
+			if err := os.Chmod(target, perm); err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, perm)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unsupported type %q of %q", hdr.Typeflag, hdr.Name)
+		}
+	}
+}
+
+// mkdirNoSymlink creates the directory dir relative to root with its parents,
+// failing if any of them is not a directory
+func mkdirNoSymlink(root, dir string) error {
+	if dir == "." {
+		return nil
+	}
+	p := root
+	for _, e := range strings.Split(dir, "/") {
+		p = filepath.Join(p, e)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(p, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("%q is not a directory", p)
+		}
+	}
+	return nil
+}
+
+// writeTar writes the paths to the tar stream, directories are archived
+// recursively under their base name and symbolic links are not followed
+func writeTar(w io.Writer, paths []string, limit *copyLimit) error {
+	tw := tar.NewWriter(w)
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		base := filepath.Dir(p)
+		err := filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			var link string
+			if fi.Mode()&os.ModeSymlink != 0 {
+				if link, err = os.Readlink(path); err != nil {
+					return err
+				}
+			}
+			hdr, err := tar.FileInfoHeader(fi, link)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(base, path)
+			if err != nil {
+				return err
+			}
+			hdr.Name = filepath.ToSlash(rel)
+			if fi.IsDir() {
+				hdr.Name += "/"
+			}
+			if err := limit.add(hdr.Size); err != nil {
+				return err
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.CopyN(tw, f, hdr.Size)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
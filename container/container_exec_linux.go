@@ -2,6 +2,7 @@ package container
 
 import (
 	"fmt"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -10,40 +11,55 @@ import (
 	"github.com/zqzqsb/sandbox/runner"
 )
 
-func (c *containerServer) handleExecve(cmd *execCmd, msg unixsocket.Msg) error {
+func (c *containerServer) handleExecve(id uint64, cmdCh <-chan recvCmd, cmd *execCmd, msg unixsocket.Msg) error {
 	var (
 		files    []uintptr
 		execFile uintptr
 		cred     *syscall.Credential
 	)
 	if cmd == nil {
-		return c.sendErrorReply("handle: no parameter provided")
+		return c.sendErrorReply(id, "handle: no parameter provided")
 	}
 	if len(msg.Fds) > 0 {
 		files = intSliceToUintptr(msg.Fds)
 		// don't leak fds to child
 		closeOnExecFds(msg.Fds)
-		// release files after execve
-		defer closeFds(msg.Fds)
 	}
+	// release files if the execve is not started
+	released := false
+	defer func() {
+		if !released {
+			closeFds(msg.Fds)
+		}
+	}()
 
 	// if fexecve, then the first fd must be executable
 	if cmd.FdExec {
 		if len(files) == 0 {
-			return c.sendErrorReply("handle: expected fexecve fd")
+			return c.sendErrorReply(id, "handle: expected fexecve fd")
 		}
 		execFile = files[0]
 		files = files[1:]
 	}
 
 	var env []string
-	env = append(env, c.defaultEnv...)
+	if !cmd.ClearEnv {
+		env = append(env, c.defaultEnv...)
+	}
 	env = append(env, cmd.Env...)
 
+	workDir := c.WorkDir
+	if cmd.WorkDir != "" {
+		workDir = filepath.Join(c.WorkDir, cmd.WorkDir)
+		if filepath.IsAbs(cmd.WorkDir) {
+			workDir = cmd.WorkDir
+		}
+	}
+
 	if len(cmd.Argv) > 0 {
-		exePath, err := lookPath(cmd.Argv[0], env)
+		exePath, err := lookPath(cmd.Argv[0], workDir, env)
 		if err != nil {
-			return c.sendErrorReply("handle: %s: %v", cmd.Argv[0], err)
+			return c.sendErrorReply(id, "handle: %s: %v", cmd.Argv[0], err)
 		}
 		cmd.Argv[0] = exePath
 	}
@@ -56,10 +72,10 @@ func (c *containerServer) handleExecve(cmd *execCmd, msg unixsocket.Msg) error {
 				Gid: uint32(syscall.Getgid()),
 			},
 		}
-		if err := c.sendReply(reply{}, msg); err != nil {
+		if err := c.sendReply(id, reply{}, msg); err != nil {
 			return fmt.Errorf("syncFunc: sendReply %v", err)
 		}
-		cmd, _, err := c.recvCmd()
+		cmd, _, err := c.recvExecCmd(cmdCh)
 		if err != nil {
 			return fmt.Errorf("syncFunc: recvCmd %v", err)
 		}
@@ -69,10 +85,20 @@ func (c *containerServer) handleExecve(cmd *execCmd, msg unixsocket.Msg) error {
 		return nil
 	}
 
-	if c.Cred {
+	if c.Cred || cmd.SetUID || cmd.SetGID {
+		uid, gid := c.ContainerUID, c.ContainerGID
+		if cmd.SetUID {
+			uid = cmd.UID
+		}
+		if cmd.SetGID {
+			gid = cmd.GID
+		}
+		if !c.idMapped(uid, c.ContainerUID) || !c.idMapped(gid, c.ContainerGID) {
+			return c.sendErrorReply(id, "handle: uid %d / gid %d is not mapped", uid, gid)
+		}
 		cred = &syscall.Credential{
-			Uid:         uint32(c.ContainerUID),
-			Gid:         uint32(c.ContainerGID),
+			Uid:         uint32(uid),
+			Gid:         uint32(gid),
 			NoSetGroups: true,
 		}
 	}
@@ -88,69 +114,112 @@ func (c *containerServer) handleExecve(cmd *execCmd, msg unixsocket.Msg) error {
 		ExecFile:   execFile,
 		RLimits:    cmd.RLimits,
 		Files:      files,
-		WorkDir:    c.WorkDir,
+		WorkDir:    workDir,
 		NoNewPrivs: true,
 		DropCaps:   true,
 		SyncFunc:   syncFunc,
 		Credential: cred,
 		CTTY:       cmd.CTTY,
 		Seccomp:    seccomp,
+		Umask:      cmd.Umask,
 
 		UnshareCgroupAfterSync: c.UnshareCgroup,
 	}
 	// starts the runner, error is handled same as wait4 to make communication equal
+	c.startExec()
 	pid, err := r.Start()
+	// release files after execve, so that the other end of a pipe sees EOF
+	// once the program exits (e.g. interactive judging)
+	closeFds(msg.Fds)
+	released = true
 	if err != nil {
+		c.finishExec()
 		s := "<nil>"
 		if len(cmd.Argv) > 0 {
 			s = cmd.Argv[0]
 		}
-		c.sendErrorReply("start: %s: %v", s, err)
-		c.recvCmd()
-		return c.sendReply(reply{}, unixsocket.Msg{})
+		c.sendErrorReply(id, "start: %s: %v", s, err)
+		c.recvExecCmd(cmdCh)
+		return c.sendReply(id, reply{}, unixsocket.Msg{})
 	}
-	return c.handleExecveStarted(pid)
+	return c.handleExecveStarted(id, cmdCh, pid, cmd)
+}
+
+// idMapped reports whether id is in the mapped range starting from start
+// idMapped reports whether id is the container root or one of the IDMapSize
+// ids starting from start
+func (c *containerServer) idMapped(id, start int) bool {
+	return id == 0 || (id >= start && id < start+c.IDMapSize)
 }
 
-func (c *containerServer) handleExecveStarted(pid int) error {
+func (c *containerServer) handleExecveStarted(id uint64, cmdCh <-chan recvCmd, pid int, cmd *execCmd) error {
 	// At this point, either recv kill / send result would be happened
 	// host -> container: kill
 	// container -> host: result
 	// container -> host: done
 
-	// Let's register a wait event
-	c.waitPid <- pid
+	// the process is a session leader (setsid), so killing its process group
+	// kills its children unless they escaped with setsid. These are killed
+	// once the last running exec finishes
+	waitCh := make(chan waitPidResult, 1)
+	go func() {
+		waitCh <- waitPid(pid)
+	}()
 
 	var ret waitPidResult
 	select {
 	case <-c.done: // socket error happened
 		return c.err
 
-	case <-c.recvCh: // kill cmd received
-		syscall.Kill(-1, syscall.SIGKILL)
-		ret = <-c.waitPidResult
-		c.waitAll <- struct{}{}
+	case <-cmdCh: // kill cmd received
+		var phase runner.KillPhase
+		ret, phase = killExec(pid, waitCh, cmd.KillSignal, cmd.KillGracePeriod)
+		c.finishExec()
 
-		if err := c.sendReply(convertReply(ret), unixsocket.Msg{}); err != nil {
+		rep := convertReply(ret, cmd.Limit)
+		if rep.ExecReply != nil {
+			rep.ExecReply.KillPhase = phase
+		}
+		if err := c.sendReply(id, rep, unixsocket.Msg{}); err != nil {
 			return err
 		}
 
-	case ret = <-c.waitPidResult: // child process returned
-		syscall.Kill(-1, syscall.SIGKILL)
-		c.waitAll <- struct{}{}
+	case ret = <-waitCh: // child process returned
+		c.finishExec()
 
-		if err := c.sendReply(convertReply(ret), unixsocket.Msg{}); err != nil {
+		if err := c.sendReply(id, convertReply(ret, cmd.Limit), unixsocket.Msg{}); err != nil {
 			return err
 		}
-		if _, _, err := c.recvCmd(); err != nil { // kill cmd received
+		if _, _, err := c.recvExecCmd(cmdCh); err != nil { // kill cmd received
 			return err
 		}
 	}
-	<-c.waitAllDone
-	return c.sendReply(reply{}, unixsocket.Msg{})
+	return c.sendReply(id, reply{}, unixsocket.Msg{})
+}
+
+// killExec kills the process group of pid and waits for pid. With a grace
+// period, sig (default SIGTERM) is sent first and SIGKILL follows if pid has
+// not exited by then
+func killExec(pid int, waitCh <-chan waitPidResult, sig syscall.Signal, grace time.Duration) (waitPidResult, runner.KillPhase) {
+	if grace > 0 {
+		if sig == 0 {
+			sig = syscall.SIGTERM
+		}
+		syscall.Kill(-pid, sig)
+
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case ret := <-waitCh:
+			return ret, runner.KillPhaseSignal
+		case <-timer.C:
+		}
+	}
+	syscall.Kill(-pid, syscall.SIGKILL)
+	return <-waitCh, runner.KillPhaseKill
 }
 
-func convertReply(ret waitPidResult) reply {
+func convertReply(ret waitPidResult, limit runner.Limit) reply {
 	if ret.Err != nil {
 		return reply{
 			Error: &errorReply{
@@ -160,46 +229,43 @@ func convertReply(ret waitPidResult) reply {
 	}
 
 	waitStatus := ret.WaitStatus
-	rusage := ret.Rusage
 
-	status := runner.StatusNormal
-	userTime := time.Duration(rusage.Utime.Nano()) // ns
-	userMem := runner.Size(rusage.Maxrss << 10)    // bytes
+	var usage runner.Result
+	usage.SetRusage(&ret.Rusage)
+	rep := &execReply{
+		Time:                       usage.Time,   // ns
+		Memory:                     usage.Memory, // bytes
+		SystemTime:                 usage.SystemTime,
+		WallTime:                   ret.WallTime,
+		VoluntaryContextSwitches:   usage.VoluntaryContextSwitches,
+		InvoluntaryContextSwitches: usage.InvoluntaryContextSwitches,
+		MajorPageFaults:            usage.MajorPageFaults,
+		MinorPageFaults:            usage.MinorPageFaults,
+		ReadBytes:                  ret.ReadBytes,
+		WriteBytes:                 ret.WriteBytes,
+		Processes:                  ret.Processes,
+	}
 	switch {
 	case waitStatus.Exited():
-		exitStatus := waitStatus.ExitStatus()
-		if exitStatus != 0 {
-			status = runner.StatusNonzeroExitStatus
-		}
-		return reply{
-			ExecReply: &execReply{
-				Status:     status,
-				ExitStatus: exitStatus,
-				Time:       userTime,
-				Memory:     userMem,
-			},
+		rep.Status = runner.StatusNormal
+		rep.ExitStatus = waitStatus.ExitStatus()
+		if rep.ExitStatus != 0 {
+			rep.Status = runner.StatusNonzeroExitStatus
 		}
 
 	case waitStatus.Signaled():
 		switch waitStatus.Signal() {
 		// kill signal treats as TLE
 		case syscall.SIGXCPU, syscall.SIGKILL:
-			status = runner.StatusTimeLimitExceeded
+			rep.Status = runner.StatusTimeLimitExceeded
 		case syscall.SIGXFSZ:
-			status = runner.StatusOutputLimitExceeded
+			rep.Status = runner.StatusOutputLimitExceeded
 		case syscall.SIGSYS:
-			status = runner.StatusDisallowedSyscall
+			rep.Status = runner.StatusDisallowedSyscall
 		default:
-			status = runner.StatusSignalled
-		}
-		return reply{
-			ExecReply: &execReply{
-				ExitStatus: int(waitStatus.Signal()),
-				Status:     status,
-				Time:       userTime,
-				Memory:     userMem,
-			},
+			rep.Status = runner.StatusSignalled
 		}
+		rep.ExitStatus = int(waitStatus.Signal())
 
 	default:
 		return reply{
@@ -208,4 +274,12 @@ func convertReply(ret waitPidResult) reply {
 			},
 		}
 	}
+
+	// exceeded limits take precedence over the exit status. The cgroup is not
+	// visible inside of the container, so the host checks TimePolicyCgroup again
+	if status := limit.Check(&usage); status != runner.StatusNormal {
+		rep.Status = status
+	}
+	rep.CPUTime, rep.TimePolicy = usage.CPUTime, usage.TimePolicy
+	return reply{ExecReply: rep}
 }
@@ -11,14 +11,18 @@ import (
 	"strconv"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/zqzqsb/sandbox/pkg/unixsocket"
+	"github.com/zqzqsb/sandbox/runner"
+	"golang.org/x/sys/unix"
 )
 
 type containerServer struct {
 	socket *socket
 	containerConfig
 	defaultEnv []string
+	snapshot   *snapshot // state right after conf, used by restore
 
 	done     chan struct{}
 	err      error
@@ -27,11 +31,15 @@ type containerServer struct {
 	recvCh chan recvCmd
 	sendCh chan sendReply
 
-	waitPid       chan int
-	waitPidResult chan waitPidResult
+	// execs in flight receive their later cmds (ok / kill) by request id
+	execMu sync.Mutex
+	execs  map[uint64]chan recvCmd
 
-	waitAll     chan struct{}
-	waitAllDone chan struct{}
+	// running counts started execs, orphans are killed and reaped once it is 0
+	// copying counts copyin / copyout in flight
+	runMu   sync.Mutex
+	running int
+	copying int
 }
 
 type recvCmd struct {
@@ -46,8 +54,12 @@ type sendReply struct {
 }
 
 type waitPidResult struct {
-	WaitStatus syscall.WaitStatus
-	Rusage     syscall.Rusage
+	WaitStatus unix.WaitStatus
+	Rusage     unix.Rusage
+	WallTime   time.Duration
+	ReadBytes  runner.Size
+	WriteBytes runner.Size
+	Processes  int
 	Err        error
 }
 
@@ -100,18 +112,14 @@ func Init() (err error) {
 
 	// serve forever
 	cs := &containerServer{
-		socket:        newSocket(soc),
-		done:          make(chan struct{}),
-		sendCh:        make(chan sendReply, 1),
-		recvCh:        make(chan recvCmd, 1),
-		waitPid:       make(chan int),
-		waitAll:       make(chan struct{}),
-		waitPidResult: make(chan waitPidResult, 1),
-		waitAllDone:   make(chan struct{}, 1),
+		socket: newSocket(soc),
+		done:   make(chan struct{}),
+		sendCh: make(chan sendReply, 1),
+		recvCh: make(chan recvCmd, 1),
+		execs:  make(map[uint64]chan recvCmd),
 	}
 	go cs.sendLoop()
 	go cs.recvLoop()
-	go cs.waitLoop()
 
 	return cs.serve()
 }
@@ -160,35 +168,66 @@ func (c *containerServer) socketError(err error) {
 	})
 }
 
-func (c *containerServer) waitLoop() {
-	for {
-		select {
-		case pid := <-c.waitPid:
-			var waitStatus syscall.WaitStatus
-			var rusage syscall.Rusage
+// waitPid waits for the pid to exit and collects its resource usage. The
+// process group of pid is killed before pid is reaped so that the group id
+// can not be reused by then
+func waitPid(pid int) waitPidResult {
+	var waitStatus unix.WaitStatus
+	var rusage unix.Rusage
+	var info unix.Siginfo
+	sTime := time.Now()
+	counter := runner.CountProcesses(pid)
+
+	// wait without reaping to read /proc/<pid>/io and count the processes
+	// (best effort, needs /proc mounted)
+	for unix.Waitid(unix.P_PID, pid, &info, unix.WEXITED|unix.WNOWAIT, nil) == unix.EINTR {
+	}
+	wallTime := time.Since(sTime)
+	processes := counter.Stop()
+	readBytes, writeBytes, _ := runner.ProcIO(pid)
+	syscall.Kill(-pid, syscall.SIGKILL)
+
+	_, err := unix.Wait4(pid, &waitStatus, 0, &rusage)
+	for err == unix.EINTR {
+		_, err = unix.Wait4(pid, &waitStatus, 0, &rusage)
+	}
+	if err != nil {
+		return waitPidResult{
+			Err: err,
+		}
+	}
+	return waitPidResult{
+		WaitStatus: waitStatus,
+		Rusage:     rusage,
+		WallTime:   wallTime,
+		ReadBytes:  readBytes,
+		WriteBytes: writeBytes,
+		Processes:  processes,
+	}
+}
 
-			_, err := syscall.Wait4(pid, &waitStatus, 0, &rusage)
-			for err == syscall.EINTR {
-				_, err = syscall.Wait4(pid, &waitStatus, 0, &rusage)
-			}
-			if err != nil {
-				c.waitPidResult <- waitPidResult{
-					Err: err,
-				}
-				continue
-			}
-			c.waitPidResult <- waitPidResult{
-				WaitStatus: waitStatus,
-				Rusage:     rusage,
-			}
+// startExec must be called before an exec forks its process, so that its
+// pid is never reaped by finishExec of another exec
+func (c *containerServer) startExec() {
+	c.runMu.Lock()
+	c.running++
+	c.runMu.Unlock()
+}
 
-		case <-c.waitAll:
-			for {
-				if _, err := syscall.Wait4(-1, nil, syscall.WNOHANG, nil); err != nil && err != syscall.EINTR {
-					break
-				}
-			}
-			c.waitAllDone <- struct{}{}
+// finishExec is called after the exec process is waited. The last running
+// exec kills and reaps all the remaining processes (e.g. setsid escapers)
+func (c *containerServer) finishExec() {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	c.running--
+	if c.running > 0 {
+		return
+	}
+	syscall.Kill(-1, syscall.SIGKILL)
+	for {
+		if _, err := syscall.Wait4(-1, nil, syscall.WNOHANG, nil); err != nil && err != syscall.EINTR {
+			break
 		}
 	}
 }
@@ -199,6 +238,11 @@ func (c *containerServer) serve() error {
 		if err != nil {
 			return fmt.Errorf("serve: recvCmd %v", err)
 		}
+		// later cmds of an exec in flight
+		if cmd.Cmd == cmdOk || cmd.Cmd == cmdKill {
+			c.routeExecCmd(cmd, msg)
+			continue
+		}
 		if err := c.handleCmd(cmd, msg); err != nil {
 			return fmt.Errorf("serve: failed to execute cmd %v", err)
 		}
@@ -208,26 +252,80 @@ func (c *containerServer) serve() error {
 func (c *containerServer) handleCmd(cmd cmd, msg unixsocket.Msg) error {
 	switch cmd.Cmd {
 	case cmdPing:
-		return c.handlePing()
+		return c.handlePing(cmd.ID)
 
 	case cmdConf:
-		return c.handleConf(cmd.ConfCmd)
+		return c.handleConf(cmd.ID, cmd.ConfCmd)
 
 	case cmdOpen:
-		return c.handleOpen(cmd.OpenCmd)
+		return c.handleOpen(cmd.ID, cmd.OpenCmd)
 
 	case cmdDelete:
-		return c.handleDelete(cmd.DeleteCmd)
+		return c.handleDelete(cmd.ID, cmd.DeleteCmd)
 
 	case cmdReset:
-		return c.handleReset()
+		return c.handleReset(cmd.ID)
 
 	case cmdExecve:
-		return c.handleExecve(cmd.ExecCmd, msg)
+		c.goExecve(cmd, msg)
+		return nil
+
+	case cmdCopyIn:
+		return c.handleCopyIn(cmd.ID, cmd.CopyInCmd, msg)
+
+	case cmdCopyOut:
+		return c.handleCopyOut(cmd.ID, cmd.CopyOutCmd, msg)
+
+	case cmdStat:
+		return c.handleStat(cmd.ID, cmd.StatCmd)
+
+	case cmdReadDir:
+		return c.handleReadDir(cmd.ID, cmd.StatCmd)
+
+	case cmdMkdir:
+		return c.handleMkdir(cmd.ID, cmd.MkdirCmd)
+
+	case cmdRestore:
+		return c.handleRestore(cmd.ID)
 	}
 	return fmt.Errorf("unknown command: %v", cmd.Cmd)
 }
 
+// goExecve runs the exec in its own goroutine. Its later cmds are routed to
+// it by request id, so it is registered before serve receives the next cmd
+func (c *containerServer) goExecve(cmd cmd, msg unixsocket.Msg) {
+	ch := make(chan recvCmd, 1)
+	c.execMu.Lock()
+	c.execs[cmd.ID] = ch
+	c.execMu.Unlock()
+
+	go func() {
+		defer func() {
+			c.execMu.Lock()
+			delete(c.execs, cmd.ID)
+			c.execMu.Unlock()
+		}()
+		if err := c.handleExecve(cmd.ID, ch, cmd.ExecCmd, msg); err != nil {
+			c.socketError(fmt.Errorf("execve: %v", err))
+		}
+	}()
+}
+
+// routeExecCmd passes the cmd to its exec, cmds of finished execs are dropped
+func (c *containerServer) routeExecCmd(cmd cmd, msg unixsocket.Msg) {
+	c.execMu.Lock()
+	ch, ok := c.execs[cmd.ID]
+	c.execMu.Unlock()
+	if !ok {
+		closeFds(msg.Fds)
+		return
+	}
+	select {
+	case <-c.done:
+	case ch <- recvCmd{Cmd: cmd, Msg: msg}:
+	}
+}
+
 func initContainer(c containerConfig) error {
 	if err := initFileSystem(c); err != nil {
 		return err
@@ -241,6 +339,11 @@ func initContainer(c containerConfig) error {
 	if err := os.Chdir(c.WorkDir); err != nil {
 		return err
 	}
+	if c.Loopback {
+		if err := setLoopbackUp(); err != nil {
+			return fmt.Errorf("init: loopback %v", err)
+		}
+	}
 	if len(c.InitCommand) > 0 {
 		cm := exec.Command(c.InitCommand[0], c.InitCommand[1:]...)
 		if output, err := cm.CombinedOutput(); err != nil {
@@ -316,7 +419,19 @@ func (c *containerServer) recvCmd() (cmd, unixsocket.Msg, error) {
 	}
 }
 
-func (c *containerServer) sendReplyFiles(rep reply, msg unixsocket.Msg, fileToClose []*os.File) error {
+// recvExecCmd receives the next cmd of an exec from its channel
+func (c *containerServer) recvExecCmd(ch <-chan recvCmd) (cmd, unixsocket.Msg, error) {
+	select {
+	case <-c.done:
+		return cmd{}, unixsocket.Msg{}, c.err
+
+	case recv := <-ch:
+		return recv.Cmd, recv.Msg, nil
+	}
+}
+
+func (c *containerServer) sendReplyFiles(id uint64, rep reply, msg unixsocket.Msg, fileToClose []*os.File) error {
+	rep.ID = id
 	select {
 	case <-c.done:
 		return c.err
@@ -326,12 +441,12 @@ func (c *containerServer) sendReplyFiles(rep reply, msg unixsocket.Msg, fileToCl
 	}
 }
 
-func (c *containerServer) sendReply(rep reply, msg unixsocket.Msg) error {
-	return c.sendReplyFiles(rep, msg, nil)
+func (c *containerServer) sendReply(id uint64, rep reply, msg unixsocket.Msg) error {
+	return c.sendReplyFiles(id, rep, msg, nil)
 }
 
 // sendErrorReply sends error reply
-func (c *containerServer) sendErrorReply(ft string, v ...interface{}) error {
+func (c *containerServer) sendErrorReply(id uint64, ft string, v ...interface{}) error {
 	errRep := &errorReply{
 		Msg: fmt.Sprintf(ft, v...),
 	}
@@ -341,7 +456,7 @@ func (c *containerServer) sendErrorReply(ft string, v ...interface{}) error {
 			errRep.Errno = &errno
 		}
 	}
-	return c.sendReply(reply{Error: errRep}, unixsocket.Msg{})
+	return c.sendReply(id, reply{Error: errRep}, unixsocket.Msg{})
 }
 
 func closeOnExecAllFds() error {
@@ -373,6 +488,10 @@ func maskPath(path string) error {
 	return nil
 }
 
+// ignoreSignals catches and drops the signals rather than signal.Ignore them,
+// since ignored signals stay ignored in the programs after execve (so that
+// they could not handle the SIGTERM of a graceful kill), while caught ones
+// are reset to default
 func ignoreSignals() {
-	signal.Ignore(signalToIgnore...)
+	signal.Notify(make(chan os.Signal, 1), signalToIgnore...)
 }
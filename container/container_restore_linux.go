@@ -0,0 +1,350 @@
+package container
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"github.com/zqzqsb/sandbox/pkg/unixsocket"
+	"golang.org/x/sys/unix"
+)
+
+// SysV IPC commands that are not defined in x/sys/unix
+const (
+	msgStat = 11
+	msgInfo = 12
+	shmStat = 13
+	shmInfo = 14
+	semStat = 18
+	semInfo = 19
+)
+
+// snapshot is the container state right after conf, restore brings the
+// container back to it
+type snapshot struct {
+	mounts []mountState
+	dotEnv []byte // nil if /.env does not exist
+}
+
+// mountState identifies the file system mounted at path, an unmount,
+// remount or mount on top of it changes at least one of the fields
+type mountState struct {
+	path   string
+	dev    uint64
+	fsType int64
+	flags  int64
+}
+
+// sysvIPC enumerates one kind of SysV IPC objects through its *_INFO and
+// *_STAT commands, so that /proc/sysvipc is not needed
+type sysvIPC struct {
+	trap       uintptr // ctl syscall number
+	info, stat int
+}
+
+var (
+	sysvShm = sysvIPC{trap: unix.SYS_SHMCTL, info: shmInfo, stat: shmStat}
+	sysvSem = sysvIPC{trap: unix.SYS_SEMCTL, info: semInfo, stat: semStat}
+	sysvMsg = sysvIPC{trap: unix.SYS_MSGCTL, info: msgInfo, stat: msgStat}
+)
+
+func takeSnapshot(c containerConfig) (*snapshot, error) {
+	s := new(snapshot)
+	paths := []string{"/"}
+	for _, m := range c.Mounts {
+		paths = append(paths, filepath.Join("/", m.Target))
+	}
+	for _, p := range paths {
+		m, err := getMountState(p)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot: %v", err)
+		}
+		s.mounts = append(s.mounts, m)
+	}
+	b, err := os.ReadFile("/.env")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("snapshot: %v", err)
+	}
+	s.dotEnv = b
+	return s, nil
+}
+
+// refresh takes the state of the mount at path again
+func (s *snapshot) refresh(p string) error {
+	st, err := getMountState(p)
+	if err != nil {
+		return err
+	}
+	for i := range s.mounts {
+		if s.mounts[i].path == p {
+			s.mounts[i] = st
+		}
+	}
+	return nil
+}
+
+func getMountState(p string) (mountState, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(p, &st); err != nil {
+		return mountState{}, fmt.Errorf("stat %s: %v", p, err)
+	}
+	var sfs unix.Statfs_t
+	if err := unix.Statfs(p, &sfs); err != nil {
+		return mountState{}, fmt.Errorf("statfs %s: %v", p, err)
+	}
+	return mountState{
+		path:   p,
+		dev:    uint64(st.Dev),
+		fsType: int64(sfs.Type),
+		flags:  int64(sfs.Flags),
+	}, nil
+}
+
+// lockIdle locks runMu if no execve or copy is running, otherwise it returns
+// an error. serve handles cmds one by one, so no exec or copy starts while
+// runMu is held
+func (c *containerServer) lockIdle() error {
+	c.execMu.Lock()
+	execs := len(c.execs)
+	c.execMu.Unlock()
+	c.runMu.Lock()
+	switch {
+	case execs > 0 || c.running > 0:
+		c.runMu.Unlock()
+		return fmt.Errorf("%d execve running", max(execs, c.running))
+	case c.copying > 0:
+		c.runMu.Unlock()
+		return fmt.Errorf("%d copy running", c.copying)
+	}
+	return nil
+}
+
+func (c *containerServer) handleRestore(id uint64) error {
+	if c.snapshot == nil {
+		return c.sendErrorReply(id, "restore: container not configured")
+	}
+
+	if err := c.lockIdle(); err != nil {
+		return c.sendErrorReply(id, "restore: %v", err)
+	}
+	defer c.runMu.Unlock()
+
+	var (
+		rep RestoreReport
+		err error
+	)
+	rep.Processes = killAll()
+
+	n, err := c.resetMounts()
+	rep.Files += n
+	if err != nil {
+		return c.sendErrorReply(id, "restore: %v", err)
+	}
+	// overlays are mounted again with new devices
+	for _, m := range c.Mounts {
+		if m.IsOverlay() {
+			if err := c.snapshot.refresh(filepath.Join("/", m.Target)); err != nil {
+				return c.sendErrorReply(id, "restore: %v", err)
+			}
+		}
+	}
+
+	if c.UnshareIPC {
+		if rep.SharedMemory, err = sysvShm.removeAll(); err != nil {
+			return c.sendErrorReply(id, "restore: shm %v", err)
+		}
+		if rep.Semaphores, err = sysvSem.removeAll(); err != nil {
+			return c.sendErrorReply(id, "restore: sem %v", err)
+		}
+		if rep.MessageQueues, err = sysvMsg.removeAll(); err != nil {
+			return c.sendErrorReply(id, "restore: msg %v", err)
+		}
+		if rep.PosixMQueues, err = c.removeMQueues(); err != nil {
+			return c.sendErrorReply(id, "restore: mqueue %v", err)
+		}
+	}
+
+	if rep.DotEnv, err = c.restoreDotEnv(); err != nil {
+		return c.sendErrorReply(id, "restore: %v", err)
+	}
+	if rep.HostName, err = c.restoreHostName(); err != nil {
+		return c.sendErrorReply(id, "restore: %v", err)
+	}
+
+	if err := c.verify(); err != nil {
+		return c.sendErrorReply(id, "restore: %v", err)
+	}
+	return c.sendReply(id, reply{Restore: &rep}, unixsocket.Msg{})
+}
+
+// killAll kills all processes other than init and reaps them. Orphans of the
+// pid namespace are reparented to init, so they are all reaped before ECHILD
+func killAll() int {
+	syscall.Kill(-1, syscall.SIGKILL)
+	n := 0
+	for {
+		_, err := syscall.Wait4(-1, nil, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return n
+		}
+		n++
+	}
+}
+
+// list returns the ids of all objects in the ipc namespace
+func (s sysvIPC) list() ([]int, error) {
+	// buffer large enough for the *_info / *_ds structs on all architectures
+	var buf [512]byte
+
+	maxIdx, err := s.ctl(0, s.info, unsafe.Pointer(&buf[0]))
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for idx := 0; idx <= maxIdx; idx++ {
+		id, err := s.ctl(idx, s.stat, unsafe.Pointer(&buf[0]))
+		if err == syscall.EINVAL {
+			// empty slot
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// removeAll removes all objects in the ipc namespace and returns the number
+// of them
+func (s sysvIPC) removeAll() (int, error) {
+	ids, err := s.list()
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if _, err := s.ctl(id, unix.IPC_RMID, nil); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+func (s sysvIPC) ctl(id, cmd int, buf unsafe.Pointer) (int, error) {
+	var (
+		r     uintptr
+		errno syscall.Errno
+	)
+	// semctl(semid, semnum, cmd, arg)
+	if s.trap == unix.SYS_SEMCTL {
+		r, _, errno = syscall.Syscall6(s.trap, uintptr(id), 0, uintptr(cmd), uintptr(buf), 0, 0)
+	} else {
+		r, _, errno = syscall.Syscall(s.trap, uintptr(id), uintptr(cmd), uintptr(buf))
+	}
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
+
+// removeMQueues mounts the mqueue file system of the ipc namespace on a
+// temporary directory inside the first tmpfs mount to unlink all queues
+func (c *containerServer) removeMQueues() (int, error) {
+	var dir string
+	for _, m := range c.Mounts {
+		if m.IsTmpFs() {
+			dir = filepath.Join("/", m.Target)
+			break
+		}
+	}
+	if dir == "" {
+		return 0, nil
+	}
+	dir, err := os.MkdirTemp(dir, ".mqueue")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(dir)
+
+	if err := unix.Mount("mqueue", dir, "mqueue", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return 0, err
+	}
+	defer unix.Unmount(dir, unix.MNT_DETACH)
+
+	return removeContents(dir)
+}
+
+// restoreDotEnv writes back /.env if it was changed since conf
+func (c *containerServer) restoreDotEnv() (bool, error) {
+	b, err := os.ReadFile("/.env")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	if bytes.Equal(b, c.snapshot.dotEnv) && (b == nil) == (c.snapshot.dotEnv == nil) {
+		return false, nil
+	}
+	if c.snapshot.dotEnv == nil {
+		err = os.Remove("/.env")
+	} else {
+		err = os.WriteFile("/.env", c.snapshot.dotEnv, 0644)
+	}
+	if err != nil {
+		return true, fmt.Errorf("failed to restore /.env: %v", err)
+	}
+	return true, nil
+}
+
+// restoreHostName sets back the host / domain name if changed since conf
+func (c *containerServer) restoreHostName() (bool, error) {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return false, err
+	}
+	hostName := unix.ByteSliceToString(uts.Nodename[:])
+	domainName := unix.ByteSliceToString(uts.Domainname[:])
+	if hostName == c.HostName && domainName == c.DomainName {
+		return false, nil
+	}
+	if err := syscall.Sethostname([]byte(c.HostName)); err != nil {
+		return true, err
+	}
+	if err := syscall.Setdomainname([]byte(c.DomainName)); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// verify checks the container is back to the snapshot state
+func (c *containerServer) verify() error {
+	if err := syscall.Kill(-1, 0); err != syscall.ESRCH {
+		return errors.New("processes still alive")
+	}
+	for _, m := range c.snapshot.mounts {
+		s, err := getMountState(m.path)
+		if err != nil {
+			return err
+		}
+		if s != m {
+			return fmt.Errorf("mount %s changed", m.path)
+		}
+	}
+	if c.UnshareIPC {
+		for _, s := range []sysvIPC{sysvShm, sysvSem, sysvMsg} {
+			ids, err := s.list()
+			if err != nil {
+				return err
+			}
+			if len(ids) > 0 {
+				return fmt.Errorf("sysv ipc objects %v left", ids)
+			}
+		}
+	}
+	return nil
+}
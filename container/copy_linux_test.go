@@ -0,0 +1,181 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func makeTar(t *testing.T, files map[string]string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(name, "/") {
+			hdr.Mode, hdr.Typeflag = 0755, tar.TypeDir
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestContainerCopy(t *testing.T) {
+	t.Parallel()
+	m := getEnv(t, nil)
+
+	files := map[string]string{
+		"src/":        "",
+		"src/a.c":     "int main() {}",
+		"src/lib/b.h": "#pragma once",
+		"input.txt":   "1 2\n",
+	}
+	if err := m.CopyIn("/w", makeTar(t, files)); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := m.Stat("/w/src/a.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name != "a.c" || fi.Size != int64(len(files["src/a.c"])) || fi.IsDir() {
+		t.Fatalf("stat %+v", fi)
+	}
+	entries, err := m.ReadDir("/w/src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name != "a.c" || entries[1].Name != "lib" || !entries[1].IsDir() {
+		t.Fatalf("readdir %+v", entries)
+	}
+	if err := m.Mkdir("/w/run/out", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := m.Stat("/w/run/out"); err != nil || !fi.IsDir() {
+		t.Fatal(fi, err)
+	}
+
+	rc, err := m.CopyOut([]string{"/w/src", "/w/input.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[hdr.Name] = string(b)
+	}
+	if err := rc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if got[name] != content {
+			t.Errorf("copyout %s: got %q, expected %q", name, got[name], content)
+		}
+	}
+	if _, ok := got["src/lib/"]; !ok || len(got) != len(files)+1 {
+		t.Errorf("copyout entries %v", got)
+	}
+
+	// not exists
+	rc, err = m.CopyOut([]string{"/w/not_exists"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(rc); err == nil {
+		t.Fatal("expected copyout error")
+	}
+	rc.Close()
+	if _, err := m.Stat("/w/not_exists"); err == nil {
+		t.Fatal("expected stat error")
+	}
+}
+
+func TestContainerCopyInInvalid(t *testing.T) {
+	t.Parallel()
+	m := getEnv(t, nil)
+
+	for _, files := range []map[string]string{
+		{"../escape": "x"},
+		{"/abs": "x"},
+	} {
+		if err := m.CopyIn("/w", makeTar(t, files)); err == nil {
+			t.Errorf("expected error for %v", files)
+		}
+	}
+
+	// write through a symbolic link
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "tmp", Typeflag: tar.TypeSymlink, Linkname: "/tmp"})
+	tw.WriteHeader(&tar.Header{Name: "tmp/x", Typeflag: tar.TypeReg, Mode: 0644})
+	tw.Close()
+	if err := m.CopyIn("/w", &buf); err == nil {
+		t.Error("expected error writing through symlink")
+	}
+
+	// the environment is still usable
+	if err := m.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContainerCopyLimit(t *testing.T) {
+	t.Parallel()
+	tmpDir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Remove(tmpDir)
+	})
+	m, err := (&Builder{
+		Root:         tmpDir,
+		Stderr:       os.Stderr,
+		CopyMaxSize:  8,
+		CopyMaxFiles: 2,
+	}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		m.Destroy()
+	})
+
+	if err := m.CopyIn("/w", makeTar(t, map[string]string{"a": "123456789"})); err == nil {
+		t.Error("expected size limit error")
+	}
+	if err := m.CopyIn("/w", makeTar(t, map[string]string{"a": "1", "b": "2", "c": "3"})); err == nil {
+		t.Error("expected file count limit error")
+	}
+	if err := m.CopyIn("/w", makeTar(t, map[string]string{"a": "1", "b": "2"})); err != nil {
+		t.Error(err)
+	}
+}
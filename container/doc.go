@@ -7,10 +7,18 @@
 // with host process using unix socket with
 // oob for fd / pid and commands encoded by gob.
 //
+// # OCI runtime spec
+//
+// LoadOCISpec creates the Builder and ExecveParam from the config.json of an OCI
+// bundle, rejecting the fields that the container can not honour.
+//
 // # Protocol
 //
-// Host to container communication protocol is single threaded and always initiated by
-// the host:
+// Host to container communication protocol is always initiated by the host. Every
+// command carries a request id and the replies to it carry the same id, so that
+// multiple requests can be in flight. The container handles commands one by one,
+// except execve, which runs in its own goroutine and receives its later commands
+// (ok / kill) by the request id:
 //
 // ## ping (alive check)
 //
@@ -32,11 +40,45 @@
 // - send: path
 // - reply: "finished" / "error"
 //
-// ## reset (clean up container for later use (clear workdir / tmp)):
+// ## reset (clean up container for later use (clear workdir / tmp, drop overlay upper layers), refused while execve or copy running):
 //
 // - send:
 // - reply: "success"
 //
+// ## restore (restore container to the state right after conf, refused while execve or copy running):
+//
+// - send:
+// - reply: restore report / "error"
+//
+// Restore kills and reaps all processes, clears tmpfs mounts and overlay upper
+// layers (the overlay is unmounted and mounted again), removes SysV IPC
+// objects and POSIX message queues (new ipc namespace only), writes back /.env,
+// sets back host / domain name and then checks the mounts against the snapshot.
+//
+// ## copyin (extract tar stream into a directory inside container):
+//
+// - send: dst, read end of pipe (fd)
+// - reply: "ack" / "error"
+// - (host writes the tar stream to the pipe)
+// - reply: "finished" / "error"
+//
+// ## copyout (archive paths inside container to tar stream):
+//
+// - send: paths, write end of pipe (fd)
+// - reply: "ack" / "error"
+// - (host reads the tar stream from the pipe)
+// - reply: "finished" / "error"
+//
+// ## stat / readdir (file info of path / directory entries inside container):
+//
+// - send: path
+// - reply: file info / "error"
+//
+// ## mkdir (create directory with parents inside container):
+//
+// - send: path, perm
+// - reply: "finished" / "error"
+//
 // ## execve: (execute file inside container):
 //
 // - send: argv, env, rLimits, fds
@@ -48,5 +90,9 @@
 // - send: "kill" (as cmd) / reply: "finished"
 // - reply:
 //
+// Kill only kills the process group of the execve, with its kill signal first and
+// SIGKILL after the grace period if set. Processes that escaped it (setsid) are
+// killed once no execve is running.
+//
 // Any socket related error will cause the container exit with all process inside container
 package container
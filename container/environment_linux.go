@@ -27,7 +27,8 @@ type Builder struct {
 	// TmpRoot defines the tmp dir pattern if not nil. Temp directory will be created as container root dir
 	TmpRoot string
 
-	// Mounts defines container mount points, empty uses default mounts
+	// Mounts defines container mount points, empty uses default mounts.
+	// rootfs.Rootfs.WithBinds builds them from an unpacked OCI image or tarball
 	Mounts []mount.Mount
 
 	// SymbolicLinks defines symlinks to be created after mount file system
@@ -59,6 +60,11 @@ type Builder struct {
 	// DomainName set container domainname (default: go-sandbox)
 	DomainName string
 
+	// Loopback brings up lo in the container network namespace, so that programs
+	// can listen and connect on 127.0.0.1 / ::1 without any other network access.
+	// Pair it with libseccomp.LoopbackRules to restrict the socket families
+	Loopback bool
+
 	// InitCommand defines command that runs after the initialization of the container
 	// to do additional setups (for example, loopback network)
 	InitCommand []string
@@ -66,6 +72,17 @@ type Builder struct {
 	// ContainerUID & ContainerGID set the container uid / gid mapping
 	ContainerUID int
 	ContainerGID int
+
+	// IDMapSize maps IDMapSize uid / gid starting from ContainerUID / ContainerGID
+	// to the ones starting from the credential of CredGenerator (default: 1). The
+	// mapped ids can be used by ExecveParam.UID / GID
+	IDMapSize int
+
+	// CopyMaxSize limits the total file size of a CopyIn / CopyOut (default: 256 MiB)
+	CopyMaxSize runner.Size
+
+	// CopyMaxFiles limits the number of entries of a CopyIn / CopyOut / ReadDir (default: 4096)
+	CopyMaxFiles int
 }
 
 // SymbolicLink defines symlinks to be created after mount
@@ -86,24 +103,50 @@ type Environment interface {
 	Open([]OpenCmd) ([]*os.File, error)
 	Delete(p string) error
 	Reset() error
+	// Restore resets more than Reset and reports what it cleaned up
+	Restore() (RestoreReport, error)
 	Execve(context.Context, ExecveParam) runner.Result
 	Destroy() error
+
+	// CopyIn extracts the tar stream into the directory dst
+	CopyIn(dst string, r io.Reader) error
+	// CopyOut returns the tar stream of the paths (directories are recursive)
+	CopyOut(paths []string) (io.ReadCloser, error)
+	Stat(p string) (FileInfo, error)
+	ReadDir(p string) ([]FileInfo, error)
+	// Mkdir creates the directory with its parents
+	Mkdir(p string, perm os.FileMode) error
 }
 
 // container manages single pre-forked container environment
 type container struct {
-	process *os.Process // underlying container init pid
-	socket  *socket     // host - container communication
-	mu      sync.Mutex  // lock to avoid race condition
+	process *os.Process  // underlying container init pid
+	socket  *socket      // host - container communication
+	mu      sync.RWMutex // commands hold read lock, destroy waits for them
 
 	done     chan struct{}
 	err      error
 	doneOnce sync.Once
 
-	recvCh chan recvReply
 	sendCh chan sendCmd
+
+	// replies are routed to the pending request by id
+	reqMu   sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan recvReply
+}
+
+// request is a single command in flight with its replies
+type request struct {
+	c  *container
+	id uint64
+	ch chan recvReply
 }
 
+// replyBufferSize is the max number of unread replies of a request (execve
+// receives result and finish after sending kill)
+const replyBufferSize = 2
+
 type recvReply struct {
 	Reply reply
 	Msg   unixsocket.Msg
@@ -172,6 +215,18 @@ func (b *Builder) Build() (Environment, error) {
 	if b.DomainName != "" {
 		domainName = b.DomainName
 	}
+	copyMaxSize := runner.Size(defaultCopyMaxSize)
+	if b.CopyMaxSize > 0 {
+		copyMaxSize = b.CopyMaxSize
+	}
+	copyMaxFiles := defaultCopyMaxFiles
+	if b.CopyMaxFiles > 0 {
+		copyMaxFiles = b.CopyMaxFiles
+	}
+	idMapSize := 0
+	if b.CredGenerator != nil {
+		idMapSize = b.idMapSize()
+	}
 
 	// set configuration and check if container creation successful
 	if err = c.conf(&containerConfig{
@@ -186,7 +241,12 @@ func (b *Builder) Build() (Environment, error) {
 		Cred:          b.CredGenerator != nil,
 		ContainerUID:  b.ContainerUID,
 		ContainerGID:  b.ContainerGID,
+		IDMapSize:     idMapSize,
 		UnshareCgroup: b.CloneFlags&unix.CLONE_NEWCGROUP == unix.CLONE_NEWCGROUP,
+		UnshareIPC:    b.cloneFlags()&unix.CLONE_NEWIPC == unix.CLONE_NEWIPC,
+		Loopback:      b.Loopback,
+		CopyMaxSize:   copyMaxSize,
+		CopyMaxFiles:  copyMaxFiles,
 	}); err != nil {
 		c.Destroy()
 		return nil, err
@@ -194,6 +254,14 @@ func (b *Builder) Build() (Environment, error) {
 	return c, nil
 }
 
+// cloneFlags returns the namespaces to unshare, CloneFlags limited to UnshareFlags
+func (b *Builder) cloneFlags() uintptr {
+	if b.CloneFlags == 0 {
+		return forkexec.UnshareFlags
+	}
+	return b.CloneFlags & forkexec.UnshareFlags
+}
+
 func (b *Builder) startContainer() (*container, error) {
 	var (
 		err            error
@@ -223,13 +291,6 @@ func (b *Builder) startContainer() (*container, error) {
 		gidMap = []syscall.SysProcIDMap{{HostID: os.Getegid(), Size: 1}}
 	}
 
-	var cloneFlag uintptr
-	if b.CloneFlags == 0 {
-		cloneFlag = forkexec.UnshareFlags
-	} else {
-		cloneFlag = b.CloneFlags & forkexec.UnshareFlags
-	}
-
 	exe := "/proc/self/exe"
 	if b.ExecFile != "" {
 		exe = b.ExecFile
@@ -243,7 +304,7 @@ func (b *Builder) startContainer() (*container, error) {
 		Stderr:     b.Stderr,
 		ExtraFiles: []*os.File{outf},
 		SysProcAttr: &syscall.SysProcAttr{
-			Cloneflags:  cloneFlag,
+			Cloneflags:  b.cloneFlags(),
 			UidMappings: uidMap,
 			GidMappings: gidMap,
 			AmbientCaps: []uintptr{
@@ -260,9 +321,9 @@ func (b *Builder) startContainer() (*container, error) {
 	c := &container{
 		process: r.Process,
 		socket:  newSocket(ins),
-		recvCh:  make(chan recvReply, 1),
 		sendCh:  make(chan sendCmd, 1),
 		done:    make(chan struct{}),
+		pending: make(map[uint64]chan recvReply),
 	}
 	go c.sendLoop()
 	go c.recvLoop()
@@ -296,13 +357,33 @@ func (c *container) recvLoop() {
 			c.socketError(err)
 			return
 		}
-		c.recvCh <- recvReply{
-			Reply: reply,
-			Msg:   msg,
+		if !c.deliver(recvReply{Reply: reply, Msg: msg}) {
+			closeFds(msg.Fds)
 		}
 	}
 }
 
+// deliver passes the reply to its pending request. The lookup and the send
+// hold reqMu so that request.close, which unregisters and then drains the
+// request, never misses a reply. It reports false, and the caller drops the
+// reply, when the request already finished (e.g. canceled) or has more
+// unread replies than replyBufferSize
+func (c *container) deliver(recv recvReply) bool {
+	c.reqMu.Lock()
+	defer c.reqMu.Unlock()
+
+	ch, ok := c.pending[recv.Reply.ID]
+	if !ok {
+		return false
+	}
+	select {
+	case ch <- recv:
+		return true
+	default:
+		return false
+	}
+}
+
 func (c *container) socketError(err error) {
 	c.doneOnce.Do(func() {
 		c.err = err
@@ -310,6 +391,17 @@ func (c *container) socketError(err error) {
 	})
 }
 
+// socketErr returns the error that broke the host - container communication,
+// or nil if the container is still reachable
+func (c *container) socketErr() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
 // Destroy kill the container process (with its children)
 // if stderr enabled, collect the output as error
 func (c *container) Destroy() error {
@@ -360,7 +452,7 @@ func (b *Builder) getIDMapping(cred *syscall.Credential) ([]syscall.SysProcIDMap
 		{
 			ContainerID: cUID,
 			HostID:      int(cred.Uid),
-			Size:        1,
+			Size:        b.idMapSize(),
 		},
 	}
 
@@ -373,15 +465,54 @@ func (b *Builder) getIDMapping(cred *syscall.Credential) ([]syscall.SysProcIDMap
 		{
 			ContainerID: cGID,
 			HostID:      int(cred.Gid),
-			Size:        1,
+			Size:        b.idMapSize(),
 		},
 	}
 
 	return uidMap, gidMap
 }
 
-func (c *container) recvAckReply(name string) error {
-	reply, _, err := c.recvReply()
+func (b *Builder) idMapSize() int {
+	if b.IDMapSize > 0 {
+		return b.IDMapSize
+	}
+	return 1
+}
+
+// newRequest allocates a request id and registers it to receive replies,
+// the request must be closed after its last reply
+func (c *container) newRequest() *request {
+	c.reqMu.Lock()
+	defer c.reqMu.Unlock()
+
+	c.nextID++
+	r := &request{
+		c:  c,
+		id: c.nextID,
+		ch: make(chan recvReply, replyBufferSize),
+	}
+	c.pending[r.id] = r.ch
+	return r
+}
+
+// close unregisters the request, later replies are dropped
+func (r *request) close() {
+	r.c.reqMu.Lock()
+	delete(r.c.pending, r.id)
+	r.c.reqMu.Unlock()
+
+	for {
+		select {
+		case recv := <-r.ch:
+			closeFds(recv.Msg.Fds)
+		default:
+			return
+		}
+	}
+}
+
+func (r *request) recvAckReply(name string) error {
+	reply, _, err := r.recvReply()
 	if err != nil {
 		return fmt.Errorf("%v: recvAck %v", name, err)
 	}
@@ -390,22 +521,24 @@ func (c *container) recvAckReply(name string) error {
 	}
 	return nil
 }
-func (c *container) recvReply() (reply, unixsocket.Msg, error) {
+
+func (r *request) recvReply() (reply, unixsocket.Msg, error) {
 	select {
-	case <-c.done:
-		return reply{}, unixsocket.Msg{}, c.err
+	case <-r.c.done:
+		return reply{}, unixsocket.Msg{}, r.c.err
 
-	case recv := <-c.recvCh:
+	case recv := <-r.ch:
 		return recv.Reply, recv.Msg, nil
 	}
 }
 
-func (c *container) sendCmd(cmd cmd, msg unixsocket.Msg) error {
+func (r *request) sendCmd(cmd cmd, msg unixsocket.Msg) error {
+	cmd.ID = r.id
 	select {
-	case <-c.done:
-		return c.err
+	case <-r.c.done:
+		return r.c.err
 
-	case c.sendCh <- sendCmd{Cmd: cmd, Msg: msg}:
+	case r.c.sendCh <- sendCmd{Cmd: cmd, Msg: msg}:
 		return nil
 	}
 }
@@ -0,0 +1,113 @@
+package container
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/zqzqsb/sandbox/pkg/unixsocket"
+)
+
+// newTestContainer returns a container without a process, its replies are
+// sent from the returned socket
+func newTestContainer(t *testing.T) (*container, *socket) {
+	t.Helper()
+	ins, outs, err := unixsocket.NewSocketPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &container{
+		socket:  newSocket(ins),
+		sendCh:  make(chan sendCmd, 1),
+		done:    make(chan struct{}),
+		pending: make(map[uint64]chan recvReply),
+	}
+	go c.recvLoop()
+	t.Cleanup(func() {
+		ins.Close()
+		outs.Close()
+	})
+	return c, newSocket(outs)
+}
+
+func countFds(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+// sendFdReply sends a reply to id passing an open file
+func sendFdReply(t *testing.T, s *socket, id uint64) {
+	t.Helper()
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer f.Close()
+	if err := s.SendMsg(reply{ID: id}, unixsocket.Msg{Fds: []int{int(f.Fd())}}); err != nil {
+		t.Error(err)
+	}
+}
+
+// waitReply checks that the request still gets a reply, which means the
+// replies sent before were all handled by recvLoop
+func waitReply(t *testing.T, c *container, s *socket) {
+	t.Helper()
+	r := c.newRequest()
+	defer r.close()
+	if err := s.SendMsg(reply{ID: r.id}, unixsocket.Msg{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-r.ch:
+	case <-time.After(time.Second):
+		t.Fatal("reply not delivered")
+	}
+}
+
+// TestRequestCancelRace cancels requests (as a canceled execve does) while
+// their replies carrying fds arrive, every fd is either delivered and closed
+// by the request or dropped by recvLoop
+func TestRequestCancelRace(t *testing.T) {
+	c, s := newTestContainer(t)
+	before := countFds(t)
+
+	for i := 0; i < 500; i++ {
+		r := c.newRequest()
+		sent := make(chan struct{})
+		go func() {
+			defer close(sent)
+			sendFdReply(t, s, r.id)
+		}()
+		r.close()
+		<-sent
+	}
+
+	waitReply(t, c, s)
+
+	if after := countFds(t); after != before {
+		t.Errorf("%d fds leaked", after-before)
+	}
+}
+
+// TestRequestUnreadReplies checks that replies beyond replyBufferSize are
+// dropped instead of blocking the replies to other requests
+func TestRequestUnreadReplies(t *testing.T) {
+	c, s := newTestContainer(t)
+	before := countFds(t)
+
+	r := c.newRequest()
+	for i := 0; i < replyBufferSize+2; i++ {
+		sendFdReply(t, s, r.id)
+	}
+	waitReply(t, c, s)
+	r.close()
+
+	if after := countFds(t); after != before {
+		t.Errorf("%d fds leaked", after-before)
+	}
+}
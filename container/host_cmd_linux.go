@@ -1,6 +1,7 @@
 package container
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"syscall"
@@ -11,44 +12,64 @@ import (
 
 // Ping send ping message to container, wait for 3 second before timeout
 func (c *container) Ping() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
-	// avoid infinite wait (max 3s)
-	const pingWait = 3 * time.Second
-	c.socket.SetDeadline(time.Now().Add(pingWait))
-	defer c.socket.SetDeadline(time.Time{})
+	r := c.newRequest()
+	defer r.close()
 
 	// send ping
 	cmd := cmd{
 		Cmd: cmdPing,
 	}
-	if err := c.sendCmd(cmd, unixsocket.Msg{}); err != nil {
+	if err := r.sendCmd(cmd, unixsocket.Msg{}); err != nil {
 		return fmt.Errorf("ping: %v", err)
 	}
-	// receive no error
-	return c.recvAckReply("ping")
+
+	// avoid infinite wait (max 3s), the socket is shared with other requests
+	// so the late reply is dropped with the pending request instead
+	const pingWait = 3 * time.Second
+	select {
+	case <-c.done:
+		return fmt.Errorf("ping: recvAck %v", c.err)
+
+	case recv := <-r.ch:
+		closeFds(recv.Msg.Fds)
+		if recv.Reply.Error != nil {
+			return fmt.Errorf("ping: container error %v", recv.Reply.Error)
+		}
+		return nil
+
+	case <-time.After(pingWait):
+		return fmt.Errorf("ping: timeout after %v", pingWait)
+	}
 }
 
 // conf send configuration to container (used by builder only)
 func (c *container) conf(conf *containerConfig) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	r := c.newRequest()
+	defer r.close()
 
 	cmd := cmd{
 		Cmd:     cmdConf,
 		ConfCmd: &confCmd{Conf: *conf},
 	}
-	if err := c.sendCmd(cmd, unixsocket.Msg{}); err != nil {
+	if err := r.sendCmd(cmd, unixsocket.Msg{}); err != nil {
 		return fmt.Errorf("conf: %v", err)
 	}
-	return c.recvAckReply("conf")
+	return r.recvAckReply("conf")
 }
 
 // Open open files in container
 func (c *container) Open(p []OpenCmd) ([]*os.File, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	r := c.newRequest()
+	defer r.close()
 
 	syscall.ForkLock.RLock()
 	defer syscall.ForkLock.RUnlock()
@@ -58,10 +79,10 @@ func (c *container) Open(p []OpenCmd) ([]*os.File, error) {
 		Cmd:     cmdOpen,
 		OpenCmd: p,
 	}
-	if err := c.sendCmd(cmd, unixsocket.Msg{}); err != nil {
+	if err := r.sendCmd(cmd, unixsocket.Msg{}); err != nil {
 		return nil, fmt.Errorf("open: %v", err)
 	}
-	reply, msg, err := c.recvReply()
+	reply, msg, err := r.recvReply()
 	if err != nil {
 		return nil, fmt.Errorf("open: %v", err)
 	}
@@ -88,29 +109,137 @@ func (c *container) Open(p []OpenCmd) ([]*os.File, error) {
 
 // Delete remove file from container
 func (c *container) Delete(p string) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	r := c.newRequest()
+	defer r.close()
 
 	cmd := cmd{
 		Cmd:       cmdDelete,
 		DeleteCmd: &deleteCmd{Path: p},
 	}
-	if err := c.sendCmd(cmd, unixsocket.Msg{}); err != nil {
+	if err := r.sendCmd(cmd, unixsocket.Msg{}); err != nil {
 		return fmt.Errorf("delete: %v", err)
 	}
-	return c.recvAckReply("delete")
+	return r.recvAckReply("delete")
 }
 
-// Reset remove all from /tmp and /w
+// Reset remove all from the tmpfs mounts (/tmp and /w) and drops the upper layer
+// of the overlay mounts. It fails if an overlay is in use by a running execve
 func (c *container) Reset() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	r := c.newRequest()
+	defer r.close()
 
 	cmd := cmd{
 		Cmd: cmdReset,
 	}
-	if err := c.sendCmd(cmd, unixsocket.Msg{}); err != nil {
+	if err := r.sendCmd(cmd, unixsocket.Msg{}); err != nil {
 		return fmt.Errorf("reset: %v", err)
 	}
-	return c.recvAckReply("reset")
+	return r.recvAckReply("reset")
+}
+
+// Restore brings the container back to the state right after Build: stray
+// processes are killed, tmpfs mounts cleared, IPC objects removed and /.env,
+// host name and mounts checked. It fails if any execve is running or the
+// container can not be verified clean, in which case it should be destroyed
+func (c *container) Restore() (RestoreReport, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	r := c.newRequest()
+	defer r.close()
+
+	cmd := cmd{
+		Cmd: cmdRestore,
+	}
+	if err := r.sendCmd(cmd, unixsocket.Msg{}); err != nil {
+		return RestoreReport{}, fmt.Errorf("restore: %v", err)
+	}
+	reply, _, err := r.recvReply()
+	if err != nil {
+		return RestoreReport{}, fmt.Errorf("restore: %v", err)
+	}
+	if reply.Error != nil {
+		return RestoreReport{}, fmt.Errorf("restore: %v", reply.Error)
+	}
+	if reply.Restore == nil {
+		return RestoreReport{}, errors.New("restore: no report received")
+	}
+	return *reply.Restore, nil
+}
+
+// Stat returns the file info of p inside of the container
+func (c *container) Stat(p string) (FileInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	r := c.newRequest()
+	defer r.close()
+
+	cmd := cmd{
+		Cmd:     cmdStat,
+		StatCmd: &statCmd{Path: p},
+	}
+	if err := r.sendCmd(cmd, unixsocket.Msg{}); err != nil {
+		return FileInfo{}, fmt.Errorf("stat: %v", err)
+	}
+	reply, _, err := r.recvReply()
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("stat: %v", err)
+	}
+	if reply.Error != nil {
+		return FileInfo{}, fmt.Errorf("stat: %v", reply.Error)
+	}
+	if len(reply.FileInfo) != 1 {
+		return FileInfo{}, fmt.Errorf("stat: unexpected number of file info %v", len(reply.FileInfo))
+	}
+	return reply.FileInfo[0], nil
+}
+
+// ReadDir returns the entries of directory p inside of the container sorted by name
+func (c *container) ReadDir(p string) ([]FileInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	r := c.newRequest()
+	defer r.close()
+
+	cmd := cmd{
+		Cmd:     cmdReadDir,
+		StatCmd: &statCmd{Path: p},
+	}
+	if err := r.sendCmd(cmd, unixsocket.Msg{}); err != nil {
+		return nil, fmt.Errorf("readdir: %v", err)
+	}
+	reply, _, err := r.recvReply()
+	if err != nil {
+		return nil, fmt.Errorf("readdir: %v", err)
+	}
+	if reply.Error != nil {
+		return nil, fmt.Errorf("readdir: %v", reply.Error)
+	}
+	return reply.FileInfo, nil
+}
+
+// Mkdir creates directory p with its parents inside of the container
+func (c *container) Mkdir(p string, perm os.FileMode) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	r := c.newRequest()
+	defer r.close()
+
+	cmd := cmd{
+		Cmd:      cmdMkdir,
+		MkdirCmd: &mkdirCmd{Path: p, Perm: perm},
+	}
+	if err := r.sendCmd(cmd, unixsocket.Msg{}); err != nil {
+		return fmt.Errorf("mkdir: %v", err)
+	}
+	return r.recvAckReply("mkdir")
 }
@@ -0,0 +1,128 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/zqzqsb/sandbox/pkg/unixsocket"
+)
+
+// CopyIn extracts the tar stream r into directory dst inside of the container.
+// Regular files, directories and symbolic links are supported, and the stream is
+// limited by Builder.CopyMaxSize and Builder.CopyMaxFiles
+func (c *container) CopyIn(dst string, r io.Reader) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	req := c.newRequest()
+	defer req.close()
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("copyin: %v", err)
+	}
+	defer pw.Close()
+
+	cmd := cmd{
+		Cmd:       cmdCopyIn,
+		CopyInCmd: &copyInCmd{Dst: dst},
+	}
+	if err := req.sendCmd(cmd, unixsocket.Msg{Fds: []int{int(pr.Fd())}}); err != nil {
+		pr.Close()
+		return fmt.Errorf("copyin: %v", err)
+	}
+	// the container holds the read end once acked
+	err = req.recvAckReply("copyin")
+	pr.Close()
+	if err != nil {
+		return err
+	}
+
+	copyErr := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(pw, r)
+		pw.Close()
+		copyErr <- err
+	}()
+	// the write fails with EPIPE if the container stops reading early
+	err = req.recvAckReply("copyin")
+	if werr := <-copyErr; err == nil && werr != nil && !errors.Is(werr, syscall.EPIPE) {
+		err = fmt.Errorf("copyin: %v", werr)
+	}
+	return err
+}
+
+// CopyOut returns the tar stream of the paths inside of the container. A
+// directory is archived with its content under its base name. The stream is
+// limited by Builder.CopyMaxSize and Builder.CopyMaxFiles, and errors of the
+// container are returned by Read in place of io.EOF
+func (c *container) CopyOut(paths []string) (io.ReadCloser, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	req := c.newRequest()
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		req.close()
+		return nil, fmt.Errorf("copyout: %v", err)
+	}
+
+	cmd := cmd{
+		Cmd:        cmdCopyOut,
+		CopyOutCmd: &copyOutCmd{Paths: paths},
+	}
+	if err := req.sendCmd(cmd, unixsocket.Msg{Fds: []int{int(pw.Fd())}}); err != nil {
+		pr.Close()
+		pw.Close()
+		req.close()
+		return nil, fmt.Errorf("copyout: %v", err)
+	}
+	// the container holds the write end once acked
+	err = req.recvAckReply("copyout")
+	pw.Close()
+	if err != nil {
+		pr.Close()
+		req.close()
+		return nil, err
+	}
+	return &copyOutReader{f: pr, req: req}, nil
+}
+
+// copyOutReader reads the tar stream and receives the result of the copyout
+type copyOutReader struct {
+	f   *os.File
+	req *request
+
+	once sync.Once
+	err  error
+}
+
+func (r *copyOutReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	if err == io.EOF {
+		if werr := r.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Close closes the stream and waits for the container to finish the copyout
+func (r *copyOutReader) Close() error {
+	err := r.f.Close()
+	r.wait()
+	return err
+}
+
+func (r *copyOutReader) wait() error {
+	r.once.Do(func() {
+		r.err = r.req.recvAckReply("copyout")
+		r.req.close()
+	})
+	return r.err
+}
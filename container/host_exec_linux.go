@@ -3,6 +3,8 @@ package container
 import (
 	"context"
 	"fmt"
+	"os"
+	"syscall"
 	"time"
 
 	"github.com/zqzqsb/sandbox/pkg/rlimit"
@@ -34,16 +36,53 @@ type ExecveParam struct {
 	// CTTY specifies whether to set controlling TTY
 	CTTY bool
 
+	// WorkDir specifies the work directory of the process, relative path is
+	// relative to the container work directory (default: Builder.WorkDir)
+	WorkDir string
+
+	// UID / GID specify the credential of the process. They must be the
+	// container root (0) or mapped by Builder.IDMapSize (default (nil):
+	// Builder.ContainerUID / ContainerGID if CredGenerator is set, otherwise
+	// the container root)
+	UID *int
+	GID *int
+
+	// Umask specifies the umask of the process (default: inherited from the container init)
+	Umask *os.FileMode
+
+	// ClearEnv specifies not to merge the default environment from /.env into Env
+	ClearEnv bool
+
+	// KillSignal and KillGracePeriod specify how the process group is killed
+	// when the context is done: KillSignal (default: SIGTERM) is sent first and
+	// SIGKILL follows after KillGracePeriod. Zero KillGracePeriod sends SIGKILL
+	// right away. Result.KillPhase records which of them ended the process
+	KillSignal      syscall.Signal
+	KillGracePeriod time.Duration
+
 	// SyncFunc calls with pid just before execve (for attach the process to cgroups)
 	SyncFunc func(pid int) error
+
+	// Limit specifies the time and memory limit checked when the process exits
+	// (zero limits are not checked)
+	Limit runner.Limit
 }
 
-// Execve runs process inside container. It accepts context cancelation as time limit exceeded.
+// Execve runs process inside container. It accepts context cancelation as time limit exceeded,
+// and the deadline of the context or param.Limit.WallTimeLimit as wall time limit exceeded.
+//
+// Multiple Execve can run in the same container at the same time, each of them is
+// a separate request with its own kill and result.
 func (c *container) Execve(ctx context.Context, param ExecveParam) runner.Result {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	r := c.newRequest()
+	defer r.close()
 
 	sTime := time.Now()
+	ctx, cancel := param.Limit.WithWallTimeLimit(ctx)
+	defer cancel()
 
 	// if execve with fd, put fd at the first parameter
 	var files []int
@@ -61,16 +100,30 @@ func (c *container) Execve(ctx context.Context, param ExecveParam) runner.Result
 		Seccomp: param.Seccomp,
 		FdExec:  param.ExecFile > 0,
 		CTTY:    param.CTTY,
+		Limit:   param.Limit,
+
+		WorkDir:  param.WorkDir,
+		Umask:    param.Umask,
+		ClearEnv: param.ClearEnv,
+
+		KillSignal:      param.KillSignal,
+		KillGracePeriod: param.KillGracePeriod,
+	}
+	if param.UID != nil {
+		execCmd.SetUID, execCmd.UID = true, *param.UID
+	}
+	if param.GID != nil {
+		execCmd.SetGID, execCmd.GID = true, *param.GID
 	}
 	cm := cmd{
 		Cmd:     cmdExecve,
 		ExecCmd: execCmd,
 	}
-	if err := c.sendCmd(cm, msg); err != nil {
+	if err := r.sendCmd(cm, msg); err != nil {
 		return errResult("execve: sendCmd %v", err)
 	}
 	// sync function
-	rep, msg, err := c.recvReply()
+	rep, msg, err := r.recvReply()
 	if err != nil {
 		return errResult("execve: recvReply %v", err)
 	}
@@ -81,49 +134,63 @@ func (c *container) Execve(ctx context.Context, param ExecveParam) runner.Result
 	// if pid not received
 	if msg.Cred == nil {
 		// tell kill function to exit and sync
-		c.execveSyncKill()
+		r.execveSyncKill()
 		// tell err exec function to exit and sync
-		c.execveSyncKill()
+		r.execveSyncKill()
 		return errResult("execve: no pid received")
 	}
 	if param.SyncFunc != nil {
 		if err := param.SyncFunc(int(msg.Cred.Pid)); err != nil {
 			// tell sync function to exit and recv error
-			c.execveSyncKill()
+			r.execveSyncKill()
 			// tell kill function to exit and sync
-			c.execveSyncKill()
+			r.execveSyncKill()
 			return errResult("execve: syncfunc failed %v", err)
 		}
 	}
 	// send to syncFunc ack ok
-	if err := c.sendCmd(cmd{Cmd: cmdOk}, unixsocket.Msg{}); err != nil {
+	if err := r.sendCmd(cmd{Cmd: cmdOk}, unixsocket.Msg{}); err != nil {
 		return errResult("execve: ack failed %v", err)
 	}
 
 	// wait for done
-	return c.waitForDone(ctx, sTime)
+	return r.waitForDone(ctx, sTime, param.Limit)
 }
 
-func (c *container) waitForDone(ctx context.Context, sTime time.Time) runner.Result {
+func (r *request) waitForDone(ctx context.Context, sTime time.Time, limit runner.Limit) runner.Result {
 	mTime := time.Now()
 	select {
-	case <-c.done: // socket error
-		return convertReplyResult(reply{}, sTime, mTime, c.err)
+	case <-r.c.done: // socket error
+		return convertReplyResult(reply{}, sTime, mTime, limit, r.c.err)
 
 	case <-ctx.Done(): // cancel
-		c.sendCmd(cmd{Cmd: cmdKill}, unixsocket.Msg{}) // kill
-		reply, _, _ := c.recvReply()
-		_, _, err := c.recvReply()
-		return convertReplyResult(reply, sTime, mTime, err)
+		r.sendCmd(cmd{Cmd: cmdKill}, unixsocket.Msg{}) // kill
+		reply, _, _ := r.recvReply()
+		_, _, err := r.recvReply()
+		result := convertReplyResult(reply, sTime, mTime, limit, err)
+		if result.KillPhase == runner.KillPhaseNone {
+			return result // exited before killed
+		}
+		// killed, whatever the exit status after the kill signal is
+		switch result.Status {
+		case runner.StatusNormal, runner.StatusNonzeroExitStatus, runner.StatusSignalled:
+			result.Status = runner.StatusTimeLimitExceeded
+		}
+		// killed for the wall time limit, unless the CPU time limit is exceeded as well
+		if runner.WallTimeExceeded(ctx) && result.Status == runner.StatusTimeLimitExceeded &&
+			(limit.TimeLimit == 0 || result.CPUTime <= limit.TimeLimit) {
+			result.Status = runner.StatusWallTimeLimitExceeded
+		}
+		return result
 
-	case ret := <-c.recvCh: // result
-		c.sendCmd(cmd{Cmd: cmdKill}, unixsocket.Msg{}) // kill
-		_, _, err := c.recvReply()
-		return convertReplyResult(ret.Reply, sTime, mTime, err)
+	case ret := <-r.ch: // result
+		r.sendCmd(cmd{Cmd: cmdKill}, unixsocket.Msg{}) // kill
+		_, _, err := r.recvReply()
+		return convertReplyResult(ret.Reply, sTime, mTime, limit, err)
 	}
 }
 
-func convertReplyResult(reply reply, sTime, mTime time.Time, err error) runner.Result {
+func convertReplyResult(reply reply, sTime, mTime time.Time, limit runner.Limit, err error) runner.Result {
 	// handle potential error
 	if err != nil {
 		return runner.Result{
@@ -144,20 +211,40 @@ func convertReplyResult(reply reply, sTime, mTime time.Time, err error) runner.R
 		}
 	}
 	// emit result after all communication finish
-	return runner.Result{
+	result := runner.Result{
 		Status:      reply.ExecReply.Status,
 		ExitStatus:  reply.ExecReply.ExitStatus,
 		Time:        reply.ExecReply.Time,
+		SystemTime:  reply.ExecReply.SystemTime,
+		WallTime:    reply.ExecReply.WallTime,
+		CPUTime:     reply.ExecReply.CPUTime,
+		TimePolicy:  reply.ExecReply.TimePolicy,
 		Memory:      reply.ExecReply.Memory,
 		SetUpTime:   mTime.Sub(sTime),
 		RunningTime: time.Since(mTime),
+
+		VoluntaryContextSwitches:   reply.ExecReply.VoluntaryContextSwitches,
+		InvoluntaryContextSwitches: reply.ExecReply.InvoluntaryContextSwitches,
+		MajorPageFaults:            reply.ExecReply.MajorPageFaults,
+		MinorPageFaults:            reply.ExecReply.MinorPageFaults,
+		ReadBytes:                  reply.ExecReply.ReadBytes,
+		WriteBytes:                 reply.ExecReply.WriteBytes,
+		Processes:                  reply.ExecReply.Processes,
+		KillPhase:                  reply.ExecReply.KillPhase,
+	}
+	// the container falls back to user+system time without the cgroup
+	if limit.TimePolicy == runner.TimePolicyCgroup {
+		if status := limit.Check(&result); status != runner.StatusNormal {
+			result.Status = status
+		}
 	}
+	return result
 }
 
 // execveSyncKill will send kill and recv reply
-func (c *container) execveSyncKill() {
-	c.sendCmd(cmd{Cmd: cmdKill}, unixsocket.Msg{})
-	c.recvReply()
+func (r *request) execveSyncKill() {
+	r.sendCmd(cmd{Cmd: cmdKill}, unixsocket.Msg{})
+	r.recvReply()
 }
 
 func errResult(f string, v ...interface{}) runner.Result {
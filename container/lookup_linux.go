@@ -24,14 +24,15 @@ func findExecutable(file string) error {
 	return fs.ErrPermission
 }
 
-func lookPath(name string, env []string) (string, error) {
+// lookPath looks up name in PATH, relative paths are relative to dir
+func lookPath(name, dir string, env []string) (string, error) {
 	// don't look if abs path provided
 	if filepath.Base(name) != name {
 		return name, nil
 	}
 
-	// don't look if exist in current dir
-	if err := findExecutable(name); err == nil {
+	// don't look if exist in work dir
+	if err := findExecutable(filepath.Join(dir, name)); err == nil {
 		return name, nil
 	}
 
@@ -39,11 +40,14 @@ func lookPath(name string, env []string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	for _, dir := range path {
-		if dir == "" {
-			dir = "."
+	for _, d := range path {
+		if d == "" {
+			d = "."
+		}
+		p := filepath.Join(d, name)
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
 		}
-		p := filepath.Join(dir, name)
 		if err := findExecutable(p); err == nil {
 			return p, nil
 		}
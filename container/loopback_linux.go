@@ -0,0 +1,54 @@
+package container
+
+import (
+	"encoding/binary"
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setLoopbackUp brings up lo (always ifindex 1 in a new network namespace)
+// by a RTM_NEWLINK netlink request
+func setLoopbackUp() error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	// struct nlmsghdr + struct ifinfomsg
+	req := make([]byte, unix.SizeofNlMsghdr+unix.SizeofIfInfomsg)
+	ne := binary.NativeEndian
+	ne.PutUint32(req[0:], uint32(len(req)))
+	ne.PutUint16(req[4:], unix.RTM_NEWLINK)
+	ne.PutUint16(req[6:], unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	ne.PutUint32(req[8:], 1) // seq
+	req[16] = unix.AF_UNSPEC
+	ne.PutUint32(req[20:], 1) // ifindex
+	ne.PutUint32(req[24:], unix.IFF_UP)
+	ne.PutUint32(req[28:], unix.IFF_UP) // change mask
+
+	if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+	buf := make([]byte, unix.Getpagesize())
+	n, _, err := unix.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		if m.Header.Type != unix.NLMSG_ERROR || len(m.Data) < 4 {
+			continue
+		}
+		if errno := int32(ne.Uint32(m.Data)); errno != 0 {
+			return syscall.Errno(-errno)
+		}
+		return nil
+	}
+	return errors.New("no netlink ack received")
+}
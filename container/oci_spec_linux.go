@@ -0,0 +1,632 @@
+package container
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/zqzqsb/sandbox/pkg/mount"
+	"github.com/zqzqsb/sandbox/pkg/rlimit"
+	"github.com/zqzqsb/sandbox/pkg/rootfs"
+	"github.com/zqzqsb/sandbox/pkg/seccomp"
+	"github.com/zqzqsb/sandbox/pkg/seccomp/libseccomp"
+	"golang.org/x/sys/unix"
+)
+
+// ociSpec is the part of the OCI runtime spec config.json that maps onto a container
+// (https://github.com/opencontainers/runtime-spec/blob/main/config.md).
+// Fields not listed here are rejected when decoding
+type ociSpec struct {
+	OCIVersion  string            `json:"ociVersion"`
+	Root        *ociRoot          `json:"root"`
+	Mounts      []ociMount        `json:"mounts"`
+	Process     *ociProcess       `json:"process"`
+	Hostname    string            `json:"hostname"`
+	Domainname  string            `json:"domainname"`
+	Hooks       json.RawMessage   `json:"hooks"`
+	Annotations map[string]string `json:"annotations"`
+	Linux       *ociLinux         `json:"linux"`
+}
+
+type ociRoot struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly"`
+}
+
+type ociMount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type"`
+	Source      string   `json:"source"`
+	Options     []string `json:"options"`
+}
+
+type ociProcess struct {
+	Terminal        bool            `json:"terminal"`
+	ConsoleSize     json.RawMessage `json:"consoleSize"`
+	User            ociUser         `json:"user"`
+	Args            []string        `json:"args"`
+	Env             []string        `json:"env"`
+	Cwd             string          `json:"cwd"`
+	Capabilities    json.RawMessage `json:"capabilities"`
+	Rlimits         []ociRlimit     `json:"rlimits"`
+	NoNewPrivileges bool            `json:"noNewPrivileges"`
+	ApparmorProfile string          `json:"apparmorProfile"`
+	OOMScoreAdj     *int            `json:"oomScoreAdj"`
+	SelinuxLabel    string          `json:"selinuxLabel"`
+}
+
+type ociUser struct {
+	UID            int     `json:"uid"`
+	GID            int     `json:"gid"`
+	Umask          *uint32 `json:"umask"`
+	AdditionalGids []int   `json:"additionalGids"`
+}
+
+type ociRlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+type ociLinux struct {
+	Namespaces        []ociNamespace             `json:"namespaces"`
+	UIDMappings       []ociIDMapping             `json:"uidMappings"`
+	GIDMappings       []ociIDMapping             `json:"gidMappings"`
+	Devices           []json.RawMessage          `json:"devices"`
+	CgroupsPath       string                     `json:"cgroupsPath"`
+	Resources         map[string]json.RawMessage `json:"resources"`
+	Sysctl            map[string]string          `json:"sysctl"`
+	Seccomp           *ociSeccomp                `json:"seccomp"`
+	RootfsPropagation string                     `json:"rootfsPropagation"`
+	MaskedPaths       []string                   `json:"maskedPaths"`
+	ReadonlyPaths     []string                   `json:"readonlyPaths"`
+	MountLabel        string                     `json:"mountLabel"`
+}
+
+type ociNamespace struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+type ociIDMapping struct {
+	ContainerID int `json:"containerID"`
+	HostID      int `json:"hostID"`
+	Size        int `json:"size"`
+}
+
+type ociSeccomp struct {
+	DefaultAction   string       `json:"defaultAction"`
+	DefaultErrnoRet *uint        `json:"defaultErrnoRet"`
+	Architectures   []string     `json:"architectures"`
+	Flags           []string     `json:"flags"`
+	ListenerPath    string       `json:"listenerPath"`
+	Syscalls        []ociSyscall `json:"syscalls"`
+}
+
+type ociSyscall struct {
+	Names    []string        `json:"names"`
+	Action   string          `json:"action"`
+	ErrnoRet *uint           `json:"errnoRet"`
+	Args     []ociSeccompArg `json:"args"`
+}
+
+type ociSeccompArg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo"`
+	Op       string `json:"op"`
+}
+
+var ociNamespaces = map[string]uintptr{
+	"pid":     unix.CLONE_NEWPID,
+	"network": unix.CLONE_NEWNET,
+	"mount":   unix.CLONE_NEWNS,
+	"ipc":     unix.CLONE_NEWIPC,
+	"uts":     unix.CLONE_NEWUTS,
+	"user":    unix.CLONE_NEWUSER,
+	"cgroup":  unix.CLONE_NEWCGROUP,
+}
+
+var ociRlimits = map[string]int{
+	"RLIMIT_AS":         unix.RLIMIT_AS,
+	"RLIMIT_CORE":       unix.RLIMIT_CORE,
+	"RLIMIT_CPU":        unix.RLIMIT_CPU,
+	"RLIMIT_DATA":       unix.RLIMIT_DATA,
+	"RLIMIT_FSIZE":      unix.RLIMIT_FSIZE,
+	"RLIMIT_LOCKS":      unix.RLIMIT_LOCKS,
+	"RLIMIT_MEMLOCK":    unix.RLIMIT_MEMLOCK,
+	"RLIMIT_MSGQUEUE":   unix.RLIMIT_MSGQUEUE,
+	"RLIMIT_NICE":       unix.RLIMIT_NICE,
+	"RLIMIT_NOFILE":     unix.RLIMIT_NOFILE,
+	"RLIMIT_NPROC":      unix.RLIMIT_NPROC,
+	"RLIMIT_RSS":        unix.RLIMIT_RSS,
+	"RLIMIT_RTPRIO":     unix.RLIMIT_RTPRIO,
+	"RLIMIT_RTTIME":     unix.RLIMIT_RTTIME,
+	"RLIMIT_SIGPENDING": unix.RLIMIT_SIGPENDING,
+	"RLIMIT_STACK":      unix.RLIMIT_STACK,
+}
+
+// ociMountOptions maps mount options to mount flags, options with "=" are
+// passed as mount data and propagation options are ignored (container mounts
+// are always private)
+var ociMountOptions = map[string]uintptr{
+	"defaults":    0,
+	"ro":          unix.MS_RDONLY,
+	"rw":          0,
+	"nosuid":      unix.MS_NOSUID,
+	"suid":        0,
+	"nodev":       unix.MS_NODEV,
+	"dev":         0,
+	"noexec":      unix.MS_NOEXEC,
+	"exec":        0,
+	"noatime":     unix.MS_NOATIME,
+	"atime":       0,
+	"nodiratime":  unix.MS_NODIRATIME,
+	"diratime":    0,
+	"relatime":    unix.MS_RELATIME,
+	"strictatime": unix.MS_STRICTATIME,
+	"sync":        unix.MS_SYNCHRONOUS,
+	"async":       0,
+	"dirsync":     unix.MS_DIRSYNC,
+	"bind":        unix.MS_BIND,
+	"rbind":       unix.MS_BIND | unix.MS_REC,
+	"private":     0,
+	"rprivate":    0,
+	"slave":       0,
+	"rslave":      0,
+	"shared":      0,
+	"rshared":     0,
+	"unbindable":  0,
+	"runbindable": 0,
+}
+
+// ociDevices are bound from the host when the spec mounts /dev
+var ociDevices = []string{"null", "zero", "full", "random", "urandom"}
+
+// ociCred maps the extra uid / gid range of the spec onto a single credential
+type ociCred syscall.Credential
+
+func (c ociCred) Get() syscall.Credential {
+	return syscall.Credential(c)
+}
+
+// LoadOCISpec creates the Builder and ExecveParam from the OCI runtime spec
+// config.json in the bundle directory. Relative paths (root.path and bind
+// mount sources) are relative to the bundle.
+//
+// The mapping follows what the container is able to provide:
+//   - root must be read-only, its top-level entries are bound read-only (see
+//     rootfs.Rootfs.WithBinds) and mounts are performed after them in order
+//   - bind, tmpfs, proc, sysfs and mqueue mounts are supported; devpts and
+//     cgroup mounts are skipped. When /dev is mounted, null, zero, full,
+//     random and urandom are bound from the host
+//   - linux.namespaces must contain user and mount and must not join existing
+//     namespaces by path
+//   - linux.uidMappings / gidMappings may map container root to the current
+//     user and at most one more range, which becomes ContainerUID / ContainerGID,
+//     IDMapSize and CredGenerator
+//   - linux.readonlyPaths under /proc mount proc read-only, others must
+//     already be read-only
+//   - linux.seccomp actions are limited to allow, errno and kill; syscalls
+//     unknown on this architecture are skipped and syscalls of other
+//     architectures are killed
+//   - process.capabilities and noNewPrivileges are ignored, processes never
+//     have capabilities and always run with no_new_privs
+//
+// Other fields that can not be honoured return an error naming the field.
+// The process env replaces the default environment of the container (ClearEnv)
+func LoadOCISpec(bundle string) (*Builder, ExecveParam, error) {
+	f, err := os.Open(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil, ExecveParam{}, fmt.Errorf("container: oci spec: %v", err)
+	}
+	defer f.Close()
+
+	var s ociSpec
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&s); err != nil {
+		return nil, ExecveParam{}, fmt.Errorf("container: oci spec: %v", err)
+	}
+	b, p, err := s.convert(bundle)
+	if err != nil {
+		return nil, ExecveParam{}, fmt.Errorf("container: oci spec: %v", err)
+	}
+	return b, p, nil
+}
+
+func (s *ociSpec) convert(bundle string) (*Builder, ExecveParam, error) {
+	var p ExecveParam
+	switch {
+	case s.Root == nil:
+		return nil, p, errors.New("root: required")
+	case s.Process == nil:
+		return nil, p, errors.New("process: required")
+	case s.Linux == nil:
+		return nil, p, errors.New("linux: required")
+	case len(s.Hooks) > 0 && string(s.Hooks) != "null" && string(s.Hooks) != "{}":
+		return nil, p, errors.New("hooks: not supported")
+	}
+
+	b := &Builder{
+		HostName:   s.Hostname,
+		DomainName: s.Domainname,
+		MaskPaths:  s.Linux.MaskedPaths,
+	}
+	if err := s.Linux.convert(b); err != nil {
+		return nil, p, err
+	}
+	mounts, err := s.mounts(bundle)
+	if err != nil {
+		return nil, p, err
+	}
+	b.Mounts = mounts
+
+	if p, err = s.Process.convert(b); err != nil {
+		return nil, p, err
+	}
+	if s.Linux.Seccomp != nil {
+		if p.Seccomp, err = s.Linux.Seccomp.filter(); err != nil {
+			return nil, p, fmt.Errorf("linux.seccomp: %v", err)
+		}
+	}
+	return b, p, nil
+}
+
+// mounts returns the root binds followed by the spec mounts
+func (s *ociSpec) mounts(bundle string) ([]mount.Mount, error) {
+	if !s.Root.Readonly {
+		return nil, errors.New("root.readonly: writable root is not supported, mount tmpfs for writable paths")
+	}
+	root := s.Root.Path
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(bundle, root)
+	}
+	mb, err := (&rootfs.Rootfs{Path: root}).WithBinds(mount.NewBuilder())
+	if err != nil {
+		return nil, fmt.Errorf("root.path: %v", err)
+	}
+
+	procRO := false
+	for _, p := range s.Linux.ReadonlyPaths {
+		if p == "/proc" || strings.HasPrefix(p, "/proc/") {
+			procRO = true
+		}
+	}
+
+	dev := false
+	for i, m := range s.Mounts {
+		target := strings.TrimPrefix(path.Clean(m.Destination), "/")
+		if !path.IsAbs(m.Destination) || target == "" {
+			return nil, fmt.Errorf("mounts[%d]: invalid destination %q", i, m.Destination)
+		}
+		switch m.Type {
+		case "devpts", "cgroup", "cgroup2":
+			// no terminal and no cgroup inside of the container
+			continue
+		}
+		flags, data, err := mountOptions(m.Options)
+		if err != nil {
+			return nil, fmt.Errorf("mounts[%d]: %v", i, err)
+		}
+		typ := m.Type
+		if flags&unix.MS_BIND != 0 {
+			typ = "bind"
+		}
+
+		switch typ {
+		case "bind":
+			source := m.Source
+			if !filepath.IsAbs(source) {
+				source = filepath.Join(bundle, source)
+			}
+			mb.WithMount(mount.Mount{
+				Source: source,
+				Target: target,
+				Flags:  flags | unix.MS_BIND | unix.MS_NOSUID,
+			})
+
+		case "tmpfs":
+			mb.WithMount(mount.Mount{
+				Source: "tmpfs",
+				Target: target,
+				FsType: "tmpfs",
+				Flags:  flags | unix.MS_NOSUID,
+				Data:   data,
+			})
+
+		case "proc":
+			if target != "proc" {
+				return nil, fmt.Errorf("mounts[%d]: proc must be mounted at /proc", i)
+			}
+			mb.WithProcRW(flags&unix.MS_RDONLY == 0 && !procRO)
+
+		case "sysfs", "mqueue":
+			mb.WithMount(mount.Mount{
+				Source: typ,
+				Target: target,
+				FsType: typ,
+				Flags:  flags | unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC,
+				Data:   data,
+			})
+
+		default:
+			return nil, fmt.Errorf("mounts[%d]: type %q not supported", i, m.Type)
+		}
+		if target == "dev" {
+			dev = true
+		}
+	}
+	if dev {
+		for _, d := range ociDevices {
+			mb.WithBind("/dev/"+d, "dev/"+d, false)
+		}
+	}
+
+	for _, p := range s.Linux.ReadonlyPaths {
+		if p == "/proc" || strings.HasPrefix(p, "/proc/") {
+			continue
+		}
+		if m := coveringMount(mb.Mounts, p); m != nil && !m.IsReadOnly() {
+			return nil, fmt.Errorf("linux.readonlyPaths: %q is under writable mount /%s", p, m.Target)
+		}
+	}
+	return mb.Mounts, nil
+}
+
+// coveringMount returns the last mount containing p, nil for the read-only container root
+func coveringMount(mounts []mount.Mount, p string) *mount.Mount {
+	p = strings.TrimPrefix(path.Clean(p), "/")
+	var ret *mount.Mount
+	for i, m := range mounts {
+		if p == m.Target || strings.HasPrefix(p, m.Target+"/") {
+			if ret == nil || len(m.Target) >= len(ret.Target) {
+				ret = &mounts[i]
+			}
+		}
+	}
+	return ret
+}
+
+// mountOptions returns the mount flags and data of the mount options
+func mountOptions(options []string) (uintptr, string, error) {
+	var (
+		flags uintptr
+		data  []string
+	)
+	for _, o := range options {
+		if strings.Contains(o, "=") {
+			data = append(data, o)
+			continue
+		}
+		f, ok := ociMountOptions[o]
+		if !ok {
+			return 0, "", fmt.Errorf("option %q not supported", o)
+		}
+		flags |= f
+	}
+	return flags, strings.Join(data, ","), nil
+}
+
+func (l *ociLinux) convert(b *Builder) error {
+	switch {
+	case len(l.Devices) > 0:
+		return errors.New("linux.devices: not supported")
+	case len(l.Sysctl) > 0:
+		return errors.New("linux.sysctl: not supported")
+	case l.MountLabel != "":
+		return errors.New("linux.mountLabel: not supported")
+	}
+	for k := range l.Resources {
+		// device cgroup rules have nothing to apply to, the container does not create devices
+		if k != "devices" {
+			return fmt.Errorf("linux.resources.%s: not supported, use cgroup to limit resources", k)
+		}
+	}
+
+	for _, ns := range l.Namespaces {
+		f, ok := ociNamespaces[ns.Type]
+		if !ok {
+			return fmt.Errorf("linux.namespaces: type %q not supported", ns.Type)
+		}
+		if ns.Path != "" {
+			return fmt.Errorf("linux.namespaces: joining %s namespace %s not supported", ns.Type, ns.Path)
+		}
+		b.CloneFlags |= f
+	}
+	const required = unix.CLONE_NEWUSER | unix.CLONE_NEWNS
+	if b.CloneFlags&required != required {
+		return errors.New("linux.namespaces: user and mount namespaces are required")
+	}
+
+	uid, err := idMapping("linux.uidMappings", l.UIDMappings, os.Geteuid())
+	if err != nil {
+		return err
+	}
+	gid, err := idMapping("linux.gidMappings", l.GIDMappings, os.Getegid())
+	if err != nil {
+		return err
+	}
+	switch {
+	case uid.Size == 0 && gid.Size == 0:
+	case uid.Size != gid.Size:
+		return errors.New("linux.uidMappings: size must match linux.gidMappings")
+	default:
+		b.ContainerUID = uid.ContainerID
+		b.ContainerGID = gid.ContainerID
+		b.IDMapSize = uid.Size
+		b.CredGenerator = ociCred{Uid: uint32(uid.HostID), Gid: uint32(gid.HostID)}
+	}
+	return nil
+}
+
+// idMapping returns the extra range of the id mappings (zero size if none).
+// Container root is always mapped to the current user (hostID)
+func idMapping(field string, ms []ociIDMapping, hostID int) (ociIDMapping, error) {
+	var ret ociIDMapping
+	for _, m := range ms {
+		switch {
+		case m.ContainerID == 0 && m.Size == 1 && m.HostID == hostID:
+		case m.ContainerID == 0:
+			return ret, fmt.Errorf("%s: container root must map to the current id %d only", field, hostID)
+		case m.Size <= 0:
+			return ret, fmt.Errorf("%s: invalid size %d", field, m.Size)
+		case ret.Size > 0:
+			return ret, fmt.Errorf("%s: at most one range besides container root is supported", field)
+		default:
+			ret = m
+		}
+	}
+	return ret, nil
+}
+
+func (p *ociProcess) convert(b *Builder) (ExecveParam, error) {
+	var ret ExecveParam
+	switch {
+	case p.Terminal:
+		return ret, errors.New("process.terminal: not supported")
+	case p.ApparmorProfile != "":
+		return ret, errors.New("process.apparmorProfile: not supported")
+	case p.SelinuxLabel != "":
+		return ret, errors.New("process.selinuxLabel: not supported")
+	case p.OOMScoreAdj != nil:
+		return ret, errors.New("process.oomScoreAdj: not supported")
+	case len(p.User.AdditionalGids) > 0:
+		return ret, errors.New("process.user.additionalGids: not supported")
+	case len(p.Args) == 0:
+		return ret, errors.New("process.args: required")
+	case !path.IsAbs(p.Cwd):
+		return ret, fmt.Errorf("process.cwd: %q is not absolute", p.Cwd)
+	}
+	if !idMapped(p.User.UID, b.ContainerUID, b.IDMapSize) {
+		return ret, fmt.Errorf("process.user.uid: %d is not mapped", p.User.UID)
+	}
+	if !idMapped(p.User.GID, b.ContainerGID, b.IDMapSize) {
+		return ret, fmt.Errorf("process.user.gid: %d is not mapped", p.User.GID)
+	}
+
+	b.WorkDir = p.Cwd
+	ret = ExecveParam{
+		Args:     p.Args,
+		Env:      p.Env,
+		WorkDir:  p.Cwd,
+		UID:      &p.User.UID,
+		GID:      &p.User.GID,
+		ClearEnv: true,
+	}
+	if p.User.Umask != nil {
+		umask := os.FileMode(*p.User.Umask)
+		ret.Umask = &umask
+	}
+	for _, r := range p.Rlimits {
+		res, ok := ociRlimits[r.Type]
+		if !ok {
+			return ret, fmt.Errorf("process.rlimits: type %q not supported", r.Type)
+		}
+		ret.RLimits = append(ret.RLimits, rlimit.RLimit{
+			Res:  res,
+			Rlim: syscall.Rlimit{Cur: r.Soft, Max: r.Hard},
+		})
+	}
+	return ret, nil
+}
+
+// idMapped returns whether id is container root or in the extra range
+func idMapped(id, start, size int) bool {
+	return id == 0 || (size > 0 && id >= start && id < start+size)
+}
+
+// filter builds the seccomp filter, unconditional allow rules are compiled
+// into Builder.Allow and the rest into Builder.Rules
+func (s *ociSeccomp) filter() (seccomp.Filter, error) {
+	switch {
+	case len(s.Flags) > 0:
+		return nil, fmt.Errorf("flags %v not supported", s.Flags)
+	case s.ListenerPath != "":
+		return nil, errors.New("listenerPath: not supported")
+	}
+	def, err := seccompAction(s.DefaultAction, s.DefaultErrnoRet)
+	if err != nil {
+		return nil, fmt.Errorf("defaultAction: %v", err)
+	}
+	b := libseccomp.Builder{Default: def}
+	allowed := make(map[string]bool)
+	for i, sc := range s.Syscalls {
+		action, err := seccompAction(sc.Action, sc.ErrnoRet)
+		if err != nil {
+			return nil, fmt.Errorf("syscalls[%d]: %v", i, err)
+		}
+		conds, err := seccompConditions(sc.Args)
+		if err != nil {
+			return nil, fmt.Errorf("syscalls[%d]: %v", i, err)
+		}
+		for _, name := range sc.Names {
+			if _, err := libseccomp.ToSyscallNo(name); err != nil {
+				// not available on this architecture
+				continue
+			}
+			if action == libseccomp.ActionAllow && len(conds) == 0 {
+				if !allowed[name] {
+					allowed[name] = true
+					b.Allow = append(b.Allow, name)
+				}
+				continue
+			}
+			b.Rules = append(b.Rules, libseccomp.Rule{Name: name, Action: action, Conditions: conds})
+		}
+	}
+	return b.Build()
+}
+
+func seccompAction(action string, errnoRet *uint) (libseccomp.Action, error) {
+	switch action {
+	case "SCMP_ACT_ALLOW":
+		return libseccomp.ActionAllow, nil
+	case "SCMP_ACT_ERRNO":
+		errno := uint(syscall.EPERM)
+		if errnoRet != nil {
+			errno = *errnoRet
+		}
+		if errno == 0 || errno > 4095 {
+			return 0, fmt.Errorf("invalid errno %d", errno)
+		}
+		return libseccomp.ActionErrno.WithReturnCode(int16(errno)), nil
+	case "SCMP_ACT_KILL", "SCMP_ACT_KILL_THREAD", "SCMP_ACT_KILL_PROCESS":
+		return libseccomp.ActionKill, nil
+	default:
+		return 0, fmt.Errorf("action %q not supported", action)
+	}
+}
+
+func seccompConditions(args []ociSeccompArg) ([]libseccomp.Condition, error) {
+	var ret []libseccomp.Condition
+	for _, a := range args {
+		c := libseccomp.Condition{Arg: a.Index, Value: a.Value}
+		switch a.Op {
+		case "SCMP_CMP_NE":
+			c.Op = libseccomp.OpNotEqual
+		case "SCMP_CMP_LT":
+			c.Op = libseccomp.OpLess
+		case "SCMP_CMP_LE":
+			c.Op = libseccomp.OpLessEqual
+		case "SCMP_CMP_EQ":
+			c.Op = libseccomp.OpEqual
+		case "SCMP_CMP_GE":
+			c.Op = libseccomp.OpGreaterEqual
+		case "SCMP_CMP_GT":
+			c.Op = libseccomp.OpGreater
+		case "SCMP_CMP_MASKED_EQ":
+			c.Op = libseccomp.OpMaskedEqual
+			c.Mask, c.Value = a.Value, a.ValueTwo
+		default:
+			return nil, fmt.Errorf("op %q not supported", a.Op)
+		}
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
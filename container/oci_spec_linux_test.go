@@ -0,0 +1,219 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/zqzqsb/sandbox/runner"
+	"golang.org/x/sys/unix"
+)
+
+// ociTestSpec is a minimal spec similar to `runc spec --rootless`, the rootfs
+// in the bundle binds the host /usr, /bin and /lib
+func ociTestSpec() map[string]any {
+	mounts := []map[string]any{
+		{"destination": "/proc", "type": "proc", "source": "proc"},
+		{"destination": "/dev", "type": "tmpfs", "source": "tmpfs", "options": []string{"nosuid", "strictatime", "mode=755", "size=65536k"}},
+		{"destination": "/dev/pts", "type": "devpts", "source": "devpts", "options": []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620"}},
+		{"destination": "/tmp", "type": "tmpfs", "source": "tmpfs", "options": []string{"nosuid", "nodev"}},
+		{"destination": "/sys/fs/cgroup", "type": "cgroup", "source": "cgroup", "options": []string{"nosuid", "noexec", "nodev", "relatime", "ro"}},
+	}
+	for _, d := range []string{"/usr", "/bin", "/lib", "/lib64"} {
+		if _, err := os.Stat(d); err == nil {
+			mounts = append(mounts, map[string]any{"destination": d, "type": "bind", "source": d, "options": []string{"rbind", "ro"}})
+		}
+	}
+	return map[string]any{
+		"ociVersion": "1.0.2",
+		"root":       map[string]any{"path": "rootfs", "readonly": true},
+		"hostname":   "oci",
+		"process": map[string]any{
+			"terminal": false,
+			"user":     map[string]any{"uid": 0, "gid": 0, "umask": 022},
+			"args":     []string{"/bin/sh", "-c", "echo $FOO; pwd; hostname; umask; ulimit -n; test -c /dev/null"},
+			"env":      []string{"PATH=/usr/bin:/bin", "FOO=bar"},
+			"cwd":      "/tmp",
+			"capabilities": map[string]any{
+				"bounding": []string{"CAP_KILL"},
+			},
+			"rlimits": []map[string]any{
+				{"type": "RLIMIT_NOFILE", "hard": 64, "soft": 64},
+			},
+			"noNewPrivileges": true,
+		},
+		"mounts": mounts,
+		"linux": map[string]any{
+			"namespaces": []map[string]any{
+				{"type": "pid"}, {"type": "ipc"}, {"type": "uts"}, {"type": "mount"}, {"type": "user"}, {"type": "network"},
+			},
+			"uidMappings": []map[string]any{{"containerID": 0, "hostID": os.Geteuid(), "size": 1}},
+			"gidMappings": []map[string]any{{"containerID": 0, "hostID": os.Getegid(), "size": 1}},
+			"resources": map[string]any{
+				"devices": []map[string]any{{"allow": false, "access": "rwm"}},
+			},
+			"maskedPaths":   []string{"/proc/kcore"},
+			"readonlyPaths": []string{"/proc/sys", "/etc"},
+			"seccomp": map[string]any{
+				"defaultAction": "SCMP_ACT_ALLOW",
+				"architectures": []string{"SCMP_ARCH_X86_64"},
+				"syscalls": []map[string]any{
+					{"names": []string{"mkdir", "mkdirat"}, "action": "SCMP_ACT_ERRNO", "errnoRet": 1},
+					{"names": []string{"not_a_syscall"}, "action": "SCMP_ACT_KILL"},
+				},
+			},
+		},
+	}
+}
+
+func writeOCISpec(t *testing.T, spec map[string]any) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "rootfs", "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLoadOCISpec(t *testing.T) {
+	b, p, err := LoadOCISpec(writeOCISpec(t, ociTestSpec()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const ns = unix.CLONE_NEWPID | unix.CLONE_NEWIPC | unix.CLONE_NEWUTS | unix.CLONE_NEWNS | unix.CLONE_NEWUSER | unix.CLONE_NEWNET
+	if b.CloneFlags != ns {
+		t.Fatalf("expected clone flags %x, got %x", ns, b.CloneFlags)
+	}
+	if b.HostName != "oci" || b.WorkDir != "/tmp" || len(b.MaskPaths) != 1 {
+		t.Fatalf("unexpected builder %+v", b)
+	}
+	if p.WorkDir != "/tmp" || !p.ClearEnv || p.Seccomp == nil || len(p.RLimits) != 1 || *p.Umask != 022 {
+		t.Fatalf("unexpected execve param %+v", p)
+	}
+
+	targets := make(map[string]bool)
+	for _, m := range b.Mounts {
+		targets[m.Target] = true
+		if m.Target == "proc" && !m.IsReadOnly() {
+			t.Fatal("proc is writable with readonlyPaths under /proc")
+		}
+	}
+	for _, target := range []string{"etc", "proc", "dev", "tmp", "dev/null"} {
+		if !targets[target] {
+			t.Fatalf("mount %s not found in %v", target, b.Mounts)
+		}
+	}
+	for _, target := range []string{"dev/pts", "sys/fs/cgroup", "sys"} {
+		if targets[target] {
+			t.Fatalf("mount %s should be skipped", target)
+		}
+	}
+
+	tmp := t.TempDir()
+	b.Root = tmp
+	b.Stderr = os.Stderr
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		m.Destroy()
+	})
+
+	fds := make([]int, 2)
+	if err := syscall.Pipe2(fds, syscall.O_CLOEXEC); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	p.Files = []uintptr{0, uintptr(fds[1]), uintptr(fds[1])}
+	r := m.Execve(context.TODO(), p)
+	syscall.Close(fds[1])
+	if r.Status != runner.StatusNormal {
+		t.Fatal(r.Status, r.Error)
+	}
+	out, err := io.ReadAll(os.NewFile(uintptr(fds[0]), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "bar\n/tmp\noci\n0022\n64\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	// seccomp errno
+	p.Args = []string{"/bin/mkdir", "/tmp/d"}
+	p.Files = nil
+	if r := m.Execve(context.TODO(), p); r.Status != runner.StatusNonzeroExitStatus {
+		t.Fatal(r.Status, r.Error)
+	}
+}
+
+func TestLoadOCISpecUnsupported(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(s map[string]any)
+		err    string
+	}{
+		{"unknown field", func(s map[string]any) {
+			s["solaris"] = map[string]any{}
+		}, `unknown field "solaris"`},
+		{"writable root", func(s map[string]any) {
+			s["root"] = map[string]any{"path": "/"}
+		}, "root.readonly"},
+		{"terminal", func(s map[string]any) {
+			s["process"].(map[string]any)["terminal"] = true
+		}, "process.terminal"},
+		{"namespace path", func(s map[string]any) {
+			s["linux"].(map[string]any)["namespaces"] = []map[string]any{
+				{"type": "mount"}, {"type": "user"}, {"type": "network", "path": "/proc/1/ns/net"},
+			}
+		}, "linux.namespaces"},
+		{"no user namespace", func(s map[string]any) {
+			s["linux"].(map[string]any)["namespaces"] = []map[string]any{{"type": "mount"}}
+		}, "linux.namespaces"},
+		{"uid mapping", func(s map[string]any) {
+			s["linux"].(map[string]any)["uidMappings"] = []map[string]any{
+				{"containerID": 0, "hostID": os.Geteuid() + 1, "size": 1},
+			}
+		}, "linux.uidMappings"},
+		{"uid not mapped", func(s map[string]any) {
+			s["process"].(map[string]any)["user"] = map[string]any{"uid": 1000, "gid": 0}
+		}, "process.user.uid"},
+		{"resources", func(s map[string]any) {
+			s["linux"].(map[string]any)["resources"] = map[string]any{"memory": map[string]any{"limit": 1 << 20}}
+		}, "linux.resources.memory"},
+		{"mount type", func(s map[string]any) {
+			s["mounts"] = []map[string]any{{"destination": "/mnt", "type": "overlay", "source": "overlay"}}
+		}, "mounts[0]"},
+		{"writable readonly path", func(s map[string]any) {
+			s["linux"].(map[string]any)["readonlyPaths"] = []string{"/tmp/x"}
+		}, "linux.readonlyPaths"},
+		{"seccomp action", func(s map[string]any) {
+			s["linux"].(map[string]any)["seccomp"] = map[string]any{"defaultAction": "SCMP_ACT_LOG"}
+		}, "linux.seccomp"},
+		{"hooks", func(s map[string]any) {
+			s["hooks"] = map[string]any{"prestart": []map[string]any{{"path": "/bin/true"}}}
+		}, "hooks"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := ociTestSpec()
+			tc.modify(s)
+			_, _, err := LoadOCISpec(writeOCISpec(t, s))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
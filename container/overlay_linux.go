@@ -0,0 +1,75 @@
+package container
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/zqzqsb/sandbox/pkg/mount"
+	"golang.org/x/sys/unix"
+)
+
+// resetMounts clears the tmpfs mounts and drops the upper layer of the overlay
+// mounts, returns the number of removed entries. The tmpfs mounts that hold the
+// upper directories are left to their overlay
+func (c *containerServer) resetMounts() (int, error) {
+	var uppers []string
+	for _, m := range c.Mounts {
+		if upper, _ := m.OverlayDirs(); upper != "" {
+			uppers = append(uppers, filepath.Join("/", upper))
+		}
+	}
+
+	total := 0
+	for _, m := range c.Mounts {
+		var (
+			n   int
+			err error
+		)
+		target := filepath.Join("/", m.Target)
+		switch {
+		case m.IsOverlay():
+			n, err = resetOverlay(m)
+		case m.IsTmpFs() && !holdsUpper(target, uppers):
+			n, err = removeContents(target)
+		default:
+			continue
+		}
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("%v %v", m.Target, err)
+		}
+	}
+	return total, nil
+}
+
+func holdsUpper(target string, uppers []string) bool {
+	for _, u := range uppers {
+		if strings.HasPrefix(u, target+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// resetOverlay unmounts the overlay, clears its upper directory and mounts it
+// again. It fails with EBUSY if the overlay is in use
+func resetOverlay(m mount.Mount) (int, error) {
+	upper, _ := m.OverlayDirs()
+	if upper == "" {
+		// read-only overlay
+		return 0, nil
+	}
+	target := filepath.Join("/", m.Target)
+	if err := unix.Unmount(target, 0); err != nil {
+		return 0, err
+	}
+	n, err := removeContents(filepath.Join("/", upper))
+	if err != nil {
+		return n, err
+	}
+
+	// the mount target and options are relative to the container root
+	m = m.WithRoot("/")
+	return n, m.Mount()
+}
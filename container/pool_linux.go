@@ -0,0 +1,243 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is returned by Get after the pool was closed
+var ErrPoolClosed = errors.New("container: pool closed")
+
+// ErrNotInUse is returned by Put for environments not returned by Get or
+// already put back
+var ErrNotInUse = errors.New("container: environment not in use")
+
+// buildRetryWait is the wait between failed builds of a replacement
+const buildRetryWait = time.Second
+
+// EnvironmentBuilder builds new container environment, *Builder implements it
+type EnvironmentBuilder interface {
+	Build() (Environment, error)
+}
+
+// PoolStats is a snapshot of the pool statistics
+type PoolStats struct {
+	Size      int // number of environments maintained by the pool
+	Idle      int // environments ready to be returned by Get
+	InUse     int // environments returned by Get and not yet Put back
+	Replacing int // broken environments being rebuilt
+
+	Created       uint64 // environments built, including the initial ones
+	Destroyed     uint64 // environments destroyed
+	Replaced      uint64 // broken environments that were rebuilt
+	BuildFailures uint64 // failed builds (retried after buildRetryWait)
+	Cleaned       uint64 // environments put back with leftovers removed by Restore
+}
+
+// Pool holds a fixed number of pre-built container environments
+//
+// Environments are handed out by Get and must be given back by Put, which
+// restores and pings them. Broken environments (failed restore / ping or lost
+// host - container socket) are destroyed and rebuilt in the background so
+// that the pool keeps its size.
+type Pool struct {
+	builder EnvironmentBuilder
+	idle    chan Environment
+
+	mu     sync.Mutex
+	inUse  map[Environment]struct{}
+	stats  PoolStats
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewPool builds size environments with the builder. If any of them fails
+// to build, the built ones are destroyed and the error is returned.
+func NewPool(builder EnvironmentBuilder, size int) (*Pool, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("container: invalid pool size %d", size)
+	}
+	p := &Pool{
+		builder: builder,
+		idle:    make(chan Environment, size),
+		inUse:   make(map[Environment]struct{}),
+		done:    make(chan struct{}),
+	}
+	p.stats.Size = size
+	for i := 0; i < size; i++ {
+		env, err := builder.Build()
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("container: failed to build pool environment %v", err)
+		}
+		p.stats.Created++
+		p.idle <- env
+	}
+	return p, nil
+}
+
+// Get returns an idle environment, waiting for one until ctx is done.
+// Environments whose socket failed while idle are replaced and skipped.
+func (p *Pool) Get(ctx context.Context) (Environment, error) {
+	for {
+		select {
+		case <-p.done:
+			return nil, ErrPoolClosed
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		case env := <-p.idle:
+			if isBroken(env) {
+				p.replace(env)
+				continue
+			}
+			p.mu.Lock()
+			p.inUse[env] = struct{}{}
+			p.mu.Unlock()
+			return env, nil
+		}
+	}
+}
+
+// Put restores the environment returned by Get and gives it back to the pool.
+// The environment is replaced if the restore or ping fails. Environments not
+// returned by Get, or already put back, are rejected with ErrNotInUse.
+func (p *Pool) Put(env Environment) error {
+	p.mu.Lock()
+	if _, ok := p.inUse[env]; !ok {
+		p.mu.Unlock()
+		return ErrNotInUse
+	}
+	delete(p.inUse, env)
+	p.mu.Unlock()
+
+	if isBroken(env) {
+		p.replace(env)
+		return nil
+	}
+	rep, err := env.Restore()
+	if err != nil || env.Ping() != nil {
+		p.replace(env)
+		return nil
+	}
+
+	p.mu.Lock()
+	if !rep.Clean() {
+		p.stats.Cleaned++
+	}
+	p.mu.Unlock()
+	p.release(env)
+	return nil
+}
+
+// release gives the environment back to the idle channel, or destroys it
+// when the pool is closed or the channel is full. The send never blocks so
+// that the pool can not be deadlocked by a miscounted environment
+func (p *Pool) release(env Environment) {
+	p.mu.Lock()
+	if !p.closed {
+		select {
+		case p.idle <- env:
+			p.mu.Unlock()
+			return
+		default:
+		}
+	}
+	p.mu.Unlock()
+	p.destroy(env)
+}
+
+// Stats returns the current pool statistics
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := p.stats
+	s.Idle = len(p.idle)
+	s.InUse = len(p.inUse)
+	return s
+}
+
+// Close destroys the idle environments and waits for the running rebuilds.
+// Environments in use are destroyed when they are Put back.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+
+	for {
+		select {
+		case env := <-p.idle:
+			p.destroy(env)
+		default:
+			return nil
+		}
+	}
+}
+
+// replace destroys the broken environment and builds its replacement in the
+// background, retrying until the build succeeds or the pool is closed
+func (p *Pool) replace(env Environment) {
+	p.destroy(env)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.stats.Replacing++
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for {
+			newEnv, err := p.builder.Build()
+
+			p.mu.Lock()
+			if err == nil {
+				p.stats.Created++
+				p.stats.Replaced++
+				p.stats.Replacing--
+				p.mu.Unlock()
+				p.release(newEnv)
+				return
+			}
+			p.stats.BuildFailures++
+			p.mu.Unlock()
+
+			select {
+			case <-p.done:
+				p.mu.Lock()
+				p.stats.Replacing--
+				p.mu.Unlock()
+				return
+			case <-time.After(buildRetryWait):
+			}
+		}
+	}()
+}
+
+func (p *Pool) destroy(env Environment) {
+	env.Destroy()
+
+	p.mu.Lock()
+	p.stats.Destroyed++
+	p.mu.Unlock()
+}
+
+// isBroken reports whether the host - container socket of env failed
+func isBroken(env Environment) bool {
+	c, ok := env.(interface{ socketErr() error })
+	return ok && c.socketErr() != nil
+}
@@ -0,0 +1,153 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/zqzqsb/sandbox/runner"
+)
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+	p := getPool(t, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	m, err := p.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := m.Execve(ctx, ExecveParam{
+		Args: []string{"/bin/true"},
+		Env:  []string{"PATH=/bin"},
+	})
+	if r.Status != runner.StatusNormal {
+		t.Fatal(r.Status, r.Error)
+	}
+	if s := p.Stats(); s.InUse != 1 || s.Idle != 1 {
+		t.Fatalf("stats after get %+v", s)
+	}
+	p.Put(m)
+	if s := p.Stats(); s.InUse != 0 || s.Idle != 2 || s.Created != 2 || s.Destroyed != 0 {
+		t.Fatalf("stats after put %+v", s)
+	}
+
+	// all environments in use
+	m1, _ := p.Get(ctx)
+	m2, _ := p.Get(ctx)
+	wctx, wcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer wcancel()
+	if _, err := p.Get(wctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected deadline exceeded, got", err)
+	}
+	p.Put(m1)
+	p.Put(m2)
+}
+
+func TestPoolReplace(t *testing.T) {
+	t.Parallel()
+	p := getPool(t, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// broken while in use
+	m, err := p.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Destroy()
+	p.Put(m)
+
+	m, err = p.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Ping(); err != nil {
+		t.Fatal("replacement not alive", err)
+	}
+	p.Put(m)
+	if s := p.Stats(); s.Replaced != 1 || s.Created != 2 || s.Destroyed != 1 || s.Idle != 1 {
+		t.Fatalf("stats after replace %+v", s)
+	}
+}
+
+func TestPoolPutNotInUse(t *testing.T) {
+	t.Parallel()
+	p := getPool(t, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	m, err := p.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(m); !errors.Is(err, ErrNotInUse) {
+		t.Fatal("expected not in use for double put, got", err)
+	}
+
+	foreign := getEnv(t, nil)
+	if err := p.Put(foreign); !errors.Is(err, ErrNotInUse) {
+		t.Fatal("expected not in use for foreign environment, got", err)
+	}
+	if s := p.Stats(); s.InUse != 0 || s.Idle != 1 {
+		t.Fatalf("stats after rejected puts %+v", s)
+	}
+
+	// the pool still works
+	m, err = p.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type failBuilder struct{}
+
+func (failBuilder) Build() (Environment, error) {
+	return nil, errors.New("build failed")
+}
+
+func TestPoolBuildFailed(t *testing.T) {
+	t.Parallel()
+	if _, err := NewPool(failBuilder{}, 1); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := NewPool(failBuilder{}, 0); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func getPool(t *testing.T, size int) *Pool {
+	tmpDir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Remove(tmpDir)
+	})
+	p, err := NewPool(&Builder{
+		Root:   tmpDir,
+		Stderr: os.Stderr,
+	}, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		p.Close()
+		if s := p.Stats(); s.Destroyed != s.Created {
+			t.Errorf("environments leaked %+v", s)
+		}
+	})
+	return p
+}
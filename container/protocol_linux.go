@@ -17,9 +17,15 @@ type cmd struct {
 	ExecCmd   *execCmd   // execve argument
 	ConfCmd   *confCmd   // to set configuration
 
+	CopyInCmd  *copyInCmd  // copyin argument
+	CopyOutCmd *copyOutCmd // copyout argument
+	StatCmd    *statCmd    // stat / readdir argument
+	MkdirCmd   *mkdirCmd   // mkdir argument
+
 	OpenCmd []OpenCmd // open argument
 
 	Cmd cmdType // type of the cmd
+	ID  uint64  // request id, replies and later cmds of the same request carry it
 }
 
 // OpenCmd correspond to a single open syscall
@@ -34,6 +40,27 @@ type deleteCmd struct {
 	Path string
 }
 
+// copyInCmd stores copyin parameter, the tar stream is read from the fd
+type copyInCmd struct {
+	Dst string
+}
+
+// copyOutCmd stores copyout parameter, the tar stream is written to the fd
+type copyOutCmd struct {
+	Paths []string
+}
+
+// statCmd stores stat / readdir parameter
+type statCmd struct {
+	Path string
+}
+
+// mkdirCmd stores mkdir parameter
+type mkdirCmd struct {
+	Path string
+	Perm os.FileMode
+}
+
 // execCmd stores execve parameter
 type execCmd struct {
 	Argv    []string        // execve argv
@@ -42,6 +69,18 @@ type execCmd struct {
 	Seccomp seccomp.Filter  // seccomp filter
 	FdExec  bool            // if use fexecve (fd[0] as exec)
 	CTTY    bool            // if set CTTY
+	Limit   runner.Limit    // time / memory limit checked on exit (CgroupCPUUsage is not sent)
+
+	WorkDir  string       // work directory, empty uses the container work directory
+	SetUID   bool         // use UID, otherwise ContainerUID if Cred (gob drops a pointer to 0)
+	SetGID   bool         // use GID, otherwise ContainerGID if Cred
+	UID      int          // uid if SetUID
+	GID      int          // gid if SetGID
+	Umask    *os.FileMode // umask, nil inherits from the container init
+	ClearEnv bool         // do not merge the default environment from /.env
+
+	KillSignal      syscall.Signal // signal sent first when killed, default SIGTERM
+	KillGracePeriod time.Duration  // wait after KillSignal before SIGKILL, 0 sends SIGKILL only
 }
 
 // confCmd stores conf parameter
@@ -64,14 +103,55 @@ type containerConfig struct {
 
 	ContainerUID  int
 	ContainerGID  int
+	IDMapSize     int // number of mapped ids from ContainerUID / ContainerGID, 0 without Cred
 	Cred          bool
 	UnshareCgroup bool
+	UnshareIPC    bool // SysV IPC objects and POSIX message queues are removed by restore
+	Loopback      bool // bring up lo in the container network namespace
+
+	CopyMaxSize  runner.Size
+	CopyMaxFiles int
 }
 
 // reply is the reply message send back to controller
 type reply struct {
 	Error     *errorReply // nil if no error
 	ExecReply *execReply
+	FileInfo  []FileInfo     // stat / readdir result
+	Restore   *RestoreReport // restore result
+	ID        uint64         // request id of the cmd replied to
+}
+
+// FileInfo describes a file inside of the container (symbolic links are not followed)
+type FileInfo struct {
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+}
+
+// IsDir reports whether the file is a directory
+func (f FileInfo) IsDir() bool {
+	return f.Mode.IsDir()
+}
+
+// RestoreReport lists what Restore had to clean up to bring the container
+// back to the state right after Build
+type RestoreReport struct {
+	Processes     int // stray processes killed
+	Files         int // entries removed from the tmpfs mounts
+	SharedMemory  int // SysV shared memory segments removed
+	Semaphores    int // SysV semaphore sets removed
+	MessageQueues int // SysV message queues removed
+	PosixMQueues  int // POSIX message queues removed
+
+	DotEnv   bool // /.env was modified and written back
+	HostName bool // host / domain name was changed and set back
+}
+
+// Clean reports whether there was nothing to clean up
+func (r RestoreReport) Clean() bool {
+	return r == RestoreReport{}
 }
 
 // errorReply stores error returned back from container
@@ -86,6 +166,21 @@ type execReply struct {
 	Status     runner.Status // return status
 	Time       time.Duration // waitpid user CPU (ns)
 	Memory     runner.Size   // waitpid user memory (byte)
+
+	CPUTime    time.Duration     // CPU time compared against the time limit
+	TimePolicy runner.TimePolicy // time policy used for CPUTime
+
+	// additional resource usage, see runner.Result
+	SystemTime                 time.Duration
+	WallTime                   time.Duration
+	VoluntaryContextSwitches   int64
+	InvoluntaryContextSwitches int64
+	MajorPageFaults            int64
+	MinorPageFaults            int64
+	ReadBytes                  runner.Size
+	WriteBytes                 runner.Size
+	Processes                  int
+	KillPhase                  runner.KillPhase
 }
 
 func (e *errorReply) Error() string {
@@ -0,0 +1,146 @@
+package container
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zqzqsb/sandbox/pkg/mount"
+	"github.com/zqzqsb/sandbox/runner"
+)
+
+func TestContainerRestore(t *testing.T) {
+	t.Parallel()
+	m := getEnv(t, nil)
+
+	rep, err := m.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.Clean() {
+		t.Fatalf("fresh environment not clean %+v", rep)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	r := m.Execve(ctx, ExecveParam{
+		Args: []string{"/bin/sh", "-c", "ipcmk -M 1024 && ipcmk -S 1 && ipcmk -Q && echo x > /w/a && mkdir /tmp/d"},
+		Env:  []string{PathEnv},
+	})
+	if r.Status != runner.StatusNormal {
+		t.Fatal(r.Status, r.Error)
+	}
+
+	rep, err = m.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := RestoreReport{Files: 2, SharedMemory: 1, Semaphores: 1, MessageQueues: 1}
+	if rep != expected {
+		t.Fatalf("restore %+v, expected %+v", rep, expected)
+	}
+	if entries, err := m.ReadDir("/w"); err != nil || len(entries) != 0 {
+		t.Fatal(entries, err)
+	}
+
+	if rep, err = m.Restore(); err != nil || !rep.Clean() {
+		t.Fatalf("second restore %+v %v", rep, err)
+	}
+}
+
+func TestContainerRestoreCopying(t *testing.T) {
+	t.Parallel()
+	m := getEnv(t, nil)
+
+	pr, pw := io.Pipe()
+	copyErr := make(chan error, 1)
+	go func() {
+		copyErr <- m.CopyIn("/w", pr)
+	}()
+	// the write returns once the copy is acked and reading
+	if _, err := pw.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Restore(); err == nil || !strings.Contains(err.Error(), "copy running") {
+		t.Fatalf("restore while copying: %v", err)
+	}
+	if err := m.Reset(); err == nil || !strings.Contains(err.Error(), "copy running") {
+		t.Fatalf("reset while copying: %v", err)
+	}
+
+	pw.Close()
+	if err := <-copyErr; err == nil {
+		t.Fatal("expected truncated tar error")
+	}
+	if rep, err := m.Restore(); err != nil || !rep.Clean() {
+		t.Fatalf("restore after copy %+v %v", rep, err)
+	}
+}
+
+func TestContainerOverlay(t *testing.T) {
+	t.Parallel()
+	lower := t.TempDir()
+	if err := os.WriteFile(filepath.Join(lower, "f"), []byte("lower"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := &Builder{
+		Root: t.TempDir(),
+		Mounts: mount.NewDefaultBuilder().
+			WithTmpfs("w", "").
+			WithTmpfs("tmp", "").
+			WithOverlay([]string{lower}, "data", 1<<20).
+			FilterNotExist().Mounts,
+		Stderr: os.Stderr,
+	}
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		m.Destroy()
+	})
+
+	modify := ExecveParam{
+		Args: []string{"/bin/sh", "-c", "rm /data/f && mkdir /data/__pycache__ && echo x > /data/__pycache__/g"},
+		Env:  []string{PathEnv},
+	}
+	for i := 0; i < 2; i++ {
+		if r := m.Execve(context.TODO(), modify); r.Status != runner.StatusNormal {
+			t.Fatal(i, r.Status, r.Error)
+		}
+		if entries, err := m.ReadDir("/data"); err != nil || len(entries) != 1 || entries[0].Name != "__pycache__" {
+			t.Fatal(i, entries, err)
+		}
+
+		// reset and restore drop the upper layer
+		if i == 0 {
+			err = m.Reset()
+		} else {
+			var rep RestoreReport
+			rep, err = m.Restore()
+			if rep.Files != 2 {
+				t.Fatalf("restore %+v", rep)
+			}
+		}
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if entries, err := m.ReadDir("/data"); err != nil || len(entries) != 1 || entries[0].Name != "f" {
+			t.Fatal(i, entries, err)
+		}
+	}
+	if rep, err := m.Restore(); err != nil || !rep.Clean() {
+		t.Fatalf("restore after overlay reset %+v %v", rep, err)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(lower, "f")); err != nil || string(b) != "lower" {
+		t.Fatalf("lower dir modified: %q %v", b, err)
+	}
+	if entries, err := os.ReadDir(lower); err != nil || len(entries) != 1 {
+		t.Fatalf("lower dir modified: %v %v", entries, err)
+	}
+}
@@ -40,24 +40,25 @@ func closeFds(s []int) {
 	}
 }
 
-// removeContents delete content of a directory
-func removeContents(dir string) error {
+// removeContents delete content of a directory and returns the number of
+// removed entries
+func removeContents(dir string) (int, error) {
 	d, err := os.Open(dir)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer d.Close()
 
 	names, err := d.Readdirnames(-1)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	for _, name := range names {
+	for i, name := range names {
 		err = os.RemoveAll(path.Join(dir, name))
 		if err != nil {
-			return err
+			return i, err
 		}
 	}
-	return nil
+	return len(names), nil
 }
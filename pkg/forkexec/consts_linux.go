@@ -2,6 +2,8 @@
 package forkexec
 
 import (
+	"unsafe"
+
 	"golang.org/x/sys/unix"
 )
 
@@ -19,6 +21,11 @@ const (
 	// 确保所有线程都使用相同的系统调用过滤规则
 	SECCOMP_FILTER_FLAG_TSYNC = 1
 
+	// SECCOMP_FILTER_FLAG_NEW_LISTENER 表示加载过滤器时创建用户态通知的监听文件描述符
+	// 过滤器返回 SECCOMP_RET_USER_NOTIF 的系统调用会通过该描述符交给监督者处理
+	// 注意：不能与 SECCOMP_FILTER_FLAG_TSYNC 同时使用
+	SECCOMP_FILTER_FLAG_NEW_LISTENER = 8
+
 	// UnshareFlags 定义了创建新命名空间的标志位组合
 	// CLONE_NEWIPC: 新的 IPC 命名空间
 	// CLONE_NEWNET: 新的网络命名空间
@@ -72,6 +79,31 @@ var (
 		Inheritable: 0, // 可继承能力集
 	}
 
+	// loopbackUp 是启用 lo 网卡的 netlink 请求（RTM_NEWLINK）
+	// 新网络命名空间中 lo 的 ifindex 总是 1
+	loopbackUp = loopbackRequest{
+		Header: unix.NlMsghdr{
+			Len:   uint32(unsafe.Sizeof(loopbackRequest{})),
+			Type:  unix.RTM_NEWLINK,
+			Flags: unix.NLM_F_REQUEST | unix.NLM_F_ACK,
+			Seq:   1,
+		},
+		Info: unix.IfInfomsg{
+			Family: unix.AF_UNSPEC,
+			Index:  1,
+			Flags:  unix.IFF_UP,
+			Change: unix.IFF_UP,
+		},
+	}
+
+	// loopbackAddr 是内核 netlink 的地址
+	loopbackAddr = unix.RawSockaddrNetlink{
+		Family: unix.AF_NETLINK,
+	}
+
+	// loopbackAck 用于在子进程中接收 netlink 的确认消息（fork 后每个子进程各有一份）
+	loopbackAck loopbackReply
+
 	// etxtbsyRetryInterval 定义了遇到 ETXTBSY 错误时的重试间隔
 	// 设置为 1 毫秒 (1 * 1000 * 1000 纳秒)
 	etxtbsyRetryInterval = unix.Timespec{
@@ -79,6 +111,19 @@ var (
 	}
 )
 
+// loopbackRequest 是 RTM_NEWLINK 请求的消息格式
+type loopbackRequest struct {
+	Header unix.NlMsghdr
+	Info   unix.IfInfomsg
+}
+
+// loopbackReply 是 NLMSG_ERROR 确认消息的格式，Error 为 0 表示成功
+type loopbackReply struct {
+	Header unix.NlMsghdr
+	Error  int32
+	Msg    loopbackRequest
+}
+
 // Linux 安全位（Secure Bits）的常量定义
 const (
 	// _SECURE_NOROOT: 禁止 root 用户的特权
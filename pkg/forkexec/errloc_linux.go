@@ -56,6 +56,7 @@ const (
 	LocSyncWrite                                 // 同步写入失败
 	LocSyncRead                                  // 同步读取失败
 	LocExecve                                    // 执行新程序失败
+	LocLoopback                                  // 启用 lo 网卡失败
 )
 
 // locToString 将错误位置常量映射为人类可读的字符串
@@ -94,13 +95,14 @@ var locToString = []string{
 	"sync_write",          // 30: 同步写入
 	"sync_read",           // 31: 同步读取
 	"execve",              // 32: 执行程序
+	"loopback",            // 33: 启用 lo 网卡
 }
 
 // String 将 ErrorLocation 转换为人类可读的字符串
 // 如果位置值在有效范围内，返回对应的描述
 // 否则返回 "unknown"
 func (e ErrorLocation) String() string {
-	if e >= LocClone && e <= LocExecve {
+	if e >= LocClone && e <= LocLoopback {
 		return locToString[e]
 	}
 	return "unknown"
@@ -58,8 +58,15 @@ func forkAndExecInChild(r *Runner, argv0 *byte, argv, env []*byte, workdir, host
 		err2        syscall.Errno
 		// 检查是否需要创建新的用户命名空间
 		unshareUser = r.CloneFlags&unix.CLONE_NEWUSER == unix.CLONE_NEWUSER
+		// 加载 seccomp 过滤器使用的标志
+		seccompFlags uintptr = SECCOMP_FILTER_FLAG_TSYNC
 	)
 
+	// 需要用户态通知时创建监听描述符（子进程此时只有一个线程，不需要 TSYNC）
+	if r.SeccompNotify {
+		seccompFlags = SECCOMP_FILTER_FLAG_NEW_LISTENER
+	}
+
 	// 关闭管道的写入端
 	if _, _, err1 = syscall.RawSyscall(syscall.SYS_CLOSE, uintptr(p[0]), 0, 0); err1 != 0 {
 		childExitError(pipe, LocCloseWrite, err1)
@@ -299,6 +306,36 @@ func forkAndExecInChild(r *Runner, argv0 *byte, argv, env []*byte, workdir, host
 		}
 	}
 
+	// 启用 lo 网卡
+	// socket(AF_NETLINK, SOCK_RAW, NETLINK_ROUTE) => sendto(RTM_NEWLINK) => recvfrom(NLMSG_ERROR)
+	if r.Loopback {
+		r1, _, err1 = syscall.RawSyscall(unix.SYS_SOCKET, syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+		if err1 != 0 {
+			childExitError(pipe, LocLoopback, err1)
+		}
+		nl := r1
+
+		_, _, err1 = syscall.RawSyscall6(unix.SYS_SENDTO, nl, uintptr(unsafe.Pointer(&loopbackUp)), unsafe.Sizeof(loopbackUp),
+			0, uintptr(unsafe.Pointer(&loopbackAddr)), unsafe.Sizeof(loopbackAddr))
+		if err1 != 0 {
+			childExitError(pipe, LocLoopback, err1)
+		}
+
+		r1, _, err1 = syscall.RawSyscall6(unix.SYS_RECVFROM, nl, uintptr(unsafe.Pointer(&loopbackAck)), unsafe.Sizeof(loopbackAck), 0, 0, 0)
+		if err1 != 0 {
+			childExitError(pipe, LocLoopback, err1)
+		}
+		if r1 < unsafe.Offsetof(loopbackAck.Msg) || loopbackAck.Header.Type != unix.NLMSG_ERROR {
+			err1 = syscall.EINVAL
+			childExitError(pipe, LocLoopback, err1)
+		}
+		if loopbackAck.Error != 0 {
+			err1 = syscall.Errno(-loopbackAck.Error)
+			childExitError(pipe, LocLoopback, err1)
+		}
+		syscall.RawSyscall(syscall.SYS_CLOSE, nl, 0, 0)
+	}
+
 	// 设置主机名
 	if hostname != nil {
 		syscall.RawSyscall(syscall.SYS_SETHOSTNAME,
@@ -319,6 +356,11 @@ func forkAndExecInChild(r *Runner, argv0 *byte, argv, env []*byte, workdir, host
 		}
 	}
 
+	// 设置文件模式创建掩码（umask 不会失败）
+	if r.Umask != nil {
+		syscall.RawSyscall(syscall.SYS_UMASK, uintptr(*r.Umask&0777), 0, 0)
+	}
+
 	// 设置资源限制
 	for i, rlim := range r.RLimits {
 		// prlimit 代替 setrlimit 以避免 32 位限制（linux > 3.2）
@@ -407,7 +449,7 @@ func forkAndExecInChild(r *Runner, argv0 *byte, argv, env []*byte, workdir, host
 		// 需要在 seccomp 之前执行，因为这些可能被跟踪
 
 		// 加载 seccomp 过滤器
-		_, _, err1 = syscall.RawSyscall(unix.SYS_SECCOMP, SECCOMP_SET_MODE_FILTER, SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(r.Seccomp)))
+		_, _, err1 = syscall.RawSyscall(unix.SYS_SECCOMP, SECCOMP_SET_MODE_FILTER, seccompFlags, uintptr(unsafe.Pointer(r.Seccomp)))
 		if err1 != 0 {
 			childExitError(pipe, LocSeccomp, err1)
 		}
@@ -446,7 +488,7 @@ func forkAndExecInChild(r *Runner, argv0 *byte, argv, env []*byte, workdir, host
 
 				if r.Seccomp != nil {
 					// 加载 seccomp 过滤器
-					_, _, err1 = syscall.RawSyscall(unix.SYS_SECCOMP, SECCOMP_SET_MODE_FILTER, SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(r.Seccomp)))
+					_, _, err1 = syscall.RawSyscall(unix.SYS_SECCOMP, SECCOMP_SET_MODE_FILTER, seccompFlags, uintptr(unsafe.Pointer(r.Seccomp)))
 					if err1 != 0 {
 						childExitError(pipe, LocSeccomp, err1)
 					}
@@ -117,3 +117,64 @@ func TestFork_ENOENT(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestFork_Loopback(t *testing.T) {
+	t.Parallel()
+	for _, loopback := range []bool{false, true} {
+		r := Runner{
+			Args:       []string{"/bin/sh", "-c", "ip link show lo | grep -q LOOPBACK,UP"},
+			CloneFlags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET,
+			Loopback:   loopback,
+		}
+		pid, err := r.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ws syscall.WaitStatus
+		if _, err := syscall.Wait4(pid, &ws, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+		if up := ws.ExitStatus() == 0; up != loopback {
+			t.Fatalf("loopback %v: lo up %v", loopback, up)
+		}
+	}
+}
+
+func TestFork_Overlay(t *testing.T) {
+	t.Parallel()
+	lower := t.TempDir()
+	if err := os.WriteFile(lower+"/f", []byte("lower"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	m, err := mount.NewDefaultBuilder().
+		FilterNotExist().
+		WithOverlay([]string{lower}, "data", 1<<20).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Runner{
+		Args:       []string{"/bin/sh", "-c", "echo upper > /data/f && rm -f /data/f && echo new > /data/g && test ! -e /data/f"},
+		CloneFlags: syscall.CLONE_NEWNS | syscall.CLONE_NEWUSER,
+		Mounts:     m,
+		PivotRoot:  root,
+	}
+	pid, err := r.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &ws, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ws.ExitStatus() != 0 {
+		t.Fatalf("overlay not writable: %v", ws)
+	}
+	if b, err := os.ReadFile(lower + "/f"); err != nil || string(b) != "lower" {
+		t.Fatalf("lower dir modified: %q %v", b, err)
+	}
+	if _, err := os.Stat(lower + "/g"); !os.IsNotExist(err) {
+		t.Fatalf("lower dir modified: %v", err)
+	}
+}
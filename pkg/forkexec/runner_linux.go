@@ -1,6 +1,7 @@
 package forkexec
 
 import (
+	"os"
 	"syscall"
 
 	"github.com/zqzqsb/sandbox/pkg/mount"
@@ -62,6 +63,10 @@ type Runner struct {
 	// 8. mount("tmpfs", "/", "tmpfs", MS_BIND | MS_REMOUNT | MS_RDONLY | MS_NOATIME | MS_NOSUID, nil)
 	PivotRoot string
 
+	// Loopback 在设置主机名之前通过 netlink 启用新网络命名空间中的 lo 网卡
+	// 需要 CLONE_NEWNET 以及命名空间内的 CAP_NET_ADMIN 权限
+	Loopback bool
+
 	// HostName 和 DomainName 在 unshare UTS 和用户命名空间后设置
 	// 需要 CAP_SYS_ADMIN 权限
 	HostName, DomainName string
@@ -94,6 +99,11 @@ type Runner struct {
 	// 不能在 seccomp 后停止，因为 kill 可能被 seccomp 过滤器禁用
 	StopBeforeSeccomp bool
 
+	// SeccompNotify 在加载 seccomp 过滤器时创建用户态通知的监听文件描述符
+	// 该描述符只存在于子进程中，父进程需要在 SyncFunc 中通过 pidfd_getfd 取得
+	// 需要 UnshareCgroupAfterSync 为 false，以保证 SyncFunc 调用时过滤器已经加载
+	SeccompNotify bool
+
 	// GIDMappingsEnableSetgroups 允许/禁止 setgroups 系统调用
 	// 如果 GIDMappings 为 nil 则拒绝
 	GIDMappingsEnableSetgroups bool
@@ -109,4 +119,8 @@ type Runner struct {
 
 	// CTTY 指定是否将文件描述符 0 设置为控制终端
 	CTTY bool
+
+	// Umask 设置子进程的文件模式创建掩码（在 chdir 之后设置）
+	// 为 nil 时继承父进程的掩码
+	Umask *os.FileMode
 }
@@ -1,7 +1,10 @@
 package mount
 
 import (
+	"fmt"
 	"os"
+	"path"
+	"strconv"
 	"strings"
 
 	"golang.org/x/sys/unix"
@@ -20,6 +23,10 @@ const (
 	// - MS_NOATIME: 不更新文件访问时间，提高性能
 	// - MS_NODEV: 禁止访问设备文件
 	mFlag = unix.MS_NOSUID | unix.MS_NOATIME | unix.MS_NODEV
+
+	// overlayDir 是保存 overlay 的 upper 和 work 目录的 tmpfs 的父目录（相对于根目录），
+	// 第 n 个 overlay 挂载使用 overlayDir/n
+	overlayDir = ".overlay"
 )
 
 // NewDefaultBuilder 创建一个默认的构建器，预配置了最小根文件系统所需的基本挂载点：
@@ -142,6 +149,48 @@ func (b *Builder) WithTmpfs(target, data string) *Builder {
 	return b
 }
 
+// WithOverlay 添加一个 overlay 挂载，写入保存在 tmpfs 上的 upper 目录中，lowerDirs 本身不会被修改
+// 参数：
+// - lowerDirs: 只读的下层目录（宿主机上的路径），前面的目录覆盖后面的目录
+// - target: 挂载点路径
+// - upperSize: upper 目录所在 tmpfs 的大小限制（字节），为 0 时使用 tmpfs 的默认值
+// 返回构建器自身以支持链式调用
+//
+// 实际添加的挂载点：overlayDir/n 上只有 root 可以访问的 tmpfs，绑定到其中 lower0、lower1...
+// 的只读下层目录，以及使用这些目录和 upper、work 目录的 overlay。所有路径都在新的根目录内，
+// 因此切换根目录之后仍然可以卸载 overlay，清空 upper 目录再重新挂载以丢弃所有写入（见 container 的 Reset）。
+// overlay 使用 userxattr 选项，可以在用户命名空间中挂载（需要 Linux 5.11）
+func (b *Builder) WithOverlay(lowerDirs []string, target string, upperSize uint64) *Builder {
+	n := 0
+	for _, m := range b.Mounts {
+		if m.IsOverlay() {
+			n++
+		}
+	}
+	dir := path.Join(overlayDir, strconv.Itoa(n))
+	data := "mode=0700"
+	if upperSize > 0 {
+		data += ",size=" + strconv.FormatUint(upperSize, 10)
+	}
+	b.WithTmpfs(dir, data)
+
+	lower := make([]string, 0, len(lowerDirs))
+	for i, d := range lowerDirs {
+		l := path.Join(dir, "lower"+strconv.Itoa(i))
+		b.WithBind(d, l, true)
+		lower = append(lower, l)
+	}
+	b.Mounts = append(b.Mounts, Mount{
+		Source: "overlay",
+		Target: target,
+		FsType: "overlay",
+		Flags:  unix.MS_NOSUID | unix.MS_NODEV,
+		Data: fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s,userxattr",
+			strings.Join(lower, ":"), path.Join(dir, "upper"), path.Join(dir, "work")),
+	})
+	return b
+}
+
 // WithProc 添加一个只读的 proc 文件系统挂载
 // 这是 WithProcRW(false) 的快捷方式
 // 返回构建器自身以支持链式调用
@@ -14,6 +14,7 @@ Package mount 提供了在 Linux 系统中管理挂载点的功能，特别适
      * 绑定挂载（bind mount）
      * tmpfs文件系统
      * proc文件系统
+     * overlay文件系统（写入保存在 tmpfs 上，可以在用户命名空间中挂载）
    - 提供默认配置（/usr, /lib, /lib64, /bin）
 
 3. 安全特性：
@@ -26,6 +27,7 @@ Package mount 提供了在 Linux 系统中管理挂载点的功能，特别适
     builder := mount.NewDefaultBuilder().
         WithBind("/usr", "usr", true).      // 只读绑定挂载
         WithTmpfs("tmp", "size=64m").       // 创建临时文件系统
+        WithOverlay([]string{"/data"}, "data", 64<<20). // 写入不会修改 /data
         WithProc()                          // 只读proc文件系统
 
     mounts, err := builder.Build()          // 构建挂载配置
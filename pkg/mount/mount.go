@@ -2,6 +2,8 @@
 package mount
 
 import (
+	"path/filepath"
+	"strings"
 	"syscall"
 )
 
@@ -20,14 +22,14 @@ type Mount struct {
 type SyscallParams struct {
 	Source, Target, FsType, Data *byte // C 风格的字符串指针
 	Flags                        uintptr // 挂载标志
-	Prefixes                     []*byte // 目标路径的所有父目录路径（用于创建挂载点）
+	Prefixes                     []*byte // 挂载前需要创建的目录（overlay 的 upper、work 目录和目标路径的所有父目录）
 	MakeNod                      bool    // 是否需要创建设备节点（用于文件绑定挂载）
 }
 
 // ToSyscall 将 Mount 结构体转换为系统调用参数
 // 这个方法执行以下操作：
 // 1. 将所有字符串转换为 C 风格的字节指针
-// 2. 获取目标路径的所有父目录
+// 2. 获取目标路径的所有父目录（overlay 挂载还包括 upper 和 work 目录）
 // 3. 返回可直接用于系统调用的参数集
 func (m *Mount) ToSyscall() (*SyscallParams, error) {
 	var data *byte
@@ -53,8 +55,12 @@ func (m *Mount) ToSyscall() (*SyscallParams, error) {
 			return nil, err
 		}
 	}
-	// 获取目标路径的所有父目录
-	prefix := pathPrefix(m.Target)
+	// 获取目标路径的所有父目录，目标路径必须是最后一个（见 MakeNod）
+	var prefix []string
+	if upper, work := m.OverlayDirs(); upper != "" && work != "" {
+		prefix = append(pathPrefix(upper), pathPrefix(work)...)
+	}
+	prefix = append(prefix, pathPrefix(m.Target)...)
 	// 将所有路径转换为 C 风格的字符串
 	paths, err := arrayPtrFromStrings(prefix)
 	if err != nil {
@@ -99,3 +105,93 @@ func arrayPtrFromStrings(str []string) ([]*byte, error) {
 	}
 	return bytes, nil
 }
+
+// IsOverlay 判断是否为 overlay 文件系统
+func (m Mount) IsOverlay() bool {
+	return m.FsType == "overlay"
+}
+
+// OverlayDirs 返回 overlay 挂载选项中的 upper 和 work 目录（只读的 overlay 没有）
+func (m Mount) OverlayDirs() (upper, work string) {
+	if !m.IsOverlay() {
+		return "", ""
+	}
+	for _, o := range splitOptions(m.Data) {
+		if v, ok := strings.CutPrefix(o, "upperdir="); ok {
+			upper = unescapeOverlayPath(v)
+		} else if v, ok := strings.CutPrefix(o, "workdir="); ok {
+			work = unescapeOverlayPath(v)
+		}
+	}
+	return upper, work
+}
+
+// WithRoot 返回目标路径位于 root 下的挂载点，overlay 的 lower、upper 和 work 目录同样加上 root，
+// 使挂载不依赖于当前工作目录
+func (m Mount) WithRoot(root string) Mount {
+	m.Target = filepath.Join(root, m.Target)
+	if !m.IsOverlay() {
+		return m
+	}
+	opts := splitOptions(m.Data)
+	for i, o := range opts {
+		k, v, ok := strings.Cut(o, "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "lowerdir":
+			dirs := splitEscaped(v, ':')
+			for j, d := range dirs {
+				dirs[j] = filepath.Join(root, d)
+			}
+			opts[i] = k + "=" + strings.Join(dirs, ":")
+		case "upperdir", "workdir":
+			opts[i] = k + "=" + filepath.Join(root, v)
+		}
+	}
+	m.Data = strings.Join(opts, ",")
+	return m
+}
+
+// splitOptions 按照没有转义的逗号分割挂载选项
+func splitOptions(data string) []string {
+	return splitEscaped(data, ',')
+}
+
+// splitEscaped 按照没有转义的分隔符分割字符串，转义字符保留在结果中
+func splitEscaped(data string, sep byte) []string {
+	var (
+		ret []string
+		sb  strings.Builder
+	)
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; {
+		case c == '\\' && i+1 < len(data):
+			sb.WriteByte(c)
+			sb.WriteByte(data[i+1])
+			i++
+		case c == sep:
+			ret = append(ret, sb.String())
+			sb.Reset()
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	if data != "" {
+		ret = append(ret, sb.String())
+	}
+	return ret
+}
+
+// unescapeOverlayPath 去掉 overlay 挂载选项中路径的转义字符（\\、\: 和 \,）
+func unescapeOverlayPath(p string) string {
+	var sb strings.Builder
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' && i+1 < len(p) {
+			i++
+		}
+		sb.WriteByte(p[i])
+	}
+	return sb.String()
+}
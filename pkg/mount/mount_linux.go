@@ -14,6 +14,14 @@ func (m *Mount) Mount() error {
 	if err := ensureMountTargetExists(m.Source, m.Target); err != nil {
 		return fmt.Errorf("mkdir: %w", err)
 	}
+	// 确保 overlay 的 upper 和 work 目录存在
+	if upper, work := m.OverlayDirs(); upper != "" && work != "" {
+		for _, d := range []string{upper, work} {
+			if err := os.MkdirAll(d, 0755); err != nil {
+				return fmt.Errorf("mkdir: %w", err)
+			}
+		}
+	}
 	// 执行挂载系统调用
 	if err := syscall.Mount(m.Source, m.Target, m.FsType, m.Flags, m.Data); err != nil {
 		return fmt.Errorf("mount: %w", err)
@@ -98,6 +106,9 @@ func (m Mount) String() string {
 	case m.FsType == "proc":
 		return fmt.Sprintf("proc[%s]", flag)
 
+	case m.IsOverlay():
+		return fmt.Sprintf("overlay[%s:%s]", m.Target, m.Data)
+
 	default:
 		return fmt.Sprintf("mount[%s,%s:%s:%x,%s]", m.FsType, m.Source, m.Target, m.Flags, m.Data)
 	}
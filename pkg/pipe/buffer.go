@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync"
 )
 
 // Buffer 用于创建一个可写的管道，并将最多 max 字节的数据读取到缓冲区中
@@ -15,6 +16,12 @@ type Buffer struct {
 	Buffer *bytes.Buffer  // 用于存储读取的数据的缓冲区
 	Done   <-chan struct{} // 信号通道，当读取完成时关闭
 	Max    int64          // 最大允许读取的字节数
+
+	// TeeDone 在读取完成、并且读取到的数据全部写入 tee 后关闭（没有 tee 时与 Done 相同）
+	// tee 的写入可能一直阻塞（例如没有人读取的管道），读取不会因此阻塞，等待 TeeDone 时应当设置超时
+	TeeDone <-chan struct{}
+
+	r *os.File // 管道的读取端，用于 CloseRead
 }
 
 // NewPipe 创建一个管道，并启动一个 goroutine 将其读取端的数据复制到指定的 writer
@@ -27,10 +34,16 @@ type Buffer struct {
 //   - error: 错误信息
 // 注意：调用者需要负责关闭返回的写入端（w）
 func NewPipe(writer io.Writer, n int64) (<-chan struct{}, *os.File, error) {
+	done, _, w, err := newPipe(writer, n)
+	return done, w, err
+}
+
+// newPipe 与 NewPipe 相同，同时返回读取端
+func newPipe(writer io.Writer, n int64) (<-chan struct{}, *os.File, *os.File, error) {
 	// 创建一个新的操作系统管道
 	r, w, err := os.Pipe()
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	
 	// 创建完成信号通道
@@ -48,7 +61,7 @@ func NewPipe(writer io.Writer, n int64) (<-chan struct{}, *os.File, error) {
 		r.Close()
 	}()
 	
-	return done, w, nil
+	return done, r, w, nil
 }
 
 // NewBuffer 创建一个新的 Buffer，它包含一个 OS 管道和一个字节缓冲区
@@ -59,24 +72,112 @@ func NewPipe(writer io.Writer, n int64) (<-chan struct{}, *os.File, error) {
 //   - error: 错误信息
 // 注意：如果依赖 done 通道来判断完成，需要在父进程中关闭写入端
 func NewBuffer(max int64) (*Buffer, error) {
+	return NewTeeBuffer(max, nil)
+}
+
+// NewTeeBuffer 与 NewBuffer 相同，同时将读取到的前 max 字节实时写入 tee（为 nil 时不写入）
+// 写入 tee 的错误被忽略，不影响缓冲区的读取
+func NewTeeBuffer(max int64, tee io.Writer) (*Buffer, error) {
 	// 创建字节缓冲区
 	buffer := new(bytes.Buffer)
-	// 创建管道，最大读取字节数加1（可能用于检测是否超出限制）
-	done, w, err := NewPipe(buffer, max+1)
+	var (
+		writer io.Writer = buffer
+		t      *teeWriter
+	)
+	if tee != nil {
+		t = &teeWriter{buffer: buffer, left: max, wake: make(chan struct{}, 1)}
+		writer = t
+	}
+	// 创建管道，最大读取字节数加1（用于检测是否超出限制）
+	done, r, w, err := newPipe(writer, max+1)
 	if err != nil {
 		return nil, err
 	}
-	
+
+	teeDone := done
+	if t != nil {
+		ch := make(chan struct{})
+		go t.run(tee, done, ch)
+		teeDone = ch
+	}
+
 	return &Buffer{
-		W:      w,      // 管道写入端
-		Max:    max,    // 最大字节数限制
-		Buffer: buffer, // 数据缓冲区
-		Done:   done,   // 完成信号通道
+		W:       w,       // 管道写入端
+		Max:     max,     // 最大字节数限制
+		Buffer:  buffer,  // 数据缓冲区
+		Done:    done,    // 完成信号通道
+		TeeDone: teeDone, // tee 写入完成信号通道
+		r:       r,
 	}, nil
 }
 
+// teeWriter 写入缓冲区，并将最多 left 字节交给 run 转发到 tee
+// 转发在单独的 goroutine 中进行，tee 阻塞时不影响管道的读取
+type teeWriter struct {
+	buffer *bytes.Buffer
+	left   int64
+
+	mu      sync.Mutex
+	pending []byte        // 尚未写入 tee 的数据，总量不超过 max
+	wake    chan struct{} // 有新数据时发送信号
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	if t.left > 0 {
+		q := p
+		if int64(len(q)) > t.left {
+			q = q[:t.left]
+		}
+		t.left -= int64(len(q))
+		t.mu.Lock()
+		t.pending = append(t.pending, q...)
+		t.mu.Unlock()
+		select {
+		case t.wake <- struct{}{}:
+		default:
+		}
+	}
+	return t.buffer.Write(p)
+}
+
+// run 将数据写入 tee，读取完成（done 关闭）并写完剩余数据后关闭 teeDone
+// 写入 tee 的错误被忽略
+func (t *teeWriter) run(tee io.Writer, done <-chan struct{}, teeDone chan<- struct{}) {
+	defer close(teeDone)
+	for {
+		finished := false
+		select {
+		case <-t.wake:
+		case <-done:
+			finished = true
+		}
+		t.mu.Lock()
+		p := t.pending
+		t.pending = nil
+		t.mu.Unlock()
+		if len(p) > 0 {
+			tee.Write(p)
+		}
+		if finished {
+			return
+		}
+	}
+}
+
+// CloseRead 关闭管道的读取端，结束读取，之后 Done 很快被关闭
+// 用于写入端被其他进程持有、无法等待读取到文件末尾的情况，未读取的数据被丢弃
+func (b *Buffer) CloseRead() error {
+	return b.r.Close()
+}
+
 // String 实现 Stringer 接口，返回 Buffer 的当前状态字符串
 // 格式为：Buffer[当前字节数/最大字节数]
 func (b Buffer) String() string {
 	return fmt.Sprintf("Buffer[%d/%d]", b.Buffer.Len(), b.Max)
 }
+
+// Exceeded 返回写入管道的数据是否超过了 Max 字节
+// 只有在 Done 关闭之后调用才有意义（此时不会再写入缓冲区）
+func (b Buffer) Exceeded() bool {
+	return int64(b.Buffer.Len()) > b.Max
+}
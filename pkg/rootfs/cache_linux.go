@@ -0,0 +1,232 @@
+package rootfs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zqzqsb/sandbox/pkg/mount"
+)
+
+// Cache 将镜像解压到 Dir 下以内容摘要命名的目录中
+// 目录结构：
+// - Dir/sha256/<hex>: 解压完成的根文件系统
+// - Dir/tmp: 解压中的临时目录
+type Cache struct {
+	Dir string
+}
+
+// Rootfs 是解压完成的根文件系统
+type Rootfs struct {
+	Digest string   // 镜像清单或 tar 包的摘要（sha256:<hex>）
+	Path   string   // 宿主机上的目录
+	Env    []string // 镜像配置中的环境变量（同时写入 /.env）
+}
+
+// skipBinds 是 WithBinds 跳过的顶层目录，由沙箱自己提供
+var skipBinds = map[string]bool{
+	"dev":  true,
+	"proc": true,
+	"sys":  true,
+}
+
+// FromOCI 解压 OCI 镜像布局目录 dir 中标签为 ref 的镜像
+// ref 为空时布局中只能有一个镜像，多平台镜像选择与当前平台匹配的清单
+func (c *Cache) FromOCI(dir, ref string) (*Rootfs, error) {
+	l := layout{dir: dir}
+	md, err := l.manifest(ref)
+	if err != nil {
+		return nil, fmt.Errorf("rootfs: %v", err)
+	}
+	var m manifest
+	if err := l.readJSON(md, &m); err != nil {
+		return nil, fmt.Errorf("rootfs: manifest %v", err)
+	}
+	var conf imageConfig
+	if err := l.readJSON(m.Config, &conf); err != nil {
+		return nil, fmt.Errorf("rootfs: config %v", err)
+	}
+	for _, d := range m.Layers {
+		switch d.MediaType {
+		case mediaTypeLayer, mediaTypeLayerGzip, mediaTypeDockerLayerGzip:
+		default:
+			return nil, fmt.Errorf("rootfs: layer %s: unsupported media type %q", d.Digest, d.MediaType)
+		}
+	}
+
+	return c.unpack(md.Digest, conf.Config.Env, func(root string) error {
+		for _, d := range m.Layers {
+			if err := extractBlob(root, l, d); err != nil {
+				return fmt.Errorf("layer %s: %v", d.Digest, err)
+			}
+		}
+		return nil
+	})
+}
+
+// FromTar 解压 tar 包（可以是 gzip 压缩的）作为根文件系统，tar 包中的 whiteout 同样会被处理
+func (c *Cache) FromTar(path string) (*Rootfs, error) {
+	digest, err := fileDigest(path)
+	if err != nil {
+		return nil, fmt.Errorf("rootfs: %v", err)
+	}
+	return c.unpack(digest, nil, func(root string) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		r, err := decompress(f)
+		if err != nil {
+			return err
+		}
+		return extractLayer(root, r)
+	})
+}
+
+// unpack 返回以 digest 命名的根文件系统，不存在时调用 extract 解压到临时目录后重命名
+func (c *Cache) unpack(digest string, env []string, extract func(root string) error) (*Rootfs, error) {
+	h, err := digestHex(digest)
+	if err != nil {
+		return nil, fmt.Errorf("rootfs: %v", err)
+	}
+	r := &Rootfs{
+		Digest: digest,
+		Path:   filepath.Join(c.Dir, "sha256", h),
+		Env:    env,
+	}
+	if _, err := os.Stat(r.Path); err == nil {
+		return r, nil
+	}
+
+	for _, d := range []string{"sha256", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(c.Dir, d), 0755); err != nil {
+			return nil, fmt.Errorf("rootfs: %v", err)
+		}
+	}
+	tmp, err := os.MkdirTemp(filepath.Join(c.Dir, "tmp"), h)
+	if err != nil {
+		return nil, fmt.Errorf("rootfs: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return nil, fmt.Errorf("rootfs: %v", err)
+	}
+	if err := extract(tmp); err != nil {
+		return nil, fmt.Errorf("rootfs: %s: %v", digest, err)
+	}
+	if err := writeDotEnv(tmp, env); err != nil {
+		return nil, fmt.Errorf("rootfs: %v", err)
+	}
+
+	// 其他进程可能已经解压完成了同一镜像
+	if err := os.Rename(tmp, r.Path); err != nil {
+		if _, serr := os.Stat(r.Path); serr != nil {
+			return nil, fmt.Errorf("rootfs: %v", err)
+		}
+	}
+	return r, nil
+}
+
+// WithBinds 将根文件系统的顶层目录和文件只读绑定挂载到 b 中
+// dev、proc、sys 由沙箱提供，不会被挂载
+// 顶层的符号链接（如 bin -> usr/bin）在根文件系统内解析后挂载其目标
+func (r *Rootfs) WithBinds(b *mount.Builder) (*mount.Builder, error) {
+	entries, err := os.ReadDir(r.Path)
+	if err != nil {
+		return nil, fmt.Errorf("rootfs: %v", err)
+	}
+	for _, e := range entries {
+		if skipBinds[e.Name()] {
+			continue
+		}
+		source := filepath.Join(r.Path, e.Name())
+		if e.Type()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(source)
+			if err != nil {
+				return nil, fmt.Errorf("rootfs: %v", err)
+			}
+			// 绝对路径和相对路径都相对于根文件系统解析
+			source = filepath.Join(r.Path, filepath.Clean("/"+target))
+			fi, err := os.Lstat(source)
+			if err != nil || fi.Mode()&os.ModeSymlink != 0 {
+				// 悬空或多级的符号链接
+				continue
+			}
+		}
+		b.WithBind(source, e.Name(), true)
+	}
+	return b, nil
+}
+
+// extractBlob 解压一个镜像层
+func extractBlob(root string, l layout, d descriptor) error {
+	blob, err := l.open(d)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	r, err := decompress(blob)
+	if err != nil {
+		return err
+	}
+	if err := extractLayer(root, r); err != nil {
+		return err
+	}
+	// 读完剩余的内容（tar 结尾的填充）以校验摘要
+	_, err = io.Copy(io.Discard, blob)
+	return err
+}
+
+// decompress 根据内容开头的 magic 判断是否是 gzip 压缩的
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeDotEnv 将镜像的环境变量写入 /.env（忽略包含换行的变量）
+func writeDotEnv(root string, env []string) error {
+	if len(env) == 0 {
+		return nil
+	}
+	var sb strings.Builder
+	for _, e := range env {
+		if !strings.Contains(e, "=") || strings.ContainsAny(e, "\r\n") {
+			continue
+		}
+		sb.WriteString(e)
+		sb.WriteByte('\n')
+	}
+	return os.WriteFile(filepath.Join(root, ".env"), []byte(sb.String()), 0644)
+}
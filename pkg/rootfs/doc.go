@@ -0,0 +1,36 @@
+/*
+Package rootfs 将本地的 OCI 镜像布局（OCI image layout）或普通 tar 包解压为容器的根文件系统，
+使沙箱不再依赖宿主机上安装的工具链。
+
+主要功能：
+
+1. 内容寻址的缓存：
+  - 镜像层按照描述符中的 sha256 摘要校验后解压
+  - 根文件系统以镜像清单（或 tar 包）的摘要命名，相同内容只解压一次
+  - 先解压到临时目录再重命名，并发解压同一镜像是安全的
+
+2. 镜像层合并：
+  - 按顺序解压各层，处理 whiteout 文件（.wh.<name>）和不透明目录（.wh..wh..opq）
+  - 拒绝绝对路径、包含 .. 的路径以及经过符号链接写入的路径
+  - 镜像配置中的环境变量写入 /.env，容器会将其作为默认环境变量
+
+3. 挂载：
+  - WithBinds 将根文件系统的顶层目录只读绑定挂载到 mount.Builder 中，
+    结果可以直接用作 container.Builder.Mounts
+
+使用示例：
+
+	cache := rootfs.Cache{Dir: "/var/cache/sandbox/rootfs"}
+	r, err := cache.FromOCI("/images/gcc", "13.2") // 或 cache.FromTar("/images/gcc-13.2.tar.gz")
+	if err != nil {
+		return err
+	}
+	mb, err := r.WithBinds(mount.NewBuilder())
+	if err != nil {
+		return err
+	}
+	b := container.Builder{
+		Mounts: mb.WithTmpfs("w", "").WithTmpfs("tmp", "").Mounts,
+	}
+*/
+package rootfs
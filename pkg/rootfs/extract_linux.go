@@ -0,0 +1,255 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// whiteout 文件的命名规则（见 OCI 镜像规范 layer.md）
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// extractLayer 将一层 tar 解压到 root 上，之前各层的内容被这一层覆盖：
+// - .wh.<name> 删除之前各层中的 <name>
+// - .wh..wh..opq 删除所在目录中之前各层的内容
+// 设备文件被忽略（非特权用户无法创建），不修改文件的所有者
+func extractLayer(root string, r io.Reader) error {
+	// 这一层写入的路径，不透明目录只删除之前各层的内容
+	written := make(map[string]bool)
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name, err := cleanName(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			// 根目录本身
+			continue
+		}
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+
+		switch {
+		case base == whiteoutOpaque:
+			if err := clearOpaque(root, dir, written); err != nil {
+				return err
+			}
+			continue
+
+		case strings.HasPrefix(base, whiteoutPrefix):
+			target := base[len(whiteoutPrefix):]
+			if target == "" || target == "." || target == ".." {
+				return fmt.Errorf("invalid whiteout %q", hdr.Name)
+			}
+			p, err := securePath(root, path.Join(dir, target))
+			if err != nil {
+				return err
+			}
+			if err := os.RemoveAll(p); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := extractEntry(root, name, hdr, tr); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		written[name] = true
+	}
+}
+
+// extractEntry 创建一个 tar 条目，已存在的同名文件（目录除外）会先被删除
+func extractEntry(root, name string, hdr *tar.Header, r io.Reader) error {
+	if err := mkdirAll(root, path.Dir(name)); err != nil {
+		return err
+	}
+	p, err := securePath(root, name)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(hdr.Mode).Perm() | os.FileMode(hdr.Mode)&os.ModeSticky
+
+	fi, err := os.Lstat(p)
+	exists := err == nil
+	if exists && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+		exists = false
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		// 保证之后的条目可以写入目录
+		mode |= 0700
+		if !exists {
+			if err := os.Mkdir(p, mode); err != nil {
+				return err
+			}
+		}
+		return os.Chmod(p, mode)
+
+	case tar.TypeReg:
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL|unix.O_NOFOLLOW, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(p, mode); err != nil {
+			return err
+		}
+
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, p); err != nil {
+			return err
+		}
+
+	case tar.TypeLink:
+		target, err := cleanName(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		src, err := securePath(root, target)
+		if err != nil {
+			return err
+		}
+		if err := os.Link(src, p); err != nil {
+			return err
+		}
+		// 硬链接与目标共享 inode，不修改时间
+		return nil
+
+	case tar.TypeFifo:
+		if err := unix.Mkfifo(p, uint32(mode)); err != nil {
+			return err
+		}
+
+	default:
+		// 设备文件等
+		return nil
+	}
+
+	ts := []unix.Timespec{
+		unix.NsecToTimespec(hdr.AccessTime.UnixNano()),
+		unix.NsecToTimespec(hdr.ModTime.UnixNano()),
+	}
+	if hdr.AccessTime.IsZero() {
+		ts[0] = ts[1]
+	}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, p, ts, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// clearOpaque 删除 dir 中不是这一层写入的内容，这一层写入的子目录递归处理
+func clearOpaque(root, dir string, written map[string]bool) error {
+	p, err := securePath(root, dir)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := path.Join(dir, e.Name())
+		if !written[name] {
+			if err := os.RemoveAll(filepath.Join(p, e.Name())); err != nil {
+				return err
+			}
+			continue
+		}
+		if e.IsDir() {
+			if err := clearOpaque(root, name, written); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cleanName 返回相对于根目录的路径，拒绝绝对路径和越过根目录的路径
+func cleanName(name string) (string, error) {
+	p := path.Clean(strings.TrimPrefix(name, "./"))
+	if path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("invalid path %q", name)
+	}
+	if p == "." {
+		return "", nil
+	}
+	return p, nil
+}
+
+// securePath 返回 name 在 root 下的路径，name 的上级目录中不能有符号链接，
+// 以免写入到 root 之外（name 本身可以是符号链接）
+func securePath(root, name string) (string, error) {
+	p := root
+	parts := strings.Split(name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		if part == "" {
+			continue
+		}
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("path %q through symbolic link", name)
+		}
+	}
+	return filepath.Join(root, name), nil
+}
+
+// mkdirAll 创建 root 下的目录 dir 及其上级目录，不经过符号链接
+func mkdirAll(root, dir string) error {
+	if dir == "." || dir == "" {
+		return nil
+	}
+	p := root
+	for _, part := range strings.Split(dir, "/") {
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			if err := os.Mkdir(p, 0755); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case fi.Mode()&os.ModeSymlink != 0:
+			return fmt.Errorf("path %q through symbolic link", dir)
+		case !fi.IsDir():
+			return fmt.Errorf("%q is not a directory", p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package rootfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// OCI 镜像规范与 Docker 镜像使用的媒体类型
+const (
+	mediaTypeIndex          = "application/vnd.oci.image.index.v1+json"
+	mediaTypeManifest       = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+
+	mediaTypeLayer           = "application/vnd.oci.image.layer.v1.tar"
+	mediaTypeLayerGzip       = "application/vnd.oci.image.layer.v1.tar+gzip"
+	mediaTypeDockerLayerGzip = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+
+	// refNameAnnotation 是 index.json 中镜像标签的注解
+	refNameAnnotation = "org.opencontainers.image.ref.name"
+
+	// maxJSONSize 限制索引、清单和配置文件的大小
+	maxJSONSize = 4 << 20
+
+	// maxIndexDepth 限制嵌套索引的层数
+	maxIndexDepth = 4
+)
+
+// descriptor 是 OCI 内容描述符
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *platform         `json:"platform,omitempty"`
+}
+
+type platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+type index struct {
+	Manifests []descriptor `json:"manifests"`
+}
+
+type manifest struct {
+	Config descriptor   `json:"config"`
+	Layers []descriptor `json:"layers"`
+}
+
+// imageConfig 是镜像配置中用到的部分
+type imageConfig struct {
+	Config struct {
+		Env []string `json:"Env"`
+	} `json:"config"`
+}
+
+// layout 是本地的 OCI 镜像布局目录
+type layout struct {
+	dir string
+}
+
+// manifest 返回标签为 ref 的镜像清单的描述符，ref 为空时布局中只能有一个镜像。
+// 嵌套的索引（多平台镜像）会选出与当前平台匹配的清单
+func (l layout) manifest(ref string) (descriptor, error) {
+	b, err := os.ReadFile(filepath.Join(l.dir, "index.json"))
+	if err != nil {
+		return descriptor{}, err
+	}
+	var idx index
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return descriptor{}, fmt.Errorf("index.json: %v", err)
+	}
+	var ds []descriptor
+	for _, d := range idx.Manifests {
+		if ref == "" || d.Annotations[refNameAnnotation] == ref {
+			ds = append(ds, d)
+		}
+	}
+	ds, err = l.pick(ds, 0)
+	if err != nil {
+		return descriptor{}, err
+	}
+	switch len(ds) {
+	case 0:
+		return descriptor{}, fmt.Errorf("no image %q for %s/%s", ref, runtime.GOOS, runtime.GOARCH)
+	case 1:
+		return ds[0], nil
+	default:
+		return descriptor{}, fmt.Errorf("%d images match %q", len(ds), ref)
+	}
+}
+
+// pick 展开嵌套的索引，返回与当前平台匹配的镜像清单
+func (l layout) pick(ds []descriptor, depth int) ([]descriptor, error) {
+	var ret []descriptor
+	for _, d := range ds {
+		if p := d.Platform; p != nil && (p.OS != runtime.GOOS || p.Architecture != runtime.GOARCH) {
+			continue
+		}
+		switch d.MediaType {
+		case mediaTypeIndex, mediaTypeDockerList:
+			if depth >= maxIndexDepth {
+				return nil, errors.New("index nested too deep")
+			}
+			var idx index
+			if err := l.readJSON(d, &idx); err != nil {
+				return nil, err
+			}
+			sub, err := l.pick(idx.Manifests, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, sub...)
+
+		case mediaTypeManifest, mediaTypeDockerManifest:
+			ret = append(ret, d)
+		}
+	}
+	return ret, nil
+}
+
+// readJSON 读取并校验 JSON 内容
+func (l layout) readJSON(d descriptor, v any) error {
+	if d.Size > maxJSONSize {
+		return fmt.Errorf("%s: too large (%d bytes)", d.Digest, d.Size)
+	}
+	r, err := l.open(d)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s: %v", d.Digest, err)
+	}
+	return nil
+}
+
+// open 打开描述符对应的 blob，读到结尾时校验大小和摘要
+func (l layout) open(d descriptor) (io.ReadCloser, error) {
+	h, err := digestHex(d.Digest)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(l.dir, "blobs", "sha256", h))
+	if err != nil {
+		return nil, err
+	}
+	return &verifiedReader{f: f, h: sha256.New(), digest: d.Digest, remain: d.Size}, nil
+}
+
+// verifiedReader 在读到 EOF 时检查内容与描述符一致
+type verifiedReader struct {
+	f      *os.File
+	h      hash.Hash
+	digest string
+	remain int64
+}
+
+func (r *verifiedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.remain+1 {
+		p = p[:r.remain+1]
+	}
+	n, err := r.f.Read(p)
+	r.h.Write(p[:n])
+	r.remain -= int64(n)
+	if r.remain < 0 {
+		return n, fmt.Errorf("%s: size mismatch", r.digest)
+	}
+	if err == io.EOF {
+		if r.remain != 0 {
+			return n, fmt.Errorf("%s: size mismatch", r.digest)
+		}
+		if got := "sha256:" + hex.EncodeToString(r.h.Sum(nil)); got != r.digest {
+			return n, fmt.Errorf("%s: digest mismatch (got %s)", r.digest, got)
+		}
+	}
+	return n, err
+}
+
+func (r *verifiedReader) Close() error {
+	return r.f.Close()
+}
+
+// digestHex 返回 sha256 摘要的十六进制部分，其他算法或格式错误时返回错误
+func digestHex(digest string) (string, error) {
+	h, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || len(h) != sha256.Size*2 || strings.ToLower(h) != h {
+		return "", fmt.Errorf("unsupported digest %q", digest)
+	}
+	if _, err := hex.DecodeString(h); err != nil {
+		return "", fmt.Errorf("unsupported digest %q", digest)
+	}
+	return h, nil
+}
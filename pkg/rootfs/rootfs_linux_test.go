@@ -0,0 +1,301 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zqzqsb/sandbox/pkg/mount"
+)
+
+type tarEntry struct {
+	name, body, link string
+	typ              byte
+}
+
+func makeTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typ, Linkname: e.link, Mode: 0644}
+		switch e.typ {
+		case tar.TypeDir:
+			hdr.Mode = 0755
+		case tar.TypeReg:
+			hdr.Size = int64(len(e.body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeBlob 将内容写入布局目录并返回其描述符
+func writeBlob(t *testing.T, dir, mediaType string, b []byte) descriptor {
+	t.Helper()
+	sum := sha256.Sum256(b)
+	h := hex.EncodeToString(sum[:])
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "blobs", "sha256", h), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return descriptor{MediaType: mediaType, Digest: "sha256:" + h, Size: int64(len(b))}
+}
+
+func writeJSONBlob(t *testing.T, dir, mediaType string, v any) descriptor {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeBlob(t, dir, mediaType, b)
+}
+
+// makeLayout 创建一个包含两层的 OCI 镜像布局，第二层删除和覆盖第一层的内容
+func makeLayout(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	l1 := makeTar(t, []tarEntry{
+		{name: "./", typ: tar.TypeDir},
+		{name: "usr/", typ: tar.TypeDir},
+		{name: "usr/bin/", typ: tar.TypeDir},
+		{name: "usr/bin/sh", typ: tar.TypeReg, body: "sh"},
+		{name: "usr/bin/old", typ: tar.TypeReg, body: "old"},
+		{name: "bin", typ: tar.TypeSymlink, link: "usr/bin"},
+		{name: "etc/", typ: tar.TypeDir},
+		{name: "etc/conf", typ: tar.TypeReg, body: "v1"},
+		{name: "etc/conf.d/", typ: tar.TypeDir},
+		{name: "etc/conf.d/a", typ: tar.TypeReg, body: "a"},
+		{name: "dev/", typ: tar.TypeDir},
+	})
+	l2 := makeTar(t, []tarEntry{
+		{name: "usr/bin/.wh.old", typ: tar.TypeReg},
+		{name: "usr/bin/cc", typ: tar.TypeReg, body: "cc"},
+		{name: "usr/bin/c++", typ: tar.TypeLink, link: "usr/bin/cc"},
+		{name: "etc/conf", typ: tar.TypeReg, body: "v2"},
+		{name: "etc/conf.d/b", typ: tar.TypeReg, body: "b"},
+		{name: "etc/conf.d/.wh..wh..opq", typ: tar.TypeReg},
+	})
+
+	conf := writeJSONBlob(t, dir, "application/vnd.oci.image.config.v1+json", map[string]any{
+		"config": map[string]any{"Env": []string{"PATH=/usr/bin:/bin", "LANG=C"}},
+	})
+	m := writeJSONBlob(t, dir, mediaTypeManifest, map[string]any{
+		"schemaVersion": 2,
+		"config":        conf,
+		"layers": []descriptor{
+			writeBlob(t, dir, mediaTypeLayer, l1),
+			writeBlob(t, dir, mediaTypeLayerGzip, gzipBytes(t, l2)),
+		},
+	})
+	m.Annotations = map[string]string{refNameAnnotation: "latest"}
+	b, err := json.Marshal(index{Manifests: []descriptor{m}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// listTree 返回目录中所有文件的相对路径和内容（目录和符号链接用后缀标记）
+func listTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	ret := make(map[string]string)
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || p == root {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		switch {
+		case fi.IsDir():
+			ret[rel+"/"] = ""
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			ret[rel+"@"] = target
+		default:
+			b, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			ret[rel] = string(b)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func TestFromOCI(t *testing.T) {
+	dir := makeLayout(t)
+	c := Cache{Dir: t.TempDir()}
+
+	r, err := c.FromOCI(dir, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{
+		"usr/":         "",
+		"usr/bin/":     "",
+		"usr/bin/sh":   "sh",
+		"usr/bin/cc":   "cc",
+		"usr/bin/c++":  "cc",
+		"bin@":         "usr/bin",
+		"etc/":         "",
+		"etc/conf":     "v2",
+		"etc/conf.d/":  "",
+		"etc/conf.d/b": "b",
+		"dev/":         "",
+		".env":         "PATH=/usr/bin:/bin\nLANG=C\n",
+	}
+	if got := listTree(t, r.Path); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if exp := []string{"PATH=/usr/bin:/bin", "LANG=C"}; !reflect.DeepEqual(r.Env, exp) {
+		t.Fatalf("expected env %v, got %v", exp, r.Env)
+	}
+
+	// 第二次直接使用缓存
+	r2, err := c.FromOCI(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r2.Path != r.Path || r2.Digest != r.Digest {
+		t.Fatalf("expected cached %v, got %v", r, r2)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(c.Dir, "tmp")); len(entries) != 0 {
+		t.Fatalf("temporary directories left: %v", entries)
+	}
+
+	if _, err := c.FromOCI(dir, "missing"); err == nil {
+		t.Fatal("expected error for missing ref")
+	}
+}
+
+func TestFromOCI_DigestMismatch(t *testing.T) {
+	dir := makeLayout(t)
+
+	// 篡改所有的 tar 层
+	blobs, err := filepath.Glob(filepath.Join(dir, "blobs", "sha256", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range blobs {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i := bytes.Index(b, []byte("v1")); i >= 0 {
+			copy(b[i:], "v3")
+			if err := os.WriteFile(p, b, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	c := Cache{Dir: t.TempDir()}
+	_, err = c.FromOCI(dir, "latest")
+	if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("expected digest mismatch, got %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(c.Dir, "sha256")); len(entries) != 0 {
+		t.Fatalf("rootfs left in cache: %v", entries)
+	}
+}
+
+func TestFromTar(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "rootfs.tar.gz")
+	b := gzipBytes(t, makeTar(t, []tarEntry{
+		{name: "lib/", typ: tar.TypeDir},
+		{name: "lib/libc.so", typ: tar.TypeReg, body: "libc"},
+		{name: "lib64", typ: tar.TypeSymlink, link: "/lib"},
+		{name: "proc/", typ: tar.TypeDir},
+	}))
+	if err := os.WriteFile(p, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := Cache{Dir: t.TempDir()}
+	r, err := c.FromTar(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(r.Path, "lib", "libc.so")); string(got) != "libc" {
+		t.Fatalf("expected libc, got %q", got)
+	}
+
+	mb, err := r.WithBinds(mount.NewBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range mb.Mounts {
+		got = append(got, m.Source+":"+m.Target)
+	}
+	exp := []string{
+		filepath.Join(r.Path, "lib") + ":lib",
+		filepath.Join(r.Path, "lib") + ":lib64",
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestExtractLayer_Unsafe(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{"dotdot", []tarEntry{{name: "../escape", typ: tar.TypeReg, body: "x"}}},
+		{"absolute", []tarEntry{{name: "/escape", typ: tar.TypeReg, body: "x"}}},
+		{"symlink", []tarEntry{
+			{name: "out", typ: tar.TypeSymlink, link: "/tmp"},
+			{name: "out/escape", typ: tar.TypeReg, body: "x"},
+		}},
+		{"link", []tarEntry{{name: "passwd", typ: tar.TypeLink, link: "../../etc/passwd"}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			if err := extractLayer(root, bytes.NewReader(makeTar(t, tc.entries))); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
@@ -13,6 +13,7 @@ const (
 	ActionErrno                   // 返回一个错误码给调用进程
 	ActionTrace                   // 通知跟踪器（如 ptrace）并暂停执行
 	ActionKill                    // 立即终止进程
+	ActionNotify                  // 通知用户态监督者（SECCOMP_RET_USER_NOTIF）并等待其回复
 )
 
 // MsgDisallow 和 MsgHandle 定义了当进程触发 seccomp 过滤器时
@@ -35,3 +36,34 @@ const (
 func (a Action) Action() Action {
 	return Action(a & 0xffff)
 }
+
+// WithReturnCode 返回附带返回码的动作
+// 返回码存放在高 16 位，目前只对 ActionErrno 有效，表示返回给调用进程的错误码
+//
+// 例如：ActionErrno.WithReturnCode(int16(syscall.ENOSYS))
+func (a Action) WithReturnCode(code int16) Action {
+	return a.Action() | Action(uint16(code))<<16
+}
+
+// ReturnCode 返回动作附带的返回码（高 16 位）
+func (a Action) ReturnCode() int16 {
+	return int16(a >> 16)
+}
+
+// String 返回动作的名称
+func (a Action) String() string {
+	switch a.Action() {
+	case ActionAllow:
+		return "allow"
+	case ActionErrno:
+		return "errno"
+	case ActionTrace:
+		return "trace"
+	case ActionKill:
+		return "kill"
+	case ActionNotify:
+		return "notify"
+	default:
+		return "invalid"
+	}
+}
@@ -16,6 +16,7 @@ import (
 //   - ActionAllow -> libseccomp.ActionAllow    (允许系统调用)
 //   - ActionErrno -> libseccomp.ActionErrno    (返回错误)
 //   - ActionTrace -> libseccomp.ActionTrace    (跟踪系统调用)
+//   - ActionNotify -> libseccomp.ActionUserNotify (通知用户态监督者)
 //   - 其他       -> libseccomp.ActionKillProcess (终止进程)
 func ToSeccompAction(a Action) libseccomp.Action {
 	// 提取基本动作（不包含附加数据）
@@ -27,14 +28,15 @@ func ToSeccompAction(a Action) libseccomp.Action {
 		action = libseccomp.ActionErrno   // 返回错误给调用进程
 	case ActionTrace:
 		action = libseccomp.ActionTrace   // 通知 tracer 并暂停执行
+	case ActionNotify:
+		action = libseccomp.ActionUserNotify // 通知用户态监督者并等待回复
 	default:
 		action = libseccomp.ActionKillProcess  // 默认情况：终止进程
 	}
 
 	// 注意：SECCOMP_RET_DATA 存储在返回值的低 16 位
 	// 这部分功能目前在 go-seccomp-bpf 库中并未正式支持
-	// 如果需要设置返回数据，可以使用以下代码：
-	// action = action.WithReturnData(int(a.ReturnCode()))
+	// 返回码在生成 BPF 程序时设置，见 actionRet
 	
 	return action
 }
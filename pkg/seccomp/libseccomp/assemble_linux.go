@@ -0,0 +1,197 @@
+package libseccomp
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"syscall"
+
+	"github.com/elastic/go-seccomp-bpf/arch"
+	"golang.org/x/net/bpf"
+)
+
+// seccomp_data 结构中各字段的偏移量
+//
+//	struct seccomp_data {
+//	    int   nr;                   // 0
+//	    __u32 arch;                 // 4
+//	    __u64 instruction_pointer;  // 8
+//	    __u64 args[6];              // 16
+//	};
+const (
+	offsetNr   = 0
+	offsetArch = 4
+)
+
+// syscallRule 表示编译后的一条规则：系统调用号与对应的返回值
+type syscallRule struct {
+	name string
+	nr   uint32
+	ret  uint32
+}
+
+// syscallGroup 表示一组使用同一动作的系统调用
+type syscallGroup struct {
+	action Action
+	names  []string
+}
+
+// assemble 将 Builder 中的配置编译为 BPF 指令序列
+//
+// 说明：go-seccomp-bpf 的 Policy.Assemble 会在每个 SyscallGroup 末尾插入默认动作的返回指令，
+// 导致只有第一组规则能生效（例如 Trace 组实际上不会被匹配到），架构不匹配时也会跳转到最后一组的动作。
+// 因此这里直接生成指令，程序结构如下：
+//
+//	ld  [arch]
+//	jeq #AUDIT_ARCH, 1, 0
+//	ret #foreign              ; 非本机架构（如 x86_64 上通过 int 0x80 调用的 i386 系统调用）
+//	ld  [nr]
+//	jge #X32_SYSCALL_BIT, 0, 1 ; 仅 x86_64
+//	ret #foreign              ; x32 ABI
+//	jeq #nr1, 0, 1
+//	ret #action1
+//	...
+//	<条件规则代码块>           ; 见 conditionBlock.assemble
+//	ret #default
+//
+// 每个系统调用只占两条指令，所有跳转都是短跳转，不受 255 条指令的跳转距离限制。
+// 同一系统调用出现在多个分组中时，以先出现的分组为准（Allow、Trace、Notify、Errno）。
+// 带有条件规则的系统调用不生成上述两条指令，其分组动作作为条件都不满足时的动作。
+func (b *Builder) assemble() ([]bpf.Instruction, error) {
+	if errInfo != nil {
+		return nil, errInfo
+	}
+
+	rules, err := b.compileRules()
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := b.compileConditionRules()
+	if err != nil {
+		return nil, err
+	}
+
+	foreignRet, err := b.foreignArchRet()
+	if err != nil {
+		return nil, err
+	}
+	defaultRet := actionRet(b.Default)
+	fallback := make(map[uint32]uint32, len(blocks))
+	for _, blk := range blocks {
+		fallback[blk.nr] = defaultRet
+	}
+	program := make([]bpf.Instruction, 0, 2*len(rules)+7)
+
+	// 检查系统调用的架构，其他架构的系统调用号与本机不同，不能按本机的规则匹配
+	program = append(program,
+		bpf.LoadAbsolute{Off: offsetArch, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(info.ID), SkipTrue: 1},
+		bpf.RetConstant{Val: foreignRet},
+		bpf.LoadAbsolute{Off: offsetNr, Size: 4},
+	)
+
+	// x86_64 上禁止通过 x32 ABI 绕过过滤规则
+	if info.ID == arch.X86_64.ID {
+		program = append(program,
+			bpf.JumpIf{Cond: bpf.JumpGreaterOrEqual, Val: uint32(arch.X32.SeccompMask), SkipFalse: 1},
+			bpf.RetConstant{Val: foreignRet},
+		)
+	}
+
+	for _, r := range rules {
+		if _, ok := fallback[r.nr]; ok {
+			fallback[r.nr] = r.ret
+			continue
+		}
+		program = append(program,
+			bpf.JumpIf{Cond: bpf.JumpEqual, Val: r.nr, SkipFalse: 1},
+			bpf.RetConstant{Val: r.ret},
+		)
+	}
+
+	for _, blk := range blocks {
+		code, err := blk.assemble(fallback[blk.nr])
+		if err != nil {
+			return nil, err
+		}
+		program = append(program, code...)
+	}
+	return append(program, bpf.RetConstant{Val: defaultRet}), nil
+}
+
+// compileRules 将分组中的系统调用名称转换为系统调用号
+//
+// 返回：
+//   - []syscallRule: 按分组顺序排列的规则
+//   - error: 系统调用名称不存在或同一分组中重复出现时返回错误
+func (b *Builder) compileRules() ([]syscallRule, error) {
+	groups := []syscallGroup{
+		{action: ActionAllow, names: b.Allow},
+		{action: ActionTrace, names: b.Trace},
+		{action: ActionNotify, names: b.Notify},
+	}
+
+	// 错误码分组按错误码排序，保证生成的程序是确定的
+	errnos := make([]syscall.Errno, 0, len(b.Errno))
+	for e := range b.Errno {
+		errnos = append(errnos, e)
+	}
+	sort.Slice(errnos, func(i, j int) bool { return errnos[i] < errnos[j] })
+	for _, e := range errnos {
+		if e == 0 || e > math.MaxUint16 {
+			return nil, fmt.Errorf("invalid errno %d", e)
+		}
+		groups = append(groups, syscallGroup{action: ActionErrno.WithReturnCode(int16(e)), names: b.Errno[e]})
+	}
+
+	var rules []syscallRule
+	seen := make(map[string]bool)
+	for _, g := range groups {
+		inGroup := make(map[string]bool, len(g.names))
+		for _, name := range g.names {
+			if inGroup[name] {
+				return nil, fmt.Errorf("found duplicate syscall %q in group %v", name, g.action)
+			}
+			inGroup[name] = true
+
+			nr, ok := info.SyscallNames[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown syscall %q on %s", name, info.Name)
+			}
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			rules = append(rules, syscallRule{name: name, nr: uint32(nr), ret: actionRet(g.action)})
+		}
+	}
+	return rules, nil
+}
+
+// foreignArchRet 返回非本机 ABI 系统调用的返回值
+// 未设置 ForeignArch 时终止进程；只允许 ActionKill 和 ActionErrno，
+// 因为跟踪器和用户态监督者都按本机的系统调用号处理系统调用
+func (b *Builder) foreignArchRet() (uint32, error) {
+	switch b.ForeignArch.Action() {
+	case 0:
+		return actionRet(ActionKill), nil
+	case ActionKill, ActionErrno:
+		return actionRet(b.ForeignArch), nil
+	default:
+		return 0, fmt.Errorf("invalid action %v for foreign architecture syscalls", b.ForeignArch)
+	}
+}
+
+// actionRet 返回动作在 BPF 程序中的返回值
+// 对于 ActionErrno，使用动作附带的返回码作为错误码，未指定时与 go-seccomp-bpf 一致返回 EPERM
+func actionRet(a Action) uint32 {
+	ret := uint32(ToSeccompAction(a))
+	if a.Action() == ActionErrno {
+		code := uint32(uint16(a.ReturnCode()))
+		if code == 0 {
+			code = uint32(syscall.EPERM)
+		}
+		ret |= code
+	}
+	return ret
+}
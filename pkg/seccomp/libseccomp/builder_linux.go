@@ -4,47 +4,34 @@ import (
 	"syscall"
 
 	"github.com/zqzqsb/sandbox/pkg/seccomp"
-	libseccomp "github.com/elastic/go-seccomp-bpf"
 	"golang.org/x/net/bpf"
 )
 
 // Builder 用于构建 seccomp 过滤器
 // 采用 Builder 模式，提供简单的接口来创建复杂的过滤规则
 type Builder struct {
-	Allow []string  // 允许执行的系统调用列表
-	Trace []string  // 需要跟踪的系统调用列表
-	Default Action  // 默认动作（当系统调用不在上述列表中时）
-}
+	Allow   []string                   // 允许执行的系统调用列表
+	Trace   []string                   // 需要跟踪的系统调用列表
+	Notify  []string                   // 需要通知用户态监督者的系统调用列表（见 runner/unotify）
+	Errno   map[syscall.Errno][]string // 返回指定错误码的系统调用列表，例如 {syscall.ENOSYS: {"io_uring_setup"}}
+	Rules   []Rule                     // 带有参数条件的规则，优先于上述列表匹配（见 Rule）
+	Default Action                     // 默认动作（当系统调用不在上述列表中时）
 
-// actTrace 定义了跟踪动作的全局变量
-// 使用全局变量可以避免重复创建相同的动作对象
-var actTrace = libseccomp.ActionTrace
+	// ForeignArch 是非本机 ABI 系统调用的动作（如 x86_64 上的 i386、x32 系统调用）
+	// 只能是 ActionKill 或 ActionErrno，为 0 时终止进程
+	ForeignArch Action
+}
 
 // Build 构建过滤器
 // 将 Builder 中的配置转换为可执行的 BPF 过滤器
 //
 // 过程：
-// 1. 创建过滤策略
-// 2. 编译为 BPF 程序
+// 1. 将系统调用名称转换为系统调用号
+// 2. 编译为 BPF 程序（见 assemble）
 // 3. 转换为内核可读格式
 func (b *Builder) Build() (seccomp.Filter, error) {
-	// 创建 libseccomp 策略
-	policy := libseccomp.Policy{
-		DefaultAction: ToSeccompAction(b.Default),  // 设置默认动作
-		Syscalls: []libseccomp.SyscallGroup{
-			{
-				Action: libseccomp.ActionAllow,  // 允许执行的系统调用
-				Names:  b.Allow,
-			},
-			{
-				Action: actTrace,  // 需要跟踪的系统调用
-				Names:  b.Trace,
-			},
-		},
-	}
-
-	// 将策略编译为 BPF 程序
-	program, err := policy.Assemble()
+	// 将配置编译为 BPF 程序
+	program, err := b.assemble()
 	if err != nil {
 		return nil, err
 	}
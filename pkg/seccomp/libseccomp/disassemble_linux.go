@@ -0,0 +1,12 @@
+package libseccomp
+
+import "github.com/zqzqsb/sandbox/pkg/seccomp"
+
+// Disassemble 将过滤器还原为本机架构上每个系统调用的处理方式
+// 系统调用名称通过 ToSyscallName 获得，见 seccomp.Filter.Disassemble
+func Disassemble(f seccomp.Filter) (*seccomp.Policy, error) {
+	if errInfo != nil {
+		return nil, errInfo
+	}
+	return f.Disassemble(uint32(info.ID), ToSyscallName)
+}
@@ -0,0 +1,51 @@
+package libseccomp
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// LoopbackSyscalls 是通过套接字进行本地通信需要的系统调用
+// socket 和 socketpair 不在其中，由 LoopbackRules 限制协议族
+var LoopbackSyscalls = []string{
+	"bind",
+	"listen",
+	"accept",
+	"accept4",
+	"connect",
+	"shutdown",
+	"getsockname",
+	"getpeername",
+	"getsockopt",
+	"setsockopt",
+	"sendto",
+	"recvfrom",
+	"sendmsg",
+	"recvmsg",
+	"sendmmsg",
+	"recvmmsg",
+}
+
+// LoopbackRules 返回只允许创建 AF_UNIX、AF_INET、AF_INET6 套接字的规则，
+// socketpair 只允许 AF_UNIX，其他协议族（如 AF_NETLINK、AF_PACKET）返回 EAFNOSUPPORT
+//
+// seccomp 无法检查 bind、connect 的地址参数，因此需要与只启用了 lo 的网络命名空间
+// 一起使用（见 unshare.Runner 和 container.Builder 的 Loopback），
+// 此时 AF_INET、AF_INET6 套接字只能访问回环地址
+func LoopbackRules() []Rule {
+	deny := ActionErrno.WithReturnCode(int16(syscall.EAFNOSUPPORT))
+	rules := make([]Rule, 0, 5)
+	for _, family := range []uint64{unix.AF_UNIX, unix.AF_INET, unix.AF_INET6} {
+		rules = append(rules, Rule{Name: "socket", Action: ActionAllow, Conditions: []Condition{
+			{Arg: 0, Op: OpEqual, Value: family},
+		}})
+	}
+	return append(rules,
+		Rule{Name: "socket", Action: deny},
+		Rule{Name: "socketpair", Action: ActionAllow, Conditions: []Condition{
+			{Arg: 0, Op: OpEqual, Value: unix.AF_UNIX},
+		}},
+		Rule{Name: "socketpair", Action: deny},
+	)
+}
@@ -0,0 +1,75 @@
+package libseccomp
+
+import "fmt"
+
+// Rule 表示带有参数条件的系统调用规则
+//
+// 同一系统调用的多条规则按照在 Builder.Rules 中出现的顺序依次匹配，
+// 第一条所有条件都满足的规则决定返回的动作。所有规则都不满足时，
+// 如果该系统调用出现在 Allow、Trace 或 Notify 中则使用对应分组的动作，否则使用默认动作。
+//
+// 例如，只允许创建 AF_UNIX 套接字：
+//
+//	Rule{Name: "socket", Action: ActionAllow, Conditions: []Condition{
+//		{Arg: 0, Op: OpEqual, Value: unix.AF_UNIX},
+//	}}
+//
+// 注意：过滤器在 execve 之前加载，对 mmap、mprotect 的限制（如禁止 PROT_EXEC）
+// 同样作用于动态链接器加载共享库的过程，只适用于静态链接的程序。
+type Rule struct {
+	Name       string      // 系统调用名称
+	Action     Action      // 条件全部满足时的动作
+	Conditions []Condition // 参数条件（逻辑与），为空时无条件匹配
+}
+
+// Condition 表示对系统调用某个参数的比较条件
+// 所有比较都按 64 位无符号整数进行
+type Condition struct {
+	Arg   uint   // 参数序号（0-5）
+	Op    Op     // 比较方式
+	Value uint64 // 比较的值
+	Mask  uint64 // 掩码（仅用于 OpMaskedEqual）
+}
+
+// Op 定义了参数条件的比较方式
+type Op int
+
+// 参数条件支持的比较方式
+const (
+	OpEqual        Op = iota + 1 // arg == Value
+	OpNotEqual                   // arg != Value
+	OpGreater                    // arg > Value
+	OpGreaterEqual               // arg >= Value
+	OpLess                       // arg < Value
+	OpLessEqual                  // arg <= Value
+	OpMaskedEqual                // arg & Mask == Value
+)
+
+// String 返回比较方式的名称
+func (o Op) String() string {
+	switch o {
+	case OpEqual:
+		return "=="
+	case OpNotEqual:
+		return "!="
+	case OpGreater:
+		return ">"
+	case OpGreaterEqual:
+		return ">="
+	case OpLess:
+		return "<"
+	case OpLessEqual:
+		return "<="
+	case OpMaskedEqual:
+		return "&=="
+	default:
+		return "invalid"
+	}
+}
+
+func (c Condition) String() string {
+	if c.Op == OpMaskedEqual {
+		return fmt.Sprintf("arg%d & %#x == %#x", c.Arg, c.Mask, c.Value)
+	}
+	return fmt.Sprintf("arg%d %v %#x", c.Arg, c.Op, c.Value)
+}
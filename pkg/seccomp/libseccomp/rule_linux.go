@@ -0,0 +1,206 @@
+package libseccomp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"golang.org/x/net/bpf"
+)
+
+// offsetArgs 是 seccomp_data 中 args 字段的偏移量，每个参数占 8 字节
+const (
+	offsetArgs = 16
+	maxArgs    = 6
+)
+
+// argLowOffset 和 argHighOffset 返回参数低 32 位和高 32 位在 seccomp_data 中的偏移量
+// 内核按本机字节序存放 64 位参数
+func argLowOffset(arg uint) uint32 {
+	off := uint32(offsetArgs + 8*arg)
+	if binary.NativeEndian.Uint16([]byte{0, 1}) == 1 {
+		off += 4 // 大端
+	}
+	return off
+}
+
+func argHighOffset(arg uint) uint32 {
+	return argLowOffset(arg) ^ 4
+}
+
+// conditionBlock 表示同一系统调用的所有条件规则
+type conditionBlock struct {
+	name  string
+	nr    uint32
+	rules []Rule
+}
+
+// condInstruction 是条件判断中的一条指令
+// failTrue / failFalse 表示跳转条件为真 / 假时条件不满足，需要跳过当前规则
+type condInstruction struct {
+	ins       bpf.Instruction
+	failTrue  bool
+	failFalse bool
+}
+
+// compileConditionRules 将 Rules 按系统调用分组，保持系统调用首次出现的顺序
+//
+// 返回：
+//   - []conditionBlock: 每个系统调用对应一个分组
+//   - error: 系统调用名称不存在或条件无效时返回错误
+func (b *Builder) compileConditionRules() ([]conditionBlock, error) {
+	var blocks []conditionBlock
+	index := make(map[string]int)
+	for _, r := range b.Rules {
+		nr, ok := info.SyscallNames[r.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown syscall %q on %s", r.Name, info.Name)
+		}
+		for _, c := range r.Conditions {
+			if c.Arg >= maxArgs {
+				return nil, fmt.Errorf("%s: invalid argument index %d", r.Name, c.Arg)
+			}
+			if c.Op < OpEqual || c.Op > OpMaskedEqual {
+				return nil, fmt.Errorf("%s: invalid operator %d", r.Name, c.Op)
+			}
+		}
+
+		i, ok := index[r.Name]
+		if !ok {
+			i = len(blocks)
+			index[r.Name] = i
+			blocks = append(blocks, conditionBlock{name: r.Name, nr: uint32(nr)})
+		}
+		blocks[i].rules = append(blocks[i].rules, r)
+	}
+	return blocks, nil
+}
+
+// assemble 生成一个系统调用的条件规则代码块，进入代码块时累加器中是系统调用号
+//
+//	jeq #nr, 1, 0
+//	ja  next                  ; 代码块可能超过 255 条指令，使用长跳转
+//	<rule1 的条件>            ; 任一条件不满足时跳到 rule2
+//	ret #action1
+//	<rule2 的条件>
+//	ret #action2
+//	...
+//	ret #fallback
+//	next:
+//
+// 代码块以返回指令结束，因此加载参数覆盖累加器不会影响后续系统调用号的比较。
+func (blk conditionBlock) assemble(fallback uint32) ([]bpf.Instruction, error) {
+	var body []bpf.Instruction
+	for _, r := range blk.rules {
+		code, err := assembleRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", blk.name, err)
+		}
+		body = append(body, code...)
+	}
+	body = append(body, bpf.RetConstant{Val: fallback})
+
+	return append([]bpf.Instruction{
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: blk.nr, SkipTrue: 1},
+		bpf.Jump{Skip: uint32(len(body))},
+	}, body...), nil
+}
+
+// assembleRule 生成一条规则的代码：依次判断各个条件，全部满足时返回规则的动作
+// 任一条件不满足时跳转到返回指令之后
+func assembleRule(r Rule) ([]bpf.Instruction, error) {
+	var code []condInstruction
+	for _, c := range r.Conditions {
+		code = append(code, c.assemble()...)
+	}
+
+	end := len(code) // 返回指令的位置
+	program := make([]bpf.Instruction, 0, end+1)
+	for i, ci := range code {
+		if j, ok := ci.ins.(bpf.JumpIf); ok && (ci.failTrue || ci.failFalse) {
+			skip := end - i // 跳过返回指令
+			if skip > math.MaxUint8 {
+				return nil, fmt.Errorf("too many conditions (%d)", len(r.Conditions))
+			}
+			if ci.failTrue {
+				j.SkipTrue = uint8(skip)
+			}
+			if ci.failFalse {
+				j.SkipFalse = uint8(skip)
+			}
+			ci.ins = j
+		}
+		program = append(program, ci.ins)
+	}
+	return append(program, bpf.RetConstant{Val: actionRet(r.Action)}), nil
+}
+
+// assemble 生成比较 64 位参数的指令，条件满足时顺序执行到下一条指令
+//
+// BPF 只支持 32 位比较，因此先比较高 32 位，高 32 位相等时再比较低 32 位
+func (c Condition) assemble() []condInstruction {
+	var (
+		hi   = uint32(c.Value >> 32)
+		lo   = uint32(c.Value)
+		ldHi = condInstruction{ins: bpf.LoadAbsolute{Off: argHighOffset(c.Arg), Size: 4}}
+		ldLo = condInstruction{ins: bpf.LoadAbsolute{Off: argLowOffset(c.Arg), Size: 4}}
+	)
+	jump := func(cond bpf.JumpTest, val uint32, skipTrue, skipFalse uint8, failTrue, failFalse bool) condInstruction {
+		return condInstruction{
+			ins:       bpf.JumpIf{Cond: cond, Val: val, SkipTrue: skipTrue, SkipFalse: skipFalse},
+			failTrue:  failTrue,
+			failFalse: failFalse,
+		}
+	}
+
+	switch c.Op {
+	case OpEqual:
+		return []condInstruction{
+			ldHi, jump(bpf.JumpEqual, hi, 0, 0, false, true),
+			ldLo, jump(bpf.JumpEqual, lo, 0, 0, false, true),
+		}
+
+	case OpNotEqual:
+		// 高 32 位不同时直接满足
+		return []condInstruction{
+			ldHi, jump(bpf.JumpEqual, hi, 0, 2, false, false),
+			ldLo, jump(bpf.JumpEqual, lo, 0, 0, true, false),
+		}
+
+	case OpMaskedEqual:
+		return []condInstruction{
+			ldHi, {ins: bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: uint32(c.Mask >> 32)}},
+			jump(bpf.JumpEqual, hi, 0, 0, false, true),
+			ldLo, {ins: bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: uint32(c.Mask)}},
+			jump(bpf.JumpEqual, lo, 0, 0, false, true),
+		}
+
+	case OpGreater, OpGreaterEqual:
+		// arg_hi > hi 时满足，arg_hi < hi 时不满足，相等时比较低 32 位
+		cond := bpf.JumpGreaterThan
+		if c.Op == OpGreaterEqual {
+			cond = bpf.JumpGreaterOrEqual
+		}
+		return []condInstruction{
+			ldHi,
+			jump(bpf.JumpGreaterThan, hi, 3, 0, false, false),
+			jump(bpf.JumpEqual, hi, 0, 0, false, true),
+			ldLo,
+			jump(cond, lo, 0, 0, false, true),
+		}
+
+	default: // OpLess, OpLessEqual
+		// arg_hi > hi 时不满足，arg_hi < hi 时满足，相等时比较低 32 位
+		cond := bpf.JumpGreaterOrEqual
+		if c.Op == OpLessEqual {
+			cond = bpf.JumpGreaterThan
+		}
+		return []condInstruction{
+			ldHi,
+			jump(bpf.JumpGreaterThan, hi, 0, 0, true, false),
+			jump(bpf.JumpEqual, hi, 0, 2, false, false),
+			ldLo,
+			jump(cond, lo, 0, 0, true, false),
+		}
+	}
+}
@@ -1,9 +1,14 @@
 package libseccomp
 
 import (
+	"encoding/binary"
+	"syscall"
 	"testing"
 
+	"github.com/elastic/go-seccomp-bpf/arch"
 	"github.com/zqzqsb/sandbox/pkg/seccomp"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
 )
 
 var (
@@ -27,6 +32,320 @@ func TestBuildFilter(t *testing.T) {
 	}
 }
 
+func TestBuildFilterGroups(t *testing.T) {
+	b := Builder{
+		Allow:   []string{"read"},
+		Trace:   []string{"openat"},
+		Notify:  []string{"execve"},
+		Default: ActionKill,
+	}
+	f, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want uint32
+	}{
+		{"read", unix.SECCOMP_RET_ALLOW},
+		{"openat", unix.SECCOMP_RET_TRACE},
+		{"execve", unix.SECCOMP_RET_USER_NOTIF},
+		{"write", unix.SECCOMP_RET_KILL_PROCESS},
+	}
+	for _, tc := range tests {
+		if got := runFilter(t, f, uint32(info.ID), syscallNo(t, tc.name)); got != tc.want {
+			t.Errorf("%s: got action %#x, want %#x", tc.name, got, tc.want)
+		}
+	}
+
+	// 非本机架构使用默认动作
+	if got := runFilter(t, f, 0, syscallNo(t, "read")); got != unix.SECCOMP_RET_KILL_PROCESS {
+		t.Errorf("foreign arch: got action %#x, want kill", got)
+	}
+}
+
+func TestBuildFilterForeignArch(t *testing.T) {
+	// 选择一个非本机的架构
+	foreign := uint32(arch.I386.ID)
+	if info.ID == arch.I386.ID {
+		foreign = uint32(arch.X86_64.ID)
+	}
+	enosys := unix.SECCOMP_RET_ERRNO | uint32(syscall.ENOSYS)
+
+	tests := []struct {
+		action Action
+		want   uint32
+	}{
+		{0, unix.SECCOMP_RET_KILL_PROCESS},
+		{ActionKill, unix.SECCOMP_RET_KILL_PROCESS},
+		{ActionErrno.WithReturnCode(int16(syscall.ENOSYS)), enosys},
+	}
+	for _, tc := range tests {
+		b := Builder{
+			Allow:       []string{"read", "write"},
+			Default:     ActionTrace,
+			ForeignArch: tc.action,
+		}
+		f, err := b.Build()
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+
+		nr := syscallNo(t, "write")
+		if got := runFilter(t, f, uint32(info.ID), nr); got != unix.SECCOMP_RET_ALLOW {
+			t.Errorf("%v native: got action %#x, want allow", tc.action, got)
+		}
+		// 同样的系统调用号在其他架构上可能是任意系统调用（i386 上 1 是 exit、4 是 write）
+		for _, n := range []uint32{0, 1, nr, 11} {
+			if got := runFilter(t, f, foreign, n); got != tc.want {
+				t.Errorf("%v foreign arch nr %d: got action %#x, want %#x", tc.action, n, got, tc.want)
+			}
+		}
+		// x86_64 上的 x32 系统调用与本机使用相同的 AUDIT_ARCH，通过 __X32_SYSCALL_BIT 区分
+		if info.ID == arch.X86_64.ID {
+			for _, n := range []uint32{nr, 0xffffffff} {
+				if got := runFilter(t, f, uint32(info.ID), n|uint32(arch.X32.SeccompMask)); got != tc.want {
+					t.Errorf("%v x32 nr %#x: got action %#x, want %#x", tc.action, n, got, tc.want)
+				}
+			}
+		}
+	}
+
+	for _, a := range []Action{ActionAllow, ActionTrace, ActionNotify} {
+		b := Builder{Default: ActionKill, ForeignArch: a}
+		if _, err := b.Build(); err == nil {
+			t.Errorf("expected error for foreign arch action %v", a)
+		}
+	}
+}
+
+func TestBuildFilterErrno(t *testing.T) {
+	b := Builder{
+		Allow: []string{"read"},
+		Errno: map[syscall.Errno][]string{
+			syscall.ENOSYS: {"io_uring_setup"},
+			syscall.EPERM:  {"socket", "read"},
+		},
+		Rules: []Rule{
+			{Name: "ioctl", Action: ActionErrno.WithReturnCode(int16(syscall.ENOTTY))},
+		},
+		Default: ActionKill,
+	}
+	f, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want uint32
+	}{
+		{"io_uring_setup", unix.SECCOMP_RET_ERRNO | uint32(syscall.ENOSYS)},
+		{"socket", unix.SECCOMP_RET_ERRNO | uint32(syscall.EPERM)},
+		{"ioctl", unix.SECCOMP_RET_ERRNO | uint32(syscall.ENOTTY)},
+		{"read", unix.SECCOMP_RET_ALLOW}, // Allow 分组优先
+		{"write", unix.SECCOMP_RET_KILL_PROCESS},
+	}
+	for _, tc := range tests {
+		if got := runFilter(t, f, uint32(info.ID), syscallNo(t, tc.name)); got != tc.want {
+			t.Errorf("%s: got action %#x, want %#x", tc.name, got, tc.want)
+		}
+	}
+
+	b = Builder{Errno: map[syscall.Errno][]string{0: {"socket"}}, Default: ActionKill}
+	if _, err := b.Build(); err == nil {
+		t.Errorf("expected error for errno 0")
+	}
+}
+
+func TestActionReturnCode(t *testing.T) {
+	a := ActionErrno.WithReturnCode(int16(syscall.EACCES))
+	if a.Action() != ActionErrno || a.ReturnCode() != int16(syscall.EACCES) {
+		t.Errorf("unexpected action %#x", uint32(a))
+	}
+	if got := actionRet(ActionErrno); got != unix.SECCOMP_RET_ERRNO|uint32(syscall.EPERM) {
+		t.Errorf("default errno: got %#x", got)
+	}
+}
+
+func TestBuildFilterRules(t *testing.T) {
+	b := Builder{
+		Allow: []string{"read"},
+		Trace: []string{"mmap"},
+		Rules: []Rule{
+			{Name: "socket", Action: ActionAllow, Conditions: []Condition{
+				{Arg: 0, Op: OpEqual, Value: unix.AF_UNIX},
+			}},
+			{Name: "ioctl", Action: ActionAllow, Conditions: []Condition{
+				{Arg: 1, Op: OpEqual, Value: unix.TCGETS},
+			}},
+			{Name: "clone", Action: ActionAllow, Conditions: []Condition{
+				{Arg: 0, Op: OpMaskedEqual, Mask: unix.CLONE_THREAD, Value: unix.CLONE_THREAD},
+			}},
+			{Name: "mmap", Action: ActionAllow, Conditions: []Condition{
+				{Arg: 2, Op: OpMaskedEqual, Mask: unix.PROT_EXEC, Value: 0},
+			}},
+			{Name: "ioctl", Action: ActionErrno, Conditions: []Condition{
+				{Arg: 1, Op: OpEqual, Value: unix.TIOCSTI},
+			}},
+		},
+		Default: ActionKill,
+	}
+	f, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	const (
+		kill  = unix.SECCOMP_RET_KILL_PROCESS
+		allow = unix.SECCOMP_RET_ALLOW
+		trace = unix.SECCOMP_RET_TRACE
+	)
+	tests := []struct {
+		name string
+		args []uint64
+		want uint32
+	}{
+		{"socket", []uint64{unix.AF_UNIX, unix.SOCK_STREAM}, allow},
+		{"socket", []uint64{unix.AF_INET, unix.SOCK_STREAM}, kill},
+		{"socket", []uint64{1<<32 | unix.AF_UNIX}, kill},
+		{"ioctl", []uint64{0, unix.TCGETS}, allow},
+		{"ioctl", []uint64{0, unix.TIOCSTI}, unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{"ioctl", []uint64{0, unix.TCSETS}, kill},
+		{"clone", []uint64{unix.CLONE_VM | unix.CLONE_THREAD | unix.CLONE_SIGHAND}, allow},
+		{"clone", []uint64{uint64(unix.SIGCHLD)}, kill},
+		{"mmap", []uint64{0, 4096, unix.PROT_READ | unix.PROT_WRITE}, allow},
+		{"mmap", []uint64{0, 4096, unix.PROT_READ | unix.PROT_EXEC}, trace}, // 使用 Trace 分组的动作
+		{"read", nil, allow},
+		{"write", nil, kill},
+	}
+	for _, tc := range tests {
+		if got := runFilter(t, f, uint32(info.ID), syscallNo(t, tc.name), tc.args...); got != tc.want {
+			t.Errorf("%s%#x: got action %#x, want %#x", tc.name, tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestLoopbackRules(t *testing.T) {
+	b := Builder{
+		Allow:   LoopbackSyscalls,
+		Trace:   []string{"socket"},
+		Rules:   LoopbackRules(),
+		Default: ActionKill,
+	}
+	f, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	const (
+		allow = unix.SECCOMP_RET_ALLOW
+		deny  = unix.SECCOMP_RET_ERRNO | uint32(unix.EAFNOSUPPORT)
+	)
+	tests := []struct {
+		name string
+		args []uint64
+		want uint32
+	}{
+		{"socket", []uint64{unix.AF_UNIX, unix.SOCK_STREAM}, allow},
+		{"socket", []uint64{unix.AF_INET, unix.SOCK_STREAM}, allow},
+		{"socket", []uint64{unix.AF_INET6, unix.SOCK_DGRAM}, allow},
+		{"socket", []uint64{unix.AF_NETLINK, unix.SOCK_RAW}, deny}, // 条件规则优先于 Trace 分组
+		{"socket", []uint64{unix.AF_PACKET, unix.SOCK_RAW}, deny},
+		{"socketpair", []uint64{unix.AF_UNIX, unix.SOCK_STREAM}, allow},
+		{"socketpair", []uint64{unix.AF_INET, unix.SOCK_STREAM}, deny},
+		{"connect", nil, allow},
+		{"write", nil, unix.SECCOMP_RET_KILL_PROCESS},
+	}
+	for _, tc := range tests {
+		if got := runFilter(t, f, uint32(info.ID), syscallNo(t, tc.name), tc.args...); got != tc.want {
+			t.Errorf("%s%#x: got action %#x, want %#x", tc.name, tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestBuildFilterConditions(t *testing.T) {
+	const v = 1<<32 | 100
+	values := []uint64{0, 99, 100, 101, 1 << 32, v - 1, v, v + 1, 2 << 32, 2<<32 | 100, 1<<64 - 1}
+	compare := map[Op]func(uint64) bool{
+		OpEqual:        func(a uint64) bool { return a == v },
+		OpNotEqual:     func(a uint64) bool { return a != v },
+		OpGreater:      func(a uint64) bool { return a > v },
+		OpGreaterEqual: func(a uint64) bool { return a >= v },
+		OpLess:         func(a uint64) bool { return a < v },
+		OpLessEqual:    func(a uint64) bool { return a <= v },
+		OpMaskedEqual:  func(a uint64) bool { return a&(3<<32|0xff) == v },
+	}
+
+	for op, cmp := range compare {
+		c := Condition{Arg: 3, Op: op, Value: v, Mask: 3<<32 | 0xff}
+		b := Builder{
+			Rules:   []Rule{{Name: "write", Action: ActionAllow, Conditions: []Condition{c}}},
+			Default: ActionKill,
+		}
+		f, err := b.Build()
+		if err != nil {
+			t.Fatalf("%v: Build failed: %v", c, err)
+		}
+		for _, a := range values {
+			want := uint32(unix.SECCOMP_RET_KILL_PROCESS)
+			if cmp(a) {
+				want = unix.SECCOMP_RET_ALLOW
+			}
+			if got := runFilter(t, f, uint32(info.ID), syscallNo(t, "write"), 0, 0, 0, a); got != want {
+				t.Errorf("%v with arg3=%#x: got action %#x, want %#x", c, a, got, want)
+			}
+		}
+	}
+}
+
+func TestBuildFilterLongRules(t *testing.T) {
+	// 条件规则代码块超过 255 条指令时仍然可以跳过
+	var rules []Rule
+	for i := 0; i < 100; i++ {
+		rules = append(rules, Rule{Name: "ioctl", Action: ActionAllow, Conditions: []Condition{
+			{Arg: 1, Op: OpEqual, Value: uint64(i)},
+		}})
+	}
+	b := Builder{
+		Allow:   []string{"read"},
+		Rules:   append(rules, Rule{Name: "write", Action: ActionAllow}),
+		Default: ActionKill,
+	}
+	f, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	for _, tc := range []struct {
+		name string
+		arg  uint64
+		want uint32
+	}{
+		{"ioctl", 99, unix.SECCOMP_RET_ALLOW},
+		{"ioctl", 100, unix.SECCOMP_RET_KILL_PROCESS},
+		{"write", 0, unix.SECCOMP_RET_ALLOW},
+		{"read", 0, unix.SECCOMP_RET_ALLOW},
+	} {
+		if got := runFilter(t, f, uint32(info.ID), syscallNo(t, tc.name), 0, tc.arg); got != tc.want {
+			t.Errorf("%s(%d): got action %#x, want %#x", tc.name, tc.arg, got, tc.want)
+		}
+	}
+}
+
+func TestBuildFilterInvalidRules(t *testing.T) {
+	for _, r := range []Rule{
+		{Name: "no_such_syscall", Action: ActionAllow},
+		{Name: "socket", Action: ActionAllow, Conditions: []Condition{{Arg: 6, Op: OpEqual}}},
+		{Name: "socket", Action: ActionAllow, Conditions: []Condition{{Arg: 0}}},
+	} {
+		b := Builder{Rules: []Rule{r}, Default: ActionKill}
+		if _, err := b.Build(); err == nil {
+			t.Errorf("expected error for rule %+v", r)
+		}
+	}
+}
+
 // BenchmarkBuildDefaultFilter is about 0.2ms/op
 func BenchmarkBuildDefaultFilter(b *testing.B) {
 	for i := 0; i < b.N; i++ {
@@ -47,3 +366,116 @@ func buildFilterMock() (seccomp.Filter, error) {
 	}
 	return b.Build()
 }
+
+// runFilter 使用 BPF 虚拟机对构造的 seccomp_data 执行过滤器，返回过滤器的返回值
+func runFilter(t *testing.T, f seccomp.Filter, arch uint32, nr uint32, args ...uint64) uint32 {
+	t.Helper()
+
+	raw := make([]bpf.RawInstruction, 0, len(f))
+	for _, i := range f {
+		raw = append(raw, bpf.RawInstruction{Op: i.Code, Jt: i.Jt, Jf: i.Jf, K: i.K})
+	}
+	insts, ok := bpf.Disassemble(raw)
+	if !ok {
+		t.Fatalf("failed to disassemble filter")
+	}
+	vm, err := bpf.NewVM(insts)
+	if err != nil {
+		t.Fatalf("failed to create vm: %v", err)
+	}
+
+	// struct seccomp_data：内核按本机字节序（小端）读取，而 bpf.VM 按大端读取 32 位字，
+	// 因此这里逐个 32 位字以大端写入，参数的低 32 位在前
+	data := make([]byte, 64)
+	binary.BigEndian.PutUint32(data[0:], nr)
+	binary.BigEndian.PutUint32(data[4:], arch)
+	for i, a := range args {
+		binary.BigEndian.PutUint32(data[16+8*i:], uint32(a))
+		binary.BigEndian.PutUint32(data[20+8*i:], uint32(a>>32))
+	}
+	ret, err := vm.Run(data)
+	if err != nil {
+		t.Fatalf("failed to run filter: %v", err)
+	}
+	return uint32(ret)
+}
+
+func syscallNo(t *testing.T, name string) uint32 {
+	t.Helper()
+
+	nr, ok := info.SyscallNames[name]
+	if !ok {
+		t.Fatalf("unknown syscall %q", name)
+	}
+	return uint32(nr)
+}
+
+func TestDisassemble(t *testing.T) {
+	b := Builder{
+		Allow: []string{"read", "write"},
+		Trace: []string{"openat"},
+		Errno: map[syscall.Errno][]string{syscall.EACCES: {"connect"}},
+		Rules: []Rule{
+			{Name: "socket", Action: ActionAllow, Conditions: []Condition{
+				{Arg: 0, Op: OpEqual, Value: unix.AF_UNIX},
+			}},
+		},
+		Default: ActionKill,
+	}
+	f, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	p, err := Disassemble(f)
+	if err != nil {
+		t.Fatalf("Disassemble failed: %v", err)
+	}
+
+	want := map[string]string{
+		"read":    "allow",
+		"write":   "allow",
+		"openat":  "trace",
+		"connect": "errno(EACCES)",
+		"socket":  "allow|kill_process (args)",
+	}
+	if got := p.Default.String(); got != "<default>                kill_process" {
+		t.Errorf("unexpected default %q", got)
+	}
+	if len(p.Rules) != len(want) {
+		t.Errorf("unexpected rules:\n%v", p)
+	}
+	for _, r := range p.Rules {
+		if got := r.String()[25:]; got != want[r.Name] {
+			t.Errorf("%s: got %q, want %q", r.Name, got, want[r.Name])
+		}
+	}
+
+	// 修改策略后比较差异
+	b.Allow = []string{"read"}
+	b.Errno = map[syscall.Errno][]string{syscall.ENOSYS: {"io_uring_setup"}}
+	f2, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	p2, err := Disassemble(f2)
+	if err != nil {
+		t.Fatalf("Disassemble failed: %v", err)
+	}
+	var changes []string
+	for _, c := range seccomp.Diff(p, p2) {
+		changes = append(changes, c.String())
+	}
+	wantChanges := []string{
+		"write: allow -> kill_process",
+		"connect: errno(EACCES) -> kill_process",
+		"io_uring_setup: kill_process -> errno(ENOSYS)",
+	}
+	if len(changes) != len(wantChanges) {
+		t.Fatalf("unexpected changes %q", changes)
+	}
+	for i := range changes {
+		if changes[i] != wantChanges[i] {
+			t.Errorf("change %d: got %q, want %q", i, changes[i], wantChanges[i])
+		}
+	}
+}
@@ -37,3 +37,16 @@ func ToSyscallName(sysno uint) (string, error) {
 
 	return n, nil
 }
+
+// ToSyscallNo 将系统调用名称转换为当前架构上的系统调用号
+// 系统调用在当前架构上不存在时返回错误（例如 arm64 上的 open）
+func ToSyscallNo(name string) (uint, error) {
+	if errInfo != nil {
+		return 0, errInfo
+	}
+	n, ok := info.SyscallNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown syscall %q on %s", name, info.Name)
+	}
+	return uint(n), nil
+}
@@ -0,0 +1,459 @@
+package seccomp
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// MaxSyscall 是 Disassemble 检查的系统调用号上限（不含）
+const MaxSyscall = 1024
+
+// seccomp_data 中字段的偏移量（见 pkg/seccomp/libseccomp）
+const (
+	offsetNr   = 0
+	offsetArch = 4
+)
+
+// unlistedNr 是用于计算默认动作的系统调用号，不对应任何系统调用，也不带有 x32 标志位
+const unlistedNr = 0x3fffffff
+
+// Rule 表示过滤器对一个系统调用的处理
+type Rule struct {
+	Nr      int      // 系统调用号
+	Name    string   // 系统调用名称
+	Actions []uint32 // 过滤器可能返回的值（从小到大排序）
+
+	// ArgDependent 表示过滤器读取了系统调用参数（或指令地址），
+	// 此时 Actions 列出了所有可能的返回值
+	ArgDependent bool
+}
+
+// Policy 是从过滤器还原出的、内核实际执行的规则
+type Policy struct {
+	Arch    uint32 // 本机的 AUDIT_ARCH
+	Default Rule   // 未列出的系统调用的处理
+	Foreign Rule   // 非本机架构的系统调用的处理
+	Rules   []Rule // 处理方式与 Default 不同的系统调用，按系统调用号排序
+}
+
+// Disassemble 通过对过滤器进行符号执行，还原每个系统调用的处理方式
+//
+// 对 [0, MaxSyscall) 中的每个系统调用号执行过滤器；系统调用参数视为未知值，
+// 依赖参数的条件跳转会同时探索两个分支，因此条件规则会列出所有可能的返回值。
+//
+// 参数：
+//   - arch: 本机的 AUDIT_ARCH
+//   - name: 将系统调用号转换为名称（如 libseccomp.ToSyscallName），返回错误时使用 "#<nr>" 表示
+//
+// 返回：
+//   - *Policy: 还原出的规则
+//   - error: 过滤器包含不支持的指令或跳转越界时返回错误
+func (f Filter) Disassemble(arch uint32, name func(uint) (string, error)) (*Policy, error) {
+	raw := make([]bpf.RawInstruction, 0, len(f))
+	for _, i := range f {
+		raw = append(raw, bpf.RawInstruction{Op: i.Code, Jt: i.Jt, Jf: i.Jf, K: i.K})
+	}
+	insts, ok := bpf.Disassemble(raw)
+	if !ok {
+		return nil, errors.New("filter contains unknown instructions")
+	}
+
+	eval := func(nr, arch value) (Rule, error) {
+		e := evaluator{program: insts, nr: nr, arch: arch, memo: make(map[state][]uint32)}
+		actions, err := e.run(state{})
+		return Rule{Actions: actions, ArgDependent: e.argDependent}, err
+	}
+
+	p := &Policy{Arch: arch}
+	var err error
+	if p.Default, err = eval(known(unlistedNr), known(arch)); err != nil {
+		return nil, err
+	}
+	p.Default.Nr, p.Default.Name = -1, "<default>"
+	if p.Foreign, err = eval(value{}, known(^arch)); err != nil {
+		return nil, err
+	}
+	p.Foreign.Nr, p.Foreign.Name = -1, "<foreign>"
+
+	for nr := 0; nr < MaxSyscall; nr++ {
+		r, err := eval(known(uint32(nr)), known(arch))
+		if err != nil {
+			return nil, fmt.Errorf("syscall %d: %w", nr, err)
+		}
+		if r.equal(p.Default) {
+			continue
+		}
+		r.Nr = nr
+		if r.Name, err = name(uint(nr)); err != nil {
+			r.Name = fmt.Sprintf("#%d", nr)
+		}
+		p.Rules = append(p.Rules, r)
+	}
+	return p, nil
+}
+
+// String 按行输出策略，每行一个系统调用及其动作
+func (p *Policy) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "arch %#x\n", p.Arch)
+	for _, r := range append([]Rule{p.Default, p.Foreign}, p.Rules...) {
+		sb.WriteString(r.String())
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// String 返回 "名称 动作" 格式的描述
+func (r Rule) String() string {
+	return fmt.Sprintf("%-24s %s", r.Name, r.action())
+}
+
+// action 返回动作的描述，依赖参数时动作之间用 "|" 分隔
+func (r Rule) action() string {
+	actions := make([]string, 0, len(r.Actions))
+	for _, a := range r.Actions {
+		actions = append(actions, ActionString(a))
+	}
+	s := strings.Join(actions, "|")
+	if r.ArgDependent {
+		s += " (args)"
+	}
+	return s
+}
+
+func (r Rule) equal(o Rule) bool {
+	if r.ArgDependent != o.ArgDependent || len(r.Actions) != len(o.Actions) {
+		return false
+	}
+	for i := range r.Actions {
+		if r.Actions[i] != o.Actions[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Change 表示同一系统调用在两个策略中的不同处理
+type Change struct {
+	Name     string
+	Old, New Rule
+}
+
+// String 返回 "名称: 旧动作 -> 新动作" 格式的描述
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Name, c.Old.action(), c.New.action())
+}
+
+// Diff 比较两个策略，返回处理方式不同的系统调用（包括默认动作和非本机架构的动作）
+// 两个策略的系统调用名称应来自同一系统调用表
+func Diff(old, new *Policy) []Change {
+	var changes []Change
+	if !old.Default.equal(new.Default) {
+		changes = append(changes, Change{Name: old.Default.Name, Old: old.Default, New: new.Default})
+	}
+	if !old.Foreign.equal(new.Foreign) {
+		changes = append(changes, Change{Name: old.Foreign.Name, Old: old.Foreign, New: new.Foreign})
+	}
+
+	oldRules, newRules := old.ruleMap(), new.ruleMap()
+	nrs := make([]int, 0, len(oldRules)+len(newRules))
+	for nr := range oldRules {
+		nrs = append(nrs, nr)
+	}
+	for nr := range newRules {
+		if _, ok := oldRules[nr]; !ok {
+			nrs = append(nrs, nr)
+		}
+	}
+	sort.Ints(nrs)
+
+	for _, nr := range nrs {
+		o, ok := oldRules[nr]
+		if !ok {
+			o = old.Default
+		}
+		n, ok := newRules[nr]
+		if !ok {
+			n = new.Default
+		}
+		if o.equal(n) {
+			continue
+		}
+		name := o.Name
+		if _, ok := oldRules[nr]; !ok {
+			name = n.Name
+		}
+		changes = append(changes, Change{Name: name, Old: o, New: n})
+	}
+	return changes
+}
+
+func (p *Policy) ruleMap() map[int]Rule {
+	m := make(map[int]Rule, len(p.Rules))
+	for _, r := range p.Rules {
+		m[r.Nr] = r
+	}
+	return m
+}
+
+// ActionString 返回过滤器返回值的可读形式，例如 allow、errno(EPERM)、trace(1)
+func ActionString(ret uint32) string {
+	data := ret & unix.SECCOMP_RET_DATA
+	switch ret & unix.SECCOMP_RET_ACTION_FULL {
+	case unix.SECCOMP_RET_KILL_PROCESS:
+		return "kill_process"
+	case unix.SECCOMP_RET_KILL_THREAD:
+		return "kill_thread"
+	case unix.SECCOMP_RET_TRAP:
+		return withData("trap", data)
+	case unix.SECCOMP_RET_ERRNO:
+		if n := unix.ErrnoName(syscall.Errno(data)); n != "" {
+			return "errno(" + n + ")"
+		}
+		return fmt.Sprintf("errno(%d)", data)
+	case unix.SECCOMP_RET_USER_NOTIF:
+		return "user_notif"
+	case unix.SECCOMP_RET_TRACE:
+		return withData("trace", data)
+	case unix.SECCOMP_RET_LOG:
+		return "log"
+	case unix.SECCOMP_RET_ALLOW:
+		return "allow"
+	default:
+		return fmt.Sprintf("%#x", ret)
+	}
+}
+
+func withData(name string, data uint32) string {
+	if data == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s(%d)", name, data)
+}
+
+// value 表示寄存器中的值，known 为 false 时表示值未知（来自系统调用参数）
+type value struct {
+	v     uint32
+	known bool
+}
+
+func known(v uint32) value {
+	return value{v: v, known: true}
+}
+
+// state 表示 BPF 虚拟机的状态
+type state struct {
+	pc   int
+	a, x value
+	m    [16]value
+}
+
+// evaluator 对过滤器进行符号执行，值未知时同时探索条件跳转的两个分支
+// 由于 BPF 只能向前跳转，执行一定会结束；memo 记录已探索过的状态，避免重复探索
+type evaluator struct {
+	program      []bpf.Instruction
+	nr, arch     value
+	argDependent bool
+	memo         map[state][]uint32
+}
+
+// run 从状态 s 开始执行，返回所有可能的返回值
+func (e *evaluator) run(s state) ([]uint32, error) {
+	if r, ok := e.memo[s]; ok {
+		return r, nil
+	}
+	start := s
+
+	for {
+		if s.pc < 0 || s.pc >= len(e.program) {
+			return nil, fmt.Errorf("pc %d out of range", s.pc)
+		}
+		ins := e.program[s.pc]
+		s.pc++
+
+		switch i := ins.(type) {
+		case bpf.LoadAbsolute:
+			if i.Size != 4 {
+				return nil, fmt.Errorf("unsupported load size %d at %d", i.Size, s.pc-1)
+			}
+			s.a = e.load(i.Off)
+		case bpf.LoadConstant:
+			if i.Dst == bpf.RegA {
+				s.a = known(i.Val)
+			} else {
+				s.x = known(i.Val)
+			}
+		case bpf.LoadScratch:
+			if i.Dst == bpf.RegA {
+				s.a = s.m[i.N]
+			} else {
+				s.x = s.m[i.N]
+			}
+		case bpf.StoreScratch:
+			if i.Src == bpf.RegA {
+				s.m[i.N] = s.a
+			} else {
+				s.m[i.N] = s.x
+			}
+		case bpf.TAX:
+			s.x = s.a
+		case bpf.TXA:
+			s.a = s.x
+		case bpf.NegateA:
+			s.a.v = -s.a.v
+		case bpf.ALUOpConstant:
+			s.a = alu(i.Op, s.a, known(i.Val))
+		case bpf.ALUOpX:
+			s.a = alu(i.Op, s.a, s.x)
+		case bpf.Jump:
+			s.pc += int(i.Skip)
+		case bpf.JumpIf:
+			return e.branch(start, s, s.a, known(i.Val), i.Cond, i.SkipTrue, i.SkipFalse)
+		case bpf.JumpIfX:
+			return e.branch(start, s, s.a, s.x, i.Cond, i.SkipTrue, i.SkipFalse)
+		case bpf.RetConstant:
+			return e.save(start, []uint32{i.Val}), nil
+		case bpf.RetA:
+			if !s.a.known {
+				return nil, fmt.Errorf("return value depends on arguments at %d", s.pc-1)
+			}
+			return e.save(start, []uint32{s.a.v}), nil
+		default:
+			return nil, fmt.Errorf("unsupported instruction %v at %d", ins, s.pc-1)
+		}
+	}
+}
+
+// load 返回 seccomp_data 中偏移量 off 处的 32 位值
+func (e *evaluator) load(off uint32) value {
+	switch off {
+	case offsetNr:
+		return e.nr
+	case offsetArch:
+		return e.arch
+	default:
+		// instruction_pointer 和 args
+		e.argDependent = true
+		return value{}
+	}
+}
+
+// branch 执行条件跳转，比较的值未知时合并两个分支的结果
+func (e *evaluator) branch(start, s state, a, b value, cond bpf.JumpTest, skipTrue, skipFalse uint8) ([]uint32, error) {
+	if a.known && b.known {
+		if compare(cond, a.v, b.v) {
+			s.pc += int(skipTrue)
+		} else {
+			s.pc += int(skipFalse)
+		}
+		r, err := e.run(s)
+		if err != nil {
+			return nil, err
+		}
+		return e.save(start, r), nil
+	}
+
+	t, f := s, s
+	t.pc += int(skipTrue)
+	f.pc += int(skipFalse)
+	rt, err := e.run(t)
+	if err != nil {
+		return nil, err
+	}
+	rf, err := e.run(f)
+	if err != nil {
+		return nil, err
+	}
+	return e.save(start, merge(rt, rf)), nil
+}
+
+func (e *evaluator) save(s state, r []uint32) []uint32 {
+	e.memo[s] = r
+	return r
+}
+
+func compare(cond bpf.JumpTest, a, b uint32) bool {
+	switch cond {
+	case bpf.JumpEqual:
+		return a == b
+	case bpf.JumpNotEqual:
+		return a != b
+	case bpf.JumpGreaterThan:
+		return a > b
+	case bpf.JumpLessThan:
+		return a < b
+	case bpf.JumpGreaterOrEqual:
+		return a >= b
+	case bpf.JumpLessOrEqual:
+		return a <= b
+	case bpf.JumpBitsSet:
+		return a&b != 0
+	case bpf.JumpBitsNotSet:
+		return a&b == 0
+	default:
+		return false
+	}
+}
+
+func alu(op bpf.ALUOp, a, b value) value {
+	if !a.known || !b.known {
+		return value{}
+	}
+	switch op {
+	case bpf.ALUOpAdd:
+		return known(a.v + b.v)
+	case bpf.ALUOpSub:
+		return known(a.v - b.v)
+	case bpf.ALUOpMul:
+		return known(a.v * b.v)
+	case bpf.ALUOpDiv:
+		if b.v == 0 {
+			return known(0)
+		}
+		return known(a.v / b.v)
+	case bpf.ALUOpMod:
+		if b.v == 0 {
+			return known(0)
+		}
+		return known(a.v % b.v)
+	case bpf.ALUOpOr:
+		return known(a.v | b.v)
+	case bpf.ALUOpAnd:
+		return known(a.v & b.v)
+	case bpf.ALUOpShiftLeft:
+		return known(a.v << (b.v & 31))
+	case bpf.ALUOpShiftRight:
+		return known(a.v >> (b.v & 31))
+	case bpf.ALUOpXor:
+		return known(a.v ^ b.v)
+	default:
+		return value{}
+	}
+}
+
+// merge 合并两个有序集合
+func merge(a, b []uint32) []uint32 {
+	r := make([]uint32, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j >= len(b) || (i < len(a) && a[i] < b[j]):
+			r = append(r, a[i])
+			i++
+		case i >= len(a) || b[j] < a[i]:
+			r = append(r, b[j])
+			j++
+		default:
+			r = append(r, a[i])
+			i++
+			j++
+		}
+	}
+	return r
+}
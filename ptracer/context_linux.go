@@ -1,6 +1,7 @@
 package ptracer
 
 import (
+	"bytes"
 	"os"
 	"syscall"
 )
@@ -72,7 +73,7 @@ func (c *Context) GetString(addr uintptr) string {
             }
         } else {
             // ProcessVMReadv 成功，返回读取的字符串
-            return string(buff)
+            return cstring(buff)
         }
     }
 
@@ -81,5 +82,13 @@ func (c *Context) GetString(addr uintptr) string {
     if err := ptraceReadStr(c.Pid, addr, buff); err != nil {
         return ""  // 读取失败返回空字符串
     }
-    return string(buff)  // 返回成功读取的字符串
+    return cstring(buff)  // 返回成功读取的字符串
+}
+
+// cstring 返回缓冲区中第一个 null 字节之前的内容
+func cstring(buff []byte) string {
+	if i := bytes.IndexByte(buff, 0); i >= 0 {
+		buff = buff[:i]
+	}
+	return string(buff)
 }
@@ -98,8 +98,8 @@ trace 函数实现了对目标进程及其子进程的具体跟踪逻辑，包
   5. 收集资源使用情况 */
 
 func (t *Tracer) trace(c context.Context, pgid int) (result runner.Result) {
-	// 创建可取消的子上下文，用于控制跟踪过程
-	cc, cancel := context.WithCancel(c)
+	// 创建可取消的子上下文，用于控制跟踪过程，超出墙上时间限制时自动取消
+	cc, cancel := t.Limit.WithWallTimeLimit(c)
 	defer cancel()
 
 	// 启动 goroutine 监听取消信号
@@ -113,6 +113,8 @@ func (t *Tracer) trace(c context.Context, pgid int) (result runner.Result) {
 	sTime := time.Now()
 	// 创建进程跟踪处理器，管理进程状态
 	ph := newPtraceHandle(t, pgid)
+	// 上一次完整检查资源使用的时间
+	lastCheck := sTime
 
 	// 设置 defer 函数处理 panic 和清理工作
 	defer func() {
@@ -122,6 +124,10 @@ func (t *Tracer) trace(c context.Context, pgid int) (result runner.Result) {
 			result.Status = runner.StatusRunnerError
 			result.Error = fmt.Sprintf("%v", err)
 		}
+		// 程序没有自行退出（例如超出限制）时，实际时间计算到结束跟踪为止
+		if ph.eTime.IsZero() {
+			ph.eTime = time.Now()
+		}
 		// 清理所有进程
 		killAll(pgid)
 		// 回收僵尸进程
@@ -132,7 +138,12 @@ func (t *Tracer) trace(c context.Context, pgid int) (result runner.Result) {
 			result.SetUpTime = ph.fTime.Sub(sTime)
 			// 运行时间：从第一个进程执行到现在
 			result.RunningTime = time.Since(ph.fTime)
+			// 实际时间：从第一个进程执行到主进程退出
+			result.WallTime = ph.eTime.Sub(ph.fTime)
 		}
+		result.ReadBytes = ph.readBytes
+		result.WriteBytes = ph.writeBytes
+		result.Processes = ph.processes
 	}()
 
 	// ptrace 主循环：等待和处理进程事件
@@ -178,11 +189,14 @@ func (t *Tracer) trace(c context.Context, pgid int) (result runner.Result) {
 
 		// 对主进程进行资源使用检查
 		if pid == pgid {
+			// 完整检查（可能读取 cgroup）只在主进程退出时和每隔 usageCheckInterval 进行一次
+			full := wstatus.Exited() || wstatus.Signaled() || time.Since(lastCheck) >= usageCheckInterval
+			if full {
+				lastCheck = time.Now()
+			}
 			// 检查 CPU 时间、内存使用等
-			userTime, userMem, curStatus := t.checkUsage(rusage)
+			curStatus := t.checkUsage(&result, &rusage, full)
 			result.Status = curStatus
-			result.Time = userTime
-			result.Memory = userMem
 			// 如果资源超限，立即返回
 			if curStatus != runner.StatusNormal {
 				return
@@ -192,6 +206,10 @@ func (t *Tracer) trace(c context.Context, pgid int) (result runner.Result) {
 		// 处理进程状态变化
 		status, exitStatus, errStr, finished := ph.handle(pid, wstatus)
 		if finished || status != runner.StatusNormal {
+			// 因超出墙上时间限制被终止
+			if status == runner.StatusSignalled && exitStatus == int(unix.SIGKILL) && runner.WallTimeExceeded(cc) {
+				status = runner.StatusWallTimeLimitExceeded
+			}
 			// 设置结果并返回
 			result.Status = status
 			result.ExitStatus = exitStatus
@@ -201,20 +219,20 @@ func (t *Tracer) trace(c context.Context, pgid int) (result runner.Result) {
 	}
 }
 
-func (t *Tracer) checkUsage(rusage unix.Rusage) (time.Duration, runner.Size, runner.Status) {
-	status := runner.StatusNormal
-	// 更新资源使用情况并检查是否超过限制
-	userTime := time.Duration(rusage.Utime.Nano()) // 纳秒
-	userMem := runner.Size(rusage.Maxrss << 10)    // 字节
+// usageCheckInterval 是两次完整检查资源使用之间的最短间隔
+const usageCheckInterval = 100 * time.Millisecond
 
-	// 检查是否超时/超内存
-	if userTime > t.Limit.TimeLimit {
-		status = runner.StatusTimeLimitExceeded
-	}
-	if userMem > t.Limit.MemoryLimit {
-		status = runner.StatusMemoryLimitExceeded
+// checkUsage 更新资源使用情况并检查限制
+// 每次停止只比较 rusage，full 为 true 时才按照时间统计策略完整检查（可能读取 cgroup）
+func (t *Tracer) checkUsage(result *runner.Result, rusage *unix.Rusage, full bool) runner.Status {
+	// 更新资源使用情况
+	result.SetRusage(rusage)
+
+	if !full && !t.Limit.Exceeded(result) {
+		return runner.StatusNormal
 	}
-	return userTime, userMem, status
+	// 按照时间统计策略检查是否超时/超内存
+	return t.Limit.Check(result)
 }
 
 /*
@@ -277,7 +295,18 @@ func (ph *ptraceHandle) handle(pid int, wstatus unix.WaitStatus) (status runner.
 		// 如果是主进程被信号终止
 		if pid == ph.pgid {
 			finished = true
-			status = runner.StatusSignalled
+			// 与其他运行器一致：超出 RLIMIT_CPU、RLIMIT_FSIZE 和 seccomp 终止的信号对应各自的状态
+			switch sig {
+			case unix.SIGXCPU:
+				status = runner.StatusTimeLimitExceeded
+			case unix.SIGXFSZ:
+				status = runner.StatusOutputLimitExceeded
+			case unix.SIGSYS:
+				status = runner.StatusDisallowedSyscall
+			default:
+				status = runner.StatusSignalled
+			}
+			exitStatus = int(sig)
 			errStr = fmt.Sprintf("process killed by signal %d", sig)
 			return
 		}
@@ -310,7 +339,7 @@ func (ph *ptraceHandle) handle(pid int, wstatus unix.WaitStatus) (status runner.
 		// 如果是 SIGTRAP（跟踪陷阱）
 		if sig == unix.SIGTRAP {
 			// 获取具体的事件类型
-			event := ((wstatus.TrapCause()) >> 8) & 255
+			event := wstatus.TrapCause()
 			
 			// 3.1 seccomp 系统调用过滤器触发
 			if event == unix.PTRACE_EVENT_SECCOMP {
@@ -329,7 +358,17 @@ func (ph *ptraceHandle) handle(pid int, wstatus unix.WaitStatus) (status runner.
 				event == unix.PTRACE_EVENT_VFORK ||
 				event == unix.PTRACE_EVENT_FORK {
 				ph.Handler.Debug("process clone/fork event:", pid)
-			// 3.4 其他 trap 事件
+				// 创建线程时为 PTRACE_EVENT_CLONE，不计入进程数
+				if event != unix.PTRACE_EVENT_CLONE {
+					ph.processes++
+				}
+			// 3.4 主进程即将退出：此时进程仍然存在，读取读写的字节数
+			} else if event == unix.PTRACE_EVENT_EXIT && pid == ph.pgid {
+				var err error
+				ph.eTime = time.Now()
+				ph.readBytes, ph.writeBytes, err = runner.ProcIO(pid)
+				ph.Handler.Debug("process exit event:", pid, "io:", ph.readBytes, ph.writeBytes, err)
+			// 3.5 其他 trap 事件
 			} else {
 				ph.Handler.Debug("process trap:", pid, "event:", event)
 			}
@@ -436,6 +475,9 @@ func collectZombie(pgid int) {
   traced: 记录所有被跟踪的进程
   execved: 标记是否已执行过 exec
   fTime: 第一个进程的启动时间
+  eTime: 主进程的退出时间
+  processes: 创建的进程数（包括主进程）
+  readBytes, writeBytes: 主进程退出时读写的字节数
 */
 
 type ptraceHandle struct {
@@ -444,8 +486,12 @@ type ptraceHandle struct {
 	traced  map[int]bool
 	execved bool
 	fTime   time.Time
+	eTime   time.Time
+
+	processes             int
+	readBytes, writeBytes runner.Size
 }
 
 func newPtraceHandle(t *Tracer, pgid int) *ptraceHandle {
-	return &ptraceHandle{t, pgid, make(map[int]bool), false, time.Time{}}
+	return &ptraceHandle{Tracer: t, pgid: pgid, traced: make(map[int]bool), processes: 1}
 }
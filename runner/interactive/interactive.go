@@ -0,0 +1,171 @@
+// Package interactive 提供交互式评测的运行方式：同时运行选手程序和交互程序，
+// 选手程序的标准输出连接交互程序的标准输入，交互程序的标准输出连接选手程序的标准输入
+package interactive
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/zqzqsb/sandbox/runner"
+)
+
+// Program 创建运行一方程序的运行器
+//
+// stdin 和 stdout 是连接另一方程序的管道，应作为运行器 Files 的 0 和 1。
+// syncFunc 应在程序开始执行前调用，通常直接设置为运行器的 SyncFunc
+// （ptrace.Runner、unshare.Runner 和 container.ExecveParam 都提供了 SyncFunc），
+// 需要加入 cgroup 时在自己的 SyncFunc 中调用它
+type Program func(stdin, stdout *os.File, syncFunc func(pid int) error) (runner.Runner, error)
+
+// Side 表示交互中的一方
+type Side int
+
+// 交互中的双方
+const (
+	SideNone       Side = iota // 没有一方导致综合结果（结果正常）
+	SideContestant             // 选手程序
+	SideInteractor             // 交互程序
+)
+
+func (s Side) String() string {
+	switch s {
+	case SideContestant:
+		return "contestant"
+	case SideInteractor:
+		return "interactor"
+	default:
+		return "none"
+	}
+}
+
+// Result 是交互运行的结果
+type Result struct {
+	Contestant runner.Result // 选手程序的结果
+	Interactor runner.Result // 交互程序的结果
+
+	Status  runner.Status // 综合结果
+	Culprit Side          // 导致综合结果的一方
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("Interactive[%v(%v)][contestant=%v interactor=%v]", r.Status.Name(), r.Culprit, r.Contestant, r.Interactor)
+}
+
+// Run 同时运行选手程序和交互程序，并在双方都结束后返回双方的结果和综合结果
+//
+// 双方程序都开始执行（或者运行结束）后，Run 关闭父进程持有的管道，
+// 因此一方退出后另一方读取时得到 EOF，写入时收到 SIGPIPE
+//
+// 参数：
+//   - c: 上下文，取消时终止双方程序
+//   - contestant: 创建选手程序运行器的函数
+//   - interactor: 创建交互程序运行器的函数
+//
+// 返回：
+//   - Result: 双方的结果和综合结果
+//   - error: 创建管道或运行器失败时的错误
+func Run(c context.Context, contestant, interactor Program) (Result, error) {
+	// c2i: 选手程序 -> 交互程序，i2c: 交互程序 -> 选手程序
+	c2iR, c2iW, err := os.Pipe()
+	if err != nil {
+		return Result{}, err
+	}
+	i2cR, i2cW, err := os.Pipe()
+	if err != nil {
+		c2iR.Close()
+		c2iW.Close()
+		return Result{}, err
+	}
+	files := []*os.File{c2iR, c2iW, i2cR, i2cW}
+	var closeOnce sync.Once
+	closeFiles := func() {
+		closeOnce.Do(func() {
+			for _, f := range files {
+				f.Close()
+			}
+		})
+	}
+	defer closeFiles()
+
+	// 双方都开始执行后关闭父进程持有的管道
+	var (
+		mu      sync.Mutex
+		started [2]bool
+	)
+	markStarted := func(i int) {
+		mu.Lock()
+		defer mu.Unlock()
+		started[i] = true
+		if started[0] && started[1] {
+			closeFiles()
+		}
+	}
+
+	rc, err := contestant(i2cR, c2iW, func(int) error {
+		markStarted(0)
+		return nil
+	})
+	if err != nil {
+		return Result{}, fmt.Errorf("interactive: contestant: %v", err)
+	}
+	ri, err := interactor(c2iR, i2cW, func(int) error {
+		markStarted(1)
+		return nil
+	})
+	if err != nil {
+		return Result{}, fmt.Errorf("interactive: interactor: %v", err)
+	}
+
+	var (
+		result Result
+		wg     sync.WaitGroup
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		result.Contestant = rc.Run(c)
+		// 运行器没有调用 syncFunc 就结束时（例如启动失败）同样视为已经开始
+		markStarted(0)
+	}()
+	go func() {
+		defer wg.Done()
+		result.Interactor = ri.Run(c)
+		markStarted(1)
+	}()
+	wg.Wait()
+
+	result.Status, result.Culprit = Verdict(result.Contestant, result.Interactor)
+	return result, nil
+}
+
+// Verdict 根据双方的结果计算综合结果和导致该结果的一方
+//
+// 规则（按顺序）：
+//  1. 任意一方运行器错误时为运行器错误
+//  2. 选手程序除 SIGPIPE 之外的非正常结果（超出限制、运行时错误等）
+//  3. 交互程序除 SIGPIPE 之外的非正常结果（例如以非零状态退出表示答案错误）
+//  4. 交互程序因为 SIGPIPE 被终止时，选手程序提前结束或关闭了标准输入，归咎于选手程序
+//  5. 选手程序因为 SIGPIPE 被终止时，交互程序已经正常结束，选手程序多余的输出不影响结果
+func Verdict(contestant, interactor runner.Result) (runner.Status, Side) {
+	switch {
+	case contestant.Status == runner.StatusRunnerError:
+		return runner.StatusRunnerError, SideContestant
+	case interactor.Status == runner.StatusRunnerError:
+		return runner.StatusRunnerError, SideInteractor
+	case contestant.Status != runner.StatusNormal && !isSIGPIPE(contestant):
+		return contestant.Status, SideContestant
+	case interactor.Status != runner.StatusNormal && !isSIGPIPE(interactor):
+		return interactor.Status, SideInteractor
+	case isSIGPIPE(interactor):
+		return interactor.Status, SideContestant
+	}
+	return runner.StatusNormal, SideNone
+}
+
+// isSIGPIPE 返回程序是否因为写入已经关闭的管道被 SIGPIPE 终止
+func isSIGPIPE(r runner.Result) bool {
+	return r.Status == runner.StatusSignalled && r.ExitStatus == int(syscall.SIGPIPE)
+}
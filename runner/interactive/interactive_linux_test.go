@@ -0,0 +1,100 @@
+package interactive
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/zqzqsb/sandbox/pkg/seccomp/libseccomp"
+	"github.com/zqzqsb/sandbox/ptracer"
+	"github.com/zqzqsb/sandbox/runner"
+	"github.com/zqzqsb/sandbox/runner/ptrace"
+)
+
+type allowHandler struct{}
+
+func (allowHandler) CheckRead(string) ptracer.TraceAction    { return ptracer.TraceAllow }
+func (allowHandler) CheckWrite(string) ptracer.TraceAction   { return ptracer.TraceAllow }
+func (allowHandler) CheckStat(string) ptracer.TraceAction    { return ptracer.TraceAllow }
+func (allowHandler) CheckSyscall(string) ptracer.TraceAction { return ptracer.TraceAllow }
+
+// shell 返回在 ptrace 运行器中执行 sh -c script 的 Program
+func shell(t *testing.T, script string) Program {
+	filter, err := (&libseccomp.Builder{Trace: []string{"execve"}, Default: libseccomp.ActionAllow}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(stdin, stdout *os.File, syncFunc func(int) error) (runner.Runner, error) {
+		return &ptrace.Runner{
+			Args:     []string{"/bin/sh", "-c", script},
+			Env:      []string{"PATH=/bin:/usr/bin"},
+			Files:    []uintptr{stdin.Fd(), stdout.Fd(), 2},
+			Limit:    runner.Limit{TimeLimit: time.Second, MemoryLimit: 256 << 20, WallTimeLimit: 5 * time.Second},
+			Seccomp:  filter,
+			Handler:  allowHandler{},
+			SyncFunc: syncFunc,
+		}, nil
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name                   string
+		contestant, interactor string
+		status                 runner.Status
+		culprit                Side
+	}{
+		{"accepted", `read x; echo $((x+1))`, `echo 41; read y; test "$y" = 42`, runner.StatusNormal, SideNone},
+		{"wrong answer", `read x; echo $((x+2))`, `echo 41; read y; test "$y" = 42`, runner.StatusNonzeroExitStatus, SideInteractor},
+		// 选手程序提前退出，交互程序读到 EOF
+		{"contestant exits", `exit 0`, `read y || exit 3`, runner.StatusNonzeroExitStatus, SideInteractor},
+		// 交互程序结束后选手程序继续输出，因 SIGPIPE 终止
+		{"contestant sigpipe", `while true; do echo 1; done`, `read y`, runner.StatusNormal, SideNone},
+		// 选手程序关闭标准输入后交互程序写入，因 SIGPIPE 终止
+		{"interactor sigpipe", `exec 0<&-; sleep 0.2`, `sleep 0.1; while true; do echo 1; done`, runner.StatusSignalled, SideContestant},
+		{"contestant error", `exit 1`, `read y`, runner.StatusNonzeroExitStatus, SideContestant},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := Run(context.Background(), shell(t, tc.contestant), shell(t, tc.interactor))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Status != tc.status || result.Culprit != tc.culprit {
+				t.Errorf("expected %v(%v), got %v", tc.status.Name(), tc.culprit, result)
+			}
+		})
+	}
+}
+
+func TestVerdict(t *testing.T) {
+	normal := runner.Result{Status: runner.StatusNormal}
+	sigpipe := runner.Result{Status: runner.StatusSignalled, ExitStatus: int(syscall.SIGPIPE)}
+	tle := runner.Result{Status: runner.StatusTimeLimitExceeded}
+	wa := runner.Result{Status: runner.StatusNonzeroExitStatus, ExitStatus: 1}
+	failed := runner.Result{Status: runner.StatusRunnerError}
+
+	tests := []struct {
+		contestant, interactor runner.Result
+		status                 runner.Status
+		culprit                Side
+	}{
+		{normal, normal, runner.StatusNormal, SideNone},
+		{tle, wa, runner.StatusTimeLimitExceeded, SideContestant},
+		{normal, wa, runner.StatusNonzeroExitStatus, SideInteractor},
+		{sigpipe, normal, runner.StatusNormal, SideNone},
+		{sigpipe, wa, runner.StatusNonzeroExitStatus, SideInteractor},
+		{normal, sigpipe, runner.StatusSignalled, SideContestant},
+		{tle, sigpipe, runner.StatusTimeLimitExceeded, SideContestant},
+		{normal, failed, runner.StatusRunnerError, SideInteractor},
+		{failed, wa, runner.StatusRunnerError, SideContestant},
+	}
+	for i, tc := range tests {
+		status, culprit := Verdict(tc.contestant, tc.interactor)
+		if status != tc.status || culprit != tc.culprit {
+			t.Errorf("%d: expected %v(%v), got %v(%v)", i, tc.status.Name(), tc.culprit, status.Name(), culprit)
+		}
+	}
+}
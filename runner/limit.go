@@ -1,16 +1,133 @@
 package runner
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 )
 
+// TimePolicy selects the CPU time that is compared against Limit.TimeLimit
+type TimePolicy int
+
+// Time policies
+const (
+	TimePolicyUser       TimePolicy = iota // user CPU time (rusage ru_utime)
+	TimePolicyUserSystem                   // user + system CPU time (ru_utime + ru_stime)
+	TimePolicyCgroup                       // cgroup cpu.stat usage_usec
+)
+
+var timePolicyName = []string{
+	"user",
+	"user+system",
+	"cgroup",
+}
+
+func (p TimePolicy) String() string {
+	if p >= 0 && int(p) < len(timePolicyName) {
+		return timePolicyName[p]
+	}
+	return fmt.Sprintf("TimePolicy(%d)", int(p))
+}
+
+// MarshalText encodes the time policy as its name
+func (p TimePolicy) MarshalText() ([]byte, error) {
+	if p < 0 || int(p) >= len(timePolicyName) {
+		return nil, fmt.Errorf("invalid time policy %d", int(p))
+	}
+	return []byte(timePolicyName[p]), nil
+}
+
+// UnmarshalText decodes the time policy from its name
+func (p *TimePolicy) UnmarshalText(b []byte) error {
+	for i, n := range timePolicyName {
+		if n == string(b) {
+			*p = TimePolicy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid time policy %q", b)
+}
+
 // Limit represents the resource limit for traced process
 type Limit struct {
-	TimeLimit   time.Duration // user CPU time limit (in ns)
+	TimeLimit   time.Duration // CPU time limit (in ns), accounted by TimePolicy
 	MemoryLimit Size          // user memory limit (in bytes)
+
+	// WallTimeLimit is the real time limit from the start of the run (0 for
+	// no limit). The runner kills the program when it is exceeded and reports
+	// StatusWallTimeLimitExceeded unless the program exceeded the other limits.
+	// The deadline of the context passed to Run is treated the same way
+	WallTimeLimit time.Duration
+
+	// TimePolicy selects the CPU time compared against TimeLimit
+	TimePolicy TimePolicy
+
+	// CgroupCPUUsage returns the CPU usage of the cgroup of the program.
+	// It is used by TimePolicyCgroup and not sent to containers
+	CgroupCPUUsage func() (time.Duration, error)
 }
 
 func (l Limit) String() string {
-	return fmt.Sprintf("Limit[Time=%v, Memory=%v]", l.TimeLimit, l.MemoryLimit)
+	return fmt.Sprintf("Limit[Time=%v(%v), Memory=%v, WallTime=%v]", l.TimeLimit, l.TimePolicy, l.MemoryLimit, l.WallTimeLimit)
+}
+
+// WithWallTimeLimit returns a context that is done when the wall time limit
+// is exceeded. The runner kills the program when the context is done
+func (l Limit) WithWallTimeLimit(c context.Context) (context.Context, context.CancelFunc) {
+	if l.WallTimeLimit > 0 {
+		return context.WithTimeout(c, l.WallTimeLimit)
+	}
+	return context.WithCancel(c)
+}
+
+// WallTimeExceeded reports whether a program killed by SIGKILL was killed
+// because the context returned by WithWallTimeLimit is past its deadline
+func WallTimeExceeded(c context.Context) bool {
+	return errors.Is(c.Err(), context.DeadlineExceeded)
+}
+
+// Check sets the CPU time of r accounted by the time policy and the policy
+// actually used, and returns the exceeded limit or StatusNormal. Zero limits
+// are not checked.
+//
+// TimePolicyCgroup falls back to TimePolicyUserSystem when the cgroup usage
+// is not available, e.g. inside of a container.
+func (l Limit) Check(r *Result) Status {
+	r.TimePolicy, r.CPUTime = l.cpuTime(r)
+	switch {
+	case l.MemoryLimit > 0 && r.Memory > l.MemoryLimit:
+		return StatusMemoryLimitExceeded
+	case l.TimeLimit > 0 && r.CPUTime > l.TimeLimit:
+		return StatusTimeLimitExceeded
+	}
+	return StatusNormal
+}
+
+// Exceeded reports whether the rusage of r alone exceeds the limits. It does
+// not read the cgroup, so it is cheap enough to call on every ptrace stop
+// between calls to Check. The cgroup CPU usage is never less than the user
+// and system time, so Check reports a limit whenever Exceeded is true.
+func (l Limit) Exceeded(r *Result) bool {
+	t := r.Time
+	if l.TimePolicy != TimePolicyUser {
+		t += r.SystemTime
+	}
+	return (l.MemoryLimit > 0 && r.Memory > l.MemoryLimit) || (l.TimeLimit > 0 && t > l.TimeLimit)
+}
+
+func (l Limit) cpuTime(r *Result) (TimePolicy, time.Duration) {
+	switch l.TimePolicy {
+	case TimePolicyUserSystem:
+		return TimePolicyUserSystem, r.Time + r.SystemTime
+	case TimePolicyCgroup:
+		if l.CgroupCPUUsage != nil {
+			if t, err := l.CgroupCPUUsage(); err == nil {
+				return TimePolicyCgroup, t
+			}
+		}
+		return TimePolicyUserSystem, r.Time + r.SystemTime
+	default:
+		return TimePolicyUser, r.Time
+	}
 }
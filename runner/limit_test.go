@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimitCheck(t *testing.T) {
+	cgroupUsage := func() (time.Duration, error) { return 3 * time.Second, nil }
+	cgroupError := func() (time.Duration, error) { return 0, errors.New("no cgroup") }
+
+	tests := []struct {
+		limit   Limit
+		status  Status
+		policy  TimePolicy
+		cpuTime time.Duration
+	}{
+		{Limit{TimeLimit: time.Second}, StatusNormal, TimePolicyUser, 800 * time.Millisecond},
+		{Limit{TimeLimit: time.Second, TimePolicy: TimePolicyUserSystem}, StatusTimeLimitExceeded, TimePolicyUserSystem, 1500 * time.Millisecond},
+		{Limit{TimeLimit: time.Second, TimePolicy: TimePolicyCgroup, CgroupCPUUsage: cgroupUsage}, StatusTimeLimitExceeded, TimePolicyCgroup, 3 * time.Second},
+		{Limit{TimeLimit: 5 * time.Second, TimePolicy: TimePolicyCgroup, CgroupCPUUsage: cgroupUsage}, StatusNormal, TimePolicyCgroup, 3 * time.Second},
+		// 无法读取 cgroup 时使用用户态和内核态时间
+		{Limit{TimeLimit: time.Second, TimePolicy: TimePolicyCgroup, CgroupCPUUsage: cgroupError}, StatusTimeLimitExceeded, TimePolicyUserSystem, 1500 * time.Millisecond},
+		{Limit{TimeLimit: time.Second, TimePolicy: TimePolicyCgroup}, StatusTimeLimitExceeded, TimePolicyUserSystem, 1500 * time.Millisecond},
+		// 超内存优先于超时，为 0 的限制不检查
+		{Limit{TimeLimit: time.Second, MemoryLimit: 1 << 20, TimePolicy: TimePolicyUserSystem}, StatusMemoryLimitExceeded, TimePolicyUserSystem, 1500 * time.Millisecond},
+		{Limit{TimePolicy: TimePolicyUserSystem}, StatusNormal, TimePolicyUserSystem, 1500 * time.Millisecond},
+	}
+	for i, tc := range tests {
+		r := Result{Time: 800 * time.Millisecond, SystemTime: 700 * time.Millisecond, Memory: 2 << 20}
+		if s := tc.limit.Check(&r); s != tc.status || r.TimePolicy != tc.policy || r.CPUTime != tc.cpuTime {
+			t.Errorf("%d: %v: expected %v %v %v, got %v %v %v", i, tc.limit, tc.status, tc.policy, tc.cpuTime, s, r.TimePolicy, r.CPUTime)
+		}
+		// Exceeded 不读取 cgroup，超出限制时 Check 也一定超出
+		if tc.limit.Exceeded(&r) && tc.status == StatusNormal {
+			t.Errorf("%d: %v: exceeded without reading cgroup", i, tc.limit)
+		}
+	}
+}
+
+func TestTimePolicyText(t *testing.T) {
+	for _, p := range []TimePolicy{TimePolicyUser, TimePolicyUserSystem, TimePolicyCgroup} {
+		b, err := p.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got TimePolicy
+		if err := got.UnmarshalText(b); err != nil || got != p {
+			t.Errorf("%q: expected %v, got %v %v", b, p, got, err)
+		}
+	}
+	var p TimePolicy
+	if err := p.UnmarshalText([]byte("system")); err == nil {
+		t.Errorf("expected error for unknown policy")
+	}
+	if _, err := TimePolicy(5).MarshalText(); err == nil {
+		t.Errorf("expected error for invalid policy")
+	}
+}
@@ -0,0 +1,78 @@
+package runner
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/zqzqsb/sandbox/pkg/pipe"
+)
+
+// OutputLimit 包装程序运行器，限制通过 pipe.Buffer 收集的输出
+// 标准输出和标准错误为管道时 RLIMIT_FSIZE 不起作用，输出超过 Buffer.Max 时
+// OutputLimit 终止程序（取消传给 Runner 的上下文），结果状态为 StatusOutputLimitExceeded
+//
+// 运行结束后 OutputLimit 关闭各个 Buffer 的写入端 W 并等待读取完成和 tee 写入完成（最多 outputDrainWait），
+// 之后可以安全地读取 Buffer 中的数据
+type OutputLimit struct {
+	Runner  Runner
+	Buffers []*pipe.Buffer
+}
+
+// outputDrainWait 是程序结束后等待读取剩余输出的最长时间
+const outputDrainWait = 200 * time.Millisecond
+
+// Run 运行程序并检查输出是否超出限制
+func (r *OutputLimit) Run(c context.Context) Result {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	var exceeded atomic.Bool
+	for _, b := range r.Buffers {
+		go func(b *pipe.Buffer) {
+			select {
+			case <-b.Done:
+				// 读取了 Max+1 字节或者写入端全部关闭
+				if b.Exceeded() {
+					exceeded.Store(true)
+					cancel()
+				}
+			case <-ctx.Done():
+			}
+		}(b)
+	}
+
+	result := r.Runner.Run(ctx)
+
+	// 程序退出前写入的数据可能还没有读取，关闭写入端后等待读取完成
+	// 逃逸的进程（例如 setsid 的后台进程）可能仍然持有写入端，
+	// 超过 outputDrainWait 后关闭读取端，保证读取结束后才访问 Buffer
+	for _, b := range r.Buffers {
+		b.W.Close()
+	}
+	drain, cancelDrain := context.WithTimeout(c, outputDrainWait)
+	defer cancelDrain()
+	for _, b := range r.Buffers {
+		select {
+		case <-b.Done:
+		case <-drain.Done():
+			b.CloseRead()
+			<-b.Done
+		}
+		if b.Exceeded() {
+			exceeded.Store(true)
+		}
+	}
+	// tee 的写入方可能不再读取（例如没有人读取的标准输出），最多等待到 outputDrainWait 结束，
+	// 之后剩余的数据由后台 goroutine 继续写入
+	for _, b := range r.Buffers {
+		select {
+		case <-b.TeeDone:
+		case <-drain.Done():
+		}
+	}
+	if exceeded.Load() && result.Status != StatusRunnerError {
+		result.Status = StatusOutputLimitExceeded
+	}
+	return result
+}
@@ -0,0 +1,144 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/zqzqsb/sandbox/pkg/pipe"
+)
+
+// writeRunner 向 w 写入 n 字节，loop 为 true 时一直写入到上下文取消
+type writeRunner struct {
+	w    *os.File
+	n    int
+	loop bool
+}
+
+func (r *writeRunner) Run(c context.Context) Result {
+	b := bytes.Repeat([]byte("a"), r.n)
+	for {
+		if _, err := r.w.Write(b); err != nil {
+			return Result{Status: StatusRunnerError, Error: err.Error()}
+		}
+		if !r.loop {
+			return Result{Status: StatusNormal}
+		}
+		select {
+		case <-c.Done():
+			return Result{Status: StatusSignalled, ExitStatus: 9}
+		default:
+		}
+	}
+}
+
+func TestOutputLimit(t *testing.T) {
+	tests := []struct {
+		n      int
+		loop   bool
+		status Status
+	}{
+		{1024, false, StatusNormal},
+		{1025, false, StatusOutputLimitExceeded},
+		{100, true, StatusOutputLimitExceeded},
+	}
+	for _, tc := range tests {
+		b, err := pipe.NewBuffer(1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &OutputLimit{
+			Runner:  &writeRunner{w: b.W, n: tc.n, loop: tc.loop},
+			Buffers: []*pipe.Buffer{b},
+		}
+		result := r.Run(context.Background())
+		if result.Status != tc.status {
+			t.Errorf("%d %v: expected %v, got %v", tc.n, tc.loop, tc.status, result)
+		}
+		if tc.status == StatusNormal && b.Buffer.Len() != tc.n {
+			t.Errorf("%d: unexpected output size %d", tc.n, b.Buffer.Len())
+		}
+	}
+}
+
+// TestOutputLimitLeakedWriter 写入端被其他进程持有时 Run 不会一直等待
+func TestOutputLimitLeakedWriter(t *testing.T) {
+	b, err := pipe.NewBuffer(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaked, err := syscall.Dup(int(b.W.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(leaked)
+
+	r := &OutputLimit{
+		Runner:  &writeRunner{w: b.W, n: 100},
+		Buffers: []*pipe.Buffer{b},
+	}
+	start := time.Now()
+	result := r.Run(context.Background())
+	if result.Status != StatusNormal {
+		t.Errorf("unexpected result %v", result)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("run took %v", d)
+	}
+	if b.Buffer.Len() != 100 {
+		t.Errorf("unexpected output size %d", b.Buffer.Len())
+	}
+}
+
+func TestTeeBuffer(t *testing.T) {
+	var tee bytes.Buffer
+	b, err := pipe.NewTeeBuffer(1024, &tee)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &OutputLimit{
+		Runner:  &writeRunner{w: b.W, n: 2000},
+		Buffers: []*pipe.Buffer{b},
+	}
+	if result := r.Run(context.Background()); result.Status != StatusOutputLimitExceeded {
+		t.Errorf("unexpected result %v", result)
+	}
+	// 只转发限制以内的输出
+	if tee.Len() != 1024 {
+		t.Errorf("unexpected tee size %d", tee.Len())
+	}
+}
+
+// TestTeeBufferStuck tee 的写入一直阻塞（没有人读取的管道）时 Run 不会一直等待
+func TestTeeBufferStuck(t *testing.T) {
+	tr, tw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	defer tw.Close()
+
+	b, err := pipe.NewTeeBuffer(1<<20, tw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 超过管道的容量（64 KiB），写入 tee 时阻塞
+	r := &OutputLimit{
+		Runner:  &writeRunner{w: b.W, n: 512 << 10},
+		Buffers: []*pipe.Buffer{b},
+	}
+	start := time.Now()
+	result := r.Run(context.Background())
+	if result.Status != StatusNormal {
+		t.Errorf("unexpected result %v", result)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("run took %v", d)
+	}
+	if b.Buffer.Len() != 512<<10 {
+		t.Errorf("unexpected output size %d", b.Buffer.Len())
+	}
+}
@@ -190,7 +190,8 @@ func GetExtraSet(extra, raw []string) []string {
 
 // dirname 返回不带最后 "/" 的路径
 func dirname(path string) string {
-	if path == "" {
+	// 已经到达根目录，filepath.Dir 会一直返回 "/" 或 "."，需要终止
+	if path == "" || path == "/" || path == "." {
 		return ""
 	}
 	// 去除最后的 "/"
@@ -7,6 +7,8 @@ import (
 	"path"
 	"syscall"
 
+	"golang.org/x/sys/unix"
+
 	"github.com/zqzqsb/sandbox/pkg/seccomp/libseccomp"
 	"github.com/zqzqsb/sandbox/ptracer"
 )
@@ -26,21 +28,49 @@ func (h *tracerHandler) Debug(v ...interface{}) {
 	}
 }
 
-// getString 从目标进程的内存中读取字符串
+// pathArg 描述系统调用中的路径参数
+// 对于 *at 系统调用，相对路径基于 dirfd 解析；其他系统调用的 dirfd 为 AT_FDCWD
+type pathArg struct {
+	dirfd int  // 目录文件描述符
+	addr  uint // 路径在目标进程中的地址
+	flags uint // *at 系统调用的标志位（用于 AT_EMPTY_PATH）
+}
+
+// cwdPath 返回相对于当前工作目录的路径参数
+func cwdPath(addr uint) pathArg {
+	return pathArg{dirfd: unix.AT_FDCWD, addr: addr}
+}
+
+// atPath 返回 *at 系统调用的路径参数
+// dirfd 寄存器中保存的是 int 类型，需要按 32 位有符号数解释（AT_FDCWD 为 -100）
+func atPath(dirfd, addr, flags uint) pathArg {
+	return pathArg{dirfd: int(int32(dirfd)), addr: addr, flags: flags}
+}
+
+// isFstat 判断路径参数是否只是引用已打开的文件描述符本身（AT_EMPTY_PATH 且路径为空）
+func (p pathArg) isFstat(s string) bool {
+	return s == "" && p.flags&unix.AT_EMPTY_PATH != 0 && p.dirfd != unix.AT_FDCWD
+}
+
+// getString 从目标进程的内存中读取路径
 // ctx: ptrace 上下文
-// addr: 目标进程中字符串的地址
-// 返回: 转换为绝对路径的字符串
-func (h *tracerHandler) getString(ctx *ptracer.Context, addr uint) string {
-	return absPath(ctx.Pid, ctx.GetString(uintptr(addr)))
+// p: 路径参数
+// 返回: 转换为绝对路径的字符串，dirfd 无法解析时返回错误
+func (h *tracerHandler) getString(ctx *ptracer.Context, p pathArg) (string, error) {
+	return AbsPathAt(ctx.Pid, p.dirfd, ctx.GetString(uintptr(p.addr)), p.flags&unix.AT_EMPTY_PATH != 0)
 }
 
 // checkOpen 检查打开文件的操作是否允许
 // ctx: ptrace 上下文
-// addr: 文件路径在目标进程中的地址
+// p: 文件路径参数
 // flags: 打开文件的标志位
 // 返回: 跟踪动作（允许/禁止/杀死）
-func (h *tracerHandler) checkOpen(ctx *ptracer.Context, addr uint, flags uint) ptracer.TraceAction {
-	fn := h.getString(ctx, addr)
+func (h *tracerHandler) checkOpen(ctx *ptracer.Context, p pathArg, flags uint) ptracer.TraceAction {
+	fn, err := h.getString(ctx, p)
+	if err != nil {
+		h.Debug("open: ", err)
+		return ptracer.TraceBan
+	}
 	// 判断是否为只读操作
 	isReadOnly := (flags&syscall.O_ACCMODE == syscall.O_RDONLY) &&
 		(flags&syscall.O_CREAT == 0) &&
@@ -55,22 +85,40 @@ func (h *tracerHandler) checkOpen(ctx *ptracer.Context, addr uint, flags uint) p
 }
 
 // checkRead 检查读取文件的操作是否允许
-func (h *tracerHandler) checkRead(ctx *ptracer.Context, addr uint) ptracer.TraceAction {
-	fn := h.getString(ctx, addr)
+func (h *tracerHandler) checkRead(ctx *ptracer.Context, p pathArg) ptracer.TraceAction {
+	fn, err := h.getString(ctx, p)
+	if err != nil {
+		h.Debug("check read: ", err)
+		return ptracer.TraceBan
+	}
 	h.Debug("check read: ", fn)
 	return h.Handler.CheckRead(fn)
 }
 
 // checkWrite 检查写入文件的操作是否允许
-func (h *tracerHandler) checkWrite(ctx *ptracer.Context, addr uint) ptracer.TraceAction {
-	fn := h.getString(ctx, addr)
+func (h *tracerHandler) checkWrite(ctx *ptracer.Context, p pathArg) ptracer.TraceAction {
+	fn, err := h.getString(ctx, p)
+	if err != nil {
+		h.Debug("check write: ", err)
+		return ptracer.TraceBan
+	}
 	h.Debug("check write: ", fn)
 	return h.Handler.CheckWrite(fn)
 }
 
 // checkStat 检查获取文件状态的操作是否允许
-func (h *tracerHandler) checkStat(ctx *ptracer.Context, addr uint) ptracer.TraceAction {
-	fn := h.getString(ctx, addr)
+// 路径为空且带有 AT_EMPTY_PATH 时获取的是已打开的 dirfd 的状态，与 fstat 相同，直接允许
+func (h *tracerHandler) checkStat(ctx *ptracer.Context, p pathArg) ptracer.TraceAction {
+	s := ctx.GetString(uintptr(p.addr))
+	if p.isFstat(s) {
+		h.Debug("check stat: fd ", p.dirfd)
+		return ptracer.TraceAllow
+	}
+	fn, err := AbsPathAt(ctx.Pid, p.dirfd, s, p.flags&unix.AT_EMPTY_PATH != 0)
+	if err != nil {
+		h.Debug("check stat: ", err)
+		return ptracer.TraceBan
+	}
 	h.Debug("check stat: ", fn)
 	return h.Handler.CheckStat(fn)
 }
@@ -88,50 +136,58 @@ func (h *tracerHandler) Handle(ctx *ptracer.Context) ptracer.TraceAction {
 		h.Debug("invalid syscall no")
 		return ptracer.TraceKill
 	}
+	if o, ok := h.Handler.(SyscallObserver); ok {
+		o.ObserveSyscall(syscallName)
+	}
 
 	action := ptracer.TraceKill
 	switch syscallName {
 	// 文件打开相关系统调用
 	case "open":
-		action = h.checkOpen(ctx, ctx.Arg0(), ctx.Arg1())
+		action = h.checkOpen(ctx, cwdPath(ctx.Arg0()), ctx.Arg1())
 	case "openat":
-		action = h.checkOpen(ctx, ctx.Arg1(), ctx.Arg2())
+		action = h.checkOpen(ctx, atPath(ctx.Arg0(), ctx.Arg1(), 0), ctx.Arg2())
 
 	// 符号链接读取相关系统调用
+	// readlinkat 的路径为空时读取 dirfd 本身（相当于 AT_EMPTY_PATH）
 	case "readlink":
-		action = h.checkRead(ctx, ctx.Arg0())
+		action = h.checkRead(ctx, cwdPath(ctx.Arg0()))
 	case "readlinkat":
-		action = h.checkRead(ctx, ctx.Arg1())
+		action = h.checkRead(ctx, atPath(ctx.Arg0(), ctx.Arg1(), unix.AT_EMPTY_PATH))
 
 	// 文件删除相关系统调用
 	case "unlink":
-		action = h.checkWrite(ctx, ctx.Arg0())
+		action = h.checkWrite(ctx, cwdPath(ctx.Arg0()))
 	case "unlinkat":
-		action = h.checkWrite(ctx, ctx.Arg1())
+		action = h.checkWrite(ctx, atPath(ctx.Arg0(), ctx.Arg1(), 0))
 
 	// 文件访问权限检查相关系统调用
 	case "access":
-		action = h.checkStat(ctx, ctx.Arg0())
-	case "faccessat", "newfstatat":
-		action = h.checkStat(ctx, ctx.Arg1())
+		action = h.checkStat(ctx, cwdPath(ctx.Arg0()))
+	case "faccessat":
+		action = h.checkStat(ctx, atPath(ctx.Arg0(), ctx.Arg1(), 0))
+	case "faccessat2", "newfstatat":
+		action = h.checkStat(ctx, atPath(ctx.Arg0(), ctx.Arg1(), ctx.Arg3()))
 
 	// 文件状态查询相关系统调用
+	case "statx":
+		action = h.checkStat(ctx, atPath(ctx.Arg0(), ctx.Arg1(), ctx.Arg2()))
 	case "stat", "stat64":
-		action = h.checkStat(ctx, ctx.Arg0())
+		action = h.checkStat(ctx, cwdPath(ctx.Arg0()))
 	case "lstat", "lstat64":
-		action = h.checkStat(ctx, ctx.Arg0())
+		action = h.checkStat(ctx, cwdPath(ctx.Arg0()))
 
 	// 程序执行相关系统调用
 	case "execve":
-		action = h.checkRead(ctx, ctx.Arg0())
+		action = h.checkRead(ctx, cwdPath(ctx.Arg0()))
 	case "execveat":
-		action = h.checkRead(ctx, ctx.Arg1())
+		action = h.checkRead(ctx, atPath(ctx.Arg0(), ctx.Arg1(), ctx.Arg4()))
 
 	// 文件权限修改相关系统调用
 	case "chmod":
-		action = h.checkWrite(ctx, ctx.Arg0())
+		action = h.checkWrite(ctx, cwdPath(ctx.Arg0()))
 	case "rename":
-		action = h.checkWrite(ctx, ctx.Arg0())
+		action = h.checkWrite(ctx, cwdPath(ctx.Arg0()))
 
 	// 其他系统调用
 	default:
@@ -191,14 +247,59 @@ func getProcCwd(pid int) string {
 	return s
 }
 
+// getProcFd 获取进程的文件描述符指向的路径
+// pid: 进程ID，如果为0则表示当前进程
+// fd: 文件描述符
+// 返回: 文件描述符对应的路径，如果出错则返回空字符串
+// 注意：管道、套接字等描述符返回的是 "pipe:[1234]" 这样的非绝对路径
+func getProcFd(pid, fd int) string {
+	fileName := fmt.Sprintf("/proc/self/fd/%d", fd)
+	if pid > 0 {
+		fileName = fmt.Sprintf("/proc/%d/fd/%d", pid, fd)
+	}
+	s, err := os.Readlink(fileName)
+	if err != nil {
+		return ""
+	}
+	return s
+}
+
 // absPath 计算进程相对的绝对路径
 // pid: 进程ID
 // p: 原始路径
-// 返回: 转换后的绝对路径
-func absPath(pid int, p string) string {
-	// 如果不是绝对路径，则基于进程的工作目录计算
-	if !path.IsAbs(p) {
-		return path.Join(getProcCwd(pid), p)
+// 返回: 转换后的绝对路径，无法获取工作目录时返回错误
+func absPath(pid int, p string) (string, error) {
+	return AbsPathAt(pid, unix.AT_FDCWD, p, false)
+}
+
+// AbsPathAt 计算 *at 系统调用中路径参数对应的绝对路径
+//
+// 参数：
+//   - pid: 进程ID
+//   - dirfd: 目录文件描述符，AT_FDCWD 表示进程的当前工作目录
+//   - p: 原始路径
+//   - emptyPath: 是否设置了 AT_EMPTY_PATH，此时空路径表示 dirfd 本身
+//
+// 返回：
+//   - 转换后的绝对路径
+//   - 相对路径的 dirfd 无法解析为路径时（例如已关闭或是管道）返回错误，调用者应拒绝该系统调用
+func AbsPathAt(pid, dirfd int, p string, emptyPath bool) (string, error) {
+	if path.IsAbs(p) {
+		return path.Clean(p), nil
+	}
+
+	var base string
+	if dirfd == unix.AT_FDCWD {
+		base = getProcCwd(pid)
+	} else {
+		base = getProcFd(pid, dirfd)
+	}
+	// 管道、套接字等描述符的链接形如 pipe:[123]，不是路径
+	if !path.IsAbs(base) {
+		return "", fmt.Errorf("dirfd %d of %d: unresolvable base %q", dirfd, pid, base)
+	}
+	if p == "" && emptyPath {
+		return base, nil
 	}
-	return path.Clean(p)
+	return path.Join(base, p), nil
 }
@@ -0,0 +1,350 @@
+package ptrace
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zqzqsb/sandbox/pkg/rlimit"
+	"github.com/zqzqsb/sandbox/pkg/seccomp/libseccomp"
+	"github.com/zqzqsb/sandbox/ptracer"
+	"github.com/zqzqsb/sandbox/runner"
+	"github.com/zqzqsb/sandbox/runner/ptrace/filehandler"
+)
+
+var traceSyscalls = []string{
+	"execve", "execveat", "open", "openat", "unlink", "unlinkat",
+	"readlink", "readlinkat", "stat", "lstat", "access", "faccessat", "newfstatat",
+}
+
+// helperPath 是 testdata/dirfd 编译后的路径
+var helperPath string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "ptrace_test")
+	if err != nil {
+		panic(err)
+	}
+	helperPath = filepath.Join(dir, "dirfd")
+	cmd := exec.Command("go", "build", "-o", helperPath, "./testdata/dirfd")
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Stderr.Write(out)
+		helperPath = ""
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// recordHandler 记录检查过的路径，并软禁止 ban 中的路径
+type recordHandler struct {
+	mu      sync.Mutex
+	checked []string
+	ban     map[string]bool
+}
+
+func (h *recordHandler) check(fn string) ptracer.TraceAction {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checked = append(h.checked, fn)
+	if h.ban[fn] {
+		return ptracer.TraceBan
+	}
+	return ptracer.TraceAllow
+}
+
+func (h *recordHandler) CheckRead(fn string) ptracer.TraceAction  { return h.check(fn) }
+func (h *recordHandler) CheckWrite(fn string) ptracer.TraceAction { return h.check(fn) }
+func (h *recordHandler) CheckStat(fn string) ptracer.TraceAction  { return h.check(fn) }
+func (h *recordHandler) CheckSyscall(string) ptracer.TraceAction  { return ptracer.TraceAllow }
+
+func (h *recordHandler) contains(fn string) bool {
+	return h.count(fn) > 0
+}
+
+func (h *recordHandler) count(fn string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := 0
+	for _, c := range h.checked {
+		if c == fn {
+			n++
+		}
+	}
+	return n
+}
+
+// runHelper 在 ptrace 运行器中以 workDir 为工作目录运行辅助程序
+func runHelper(t *testing.T, h Handler, workDir string, args ...string) (runner.Result, string) {
+	t.Helper()
+	if helperPath == "" {
+		t.Skip("failed to build helper binary")
+	}
+
+	b := libseccomp.Builder{
+		Trace:   traceSyscalls,
+		Default: libseccomp.ActionAllow,
+	}
+	filter, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	r := &Runner{
+		Args:    append([]string{helperPath}, args...),
+		WorkDir: workDir,
+		Files:   []uintptr{0, out.Fd(), 2},
+		Limit:   runner.Limit{TimeLimit: 5e9, MemoryLimit: 256 << 20},
+		Seccomp: filter,
+		Handler: h,
+	}
+	result := r.Run(context.Background())
+	content, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result, string(content)
+}
+
+// prepareDirs 创建两个目录，其中都有名为 secret 的文件
+// 返回目标目录和工作目录
+func prepareDirs(t *testing.T) (string, string) {
+	t.Helper()
+
+	target, work := t.TempDir(), t.TempDir()
+	for _, d := range []string{target, work} {
+		if err := os.WriteFile(filepath.Join(d, "secret"), []byte(d), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return target, work
+}
+
+func TestDirfdOpenat(t *testing.T) {
+	target, work := prepareDirs(t)
+	secret := filepath.Join(target, "secret")
+
+	// openat(dirfd, "secret") 应该按 dirfd 检查，而不是按工作目录
+	h := &recordHandler{ban: map[string]bool{secret: true}}
+	result, out := runHelper(t, h, work, "open", target, "secret")
+	if result.Status != runner.StatusNonzeroExitStatus {
+		t.Fatalf("expected openat to be banned, got %v: %q", result, out)
+	}
+	if !h.contains(secret) {
+		t.Errorf("%s not checked, checked: %v", secret, h.checked)
+	}
+	if h.contains(filepath.Join(work, "secret")) {
+		t.Errorf("openat resolved against work dir")
+	}
+}
+
+func TestDirfdOpenatAllowed(t *testing.T) {
+	target, work := prepareDirs(t)
+
+	h := &recordHandler{ban: map[string]bool{filepath.Join(work, "secret"): true}}
+	result, out := runHelper(t, h, work, "open", target, "secret")
+	if result.Status != runner.StatusNormal {
+		t.Fatalf("unexpected result %v: %q", result, out)
+	}
+	if out != target {
+		t.Errorf("expected content %q, got %q", target, out)
+	}
+}
+
+func TestDirfdEmptyPath(t *testing.T) {
+	target, work := prepareDirs(t)
+	secret := filepath.Join(target, "secret")
+
+	// newfstatat(fd, "", AT_EMPTY_PATH) 等同于 fstat，只检查打开文件时的路径
+	h := &recordHandler{ban: map[string]bool{work: true}}
+	result, out := runHelper(t, h, work, "stat", secret)
+	if result.Status != runner.StatusNormal {
+		t.Fatalf("unexpected result %v: %q", result, out)
+	}
+	if out != strconv.Itoa(len(target)) {
+		t.Errorf("unexpected size %q", out)
+	}
+	if n := h.count(secret); n != 1 {
+		t.Errorf("%s checked %d times, checked: %v", secret, n, h.checked)
+	}
+}
+
+// observeHandler 在 recordHandler 的基础上记录被跟踪的系统调用
+type observeHandler struct {
+	recordHandler
+	syscalls map[string]int
+}
+
+func (h *observeHandler) ObserveSyscall(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.syscalls[name]++
+}
+
+func TestSyscallObserver(t *testing.T) {
+	target, work := prepareDirs(t)
+
+	h := &observeHandler{syscalls: make(map[string]int)}
+	result, out := runHelper(t, h, work, "open", target, "secret")
+	if result.Status != runner.StatusNormal {
+		t.Fatalf("unexpected result %v: %q", result, out)
+	}
+	// 文件相关的系统调用交给 CheckRead 等检查之前同样会通知 ObserveSyscall
+	for _, name := range []string{"execve", "openat"} {
+		if h.syscalls[name] == 0 {
+			t.Errorf("%s not observed: %v", name, h.syscalls)
+		}
+	}
+}
+
+func TestResultUsage(t *testing.T) {
+	target, work := prepareDirs(t)
+
+	h := &recordHandler{}
+	result, out := runHelper(t, h, work, "spawn", "2", target, "secret")
+	if result.Status != runner.StatusNormal {
+		t.Fatalf("unexpected result %v: %q", result, out)
+	}
+	// 主进程和 2 个子进程，Go 运行时创建的线程不计入
+	// Go 第一次创建子进程时会额外 vfork 一个进程检测 pidfd 的支持
+	if result.Processes < 3 || result.Processes > 4 {
+		t.Errorf("processes = %d, want 3 or 4", result.Processes)
+	}
+	// 已回收的子进程读取的内容计入主进程
+	if result.ReadBytes < runner.Size(2*len(target)) {
+		t.Errorf("read bytes = %d, want at least %d", result.ReadBytes, 2*len(target))
+	}
+	if result.WallTime <= 0 || result.WallTime > result.RunningTime {
+		t.Errorf("wall time = %v, running time = %v", result.WallTime, result.RunningTime)
+	}
+}
+
+func TestWallTimeLimit(t *testing.T) {
+	filter, err := (&libseccomp.Builder{Trace: traceSyscalls, Default: libseccomp.ActionAllow}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{
+		Args:    []string{"/bin/sleep", "5"},
+		Env:     []string{"PATH=/bin:/usr/bin"},
+		Limit:   runner.Limit{TimeLimit: 1e9, MemoryLimit: 256 << 20, WallTimeLimit: 200 * time.Millisecond},
+		Seccomp: filter,
+		Handler: &recordHandler{},
+	}
+	result := r.Run(context.Background())
+	// 睡眠没有使用 CPU 时间，超出墙上时间限制被终止
+	if result.Status != runner.StatusWallTimeLimitExceeded {
+		t.Fatalf("unexpected result: %v", result)
+	}
+	if result.WallTime < 200*time.Millisecond || result.WallTime > 2*time.Second {
+		t.Errorf("unexpected wall time: %v", result.WallTime)
+	}
+}
+
+func TestOutputLimitSignal(t *testing.T) {
+	filter, err := (&libseccomp.Builder{Trace: traceSyscalls, Default: libseccomp.ActionAllow}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	r := &Runner{
+		Args:    []string{"/usr/bin/yes"},
+		Env:     []string{"PATH=/bin:/usr/bin"},
+		Files:   []uintptr{0, out.Fd(), 2},
+		RLimits: (&rlimit.RLimits{FileSize: 4096}).PrepareRLimit(),
+		Limit:   runner.Limit{TimeLimit: 1e9, MemoryLimit: 256 << 20, WallTimeLimit: 5 * time.Second},
+		Seccomp: filter,
+		Handler: &recordHandler{},
+	}
+	// 超出 RLIMIT_FSIZE 时收到 SIGXFSZ
+	result := r.Run(context.Background())
+	if result.Status != runner.StatusOutputLimitExceeded {
+		t.Fatalf("unexpected result: %v", result)
+	}
+}
+
+func TestAbsPathAt(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	cwd, _ := os.Getwd()
+	pid, fd := os.Getpid(), int(f.Fd())
+	tests := []struct {
+		dirfd     int
+		path      string
+		emptyPath bool
+		want      string
+	}{
+		{atFDCWD, "/etc/../etc/passwd", false, "/etc/passwd"},
+		{atFDCWD, "passwd", false, filepath.Join(cwd, "passwd")},
+		{fd, "passwd", false, filepath.Join(dir, "passwd")},
+		{fd, "/etc/passwd", false, "/etc/passwd"},
+		{fd, "", true, dir},
+		{atFDCWD, "", true, cwd},
+	}
+	for _, tc := range tests {
+		if got, err := AbsPathAt(pid, tc.dirfd, tc.path, tc.emptyPath); err != nil || got != tc.want {
+			t.Errorf("AbsPathAt(%d, %q, %v) = %q %v, want %q", tc.dirfd, tc.path, tc.emptyPath, got, err, tc.want)
+		}
+	}
+
+	// 无法解析的描述符（已关闭、管道）返回错误
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+	for _, dirfd := range []int{1 << 20, int(pr.Fd())} {
+		if got, err := AbsPathAt(pid, dirfd, "passwd", false); err == nil {
+			t.Errorf("dirfd %d resolved to %q", dirfd, got)
+		}
+	}
+}
+
+const atFDCWD = -100
+
+// TestFileSetRelativePath 确认相对路径（例如无法解析的 dirfd 拼接出的 pipe:[123]/passwd）
+// 不会使 IsInSetSmart 在逐级查找父目录时陷入死循环
+func TestFileSetRelativePath(t *testing.T) {
+	fs := filehandler.NewFileSets()
+	fs.AddFilePermission("/etc/", filehandler.FilePermRead)
+
+	done := make(chan bool, 1)
+	go func() {
+		done <- fs.IsReadableFile("pipe:[123]/passwd") || fs.IsReadableFile("passwd")
+	}()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("relative path is readable")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("IsReadableFile did not return for a relative path")
+	}
+}
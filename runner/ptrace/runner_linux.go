@@ -89,3 +89,10 @@ type Handler interface {
 	// 返回跟踪动作：允许、禁止或终止
 	CheckSyscall(string) ptracer.TraceAction
 }
+
+// SyscallObserver 是 Handler 可以选择实现的接口
+// 实现该接口的 Handler 在每个被跟踪的系统调用处理之前都会收到它的名称，
+// 包括随后交给 CheckRead 等文件检查的系统调用，用于学习模式记录程序实际使用的系统调用
+type SyscallObserver interface {
+	ObserveSyscall(string)
+}
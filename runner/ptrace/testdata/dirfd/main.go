@@ -0,0 +1,68 @@
+// Command dirfd 是 runner/ptrace 测试使用的辅助程序，通过目录文件描述符访问文件
+//
+// 用法：
+//
+//	dirfd open <dir> <name>   打开 dir 后通过 openat(dirfd, name) 读取文件并输出内容
+//	dirfd stat <file>         打开 file 后通过 newfstatat(fd, "", AT_EMPTY_PATH) 获取文件状态
+//	dirfd spawn <n> <dir> <name>  创建 n 个子进程执行 dirfd open <dir> <name>
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+func main() {
+	if len(os.Args) < 3 {
+		fmt.Fprintln(os.Stderr, "usage: dirfd open <dir> <name> | dirfd stat <file>")
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "open":
+		if len(os.Args) < 4 {
+			os.Exit(2)
+		}
+		dirfd, err := unix.Open(os.Args[2], unix.O_RDONLY|unix.O_DIRECTORY, 0)
+		check(err)
+		fd, err := unix.Openat(dirfd, os.Args[3], unix.O_RDONLY, 0)
+		check(err)
+		buf := make([]byte, 4096)
+		n, err := unix.Read(fd, buf)
+		check(err)
+		os.Stdout.Write(buf[:n])
+
+	case "stat":
+		fd, err := unix.Open(os.Args[2], unix.O_RDONLY, 0)
+		check(err)
+		var st unix.Stat_t
+		check(unix.Fstatat(fd, "", &st, unix.AT_EMPTY_PATH))
+		fmt.Print(st.Size)
+
+	case "spawn":
+		if len(os.Args) < 5 {
+			os.Exit(2)
+		}
+		n, err := strconv.Atoi(os.Args[2])
+		check(err)
+		for i := 0; i < n; i++ {
+			cmd := exec.Command(os.Args[0], "open", os.Args[3], os.Args[4])
+			cmd.Stdout = os.Stdout
+			check(cmd.Run())
+		}
+
+	default:
+		os.Exit(2)
+	}
+}
+
+func check(err error) {
+	if err != nil {
+		fmt.Print(err)
+		os.Exit(1)
+	}
+}
@@ -1,8 +1,12 @@
 package runner
 
 import (
+	"encoding/json"
 	"fmt"
+	"syscall"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 // Result 是程序运行的结果
@@ -11,14 +15,74 @@ type Result struct {
 	ExitStatus int    // 退出状态（如果被信号终止则为信号编号）
 	Error      string // 潜在的详细错误信息（用于程序运行器错误）
 
-	Time   time.Duration // 使用的用户 CPU 时间（底层类型为 int64，单位纳秒）
-	Memory Size          // 使用的用户内存（底层类型为 uint64，单位字节）
+	Time       time.Duration // 使用的用户 CPU 时间（底层类型为 int64，单位纳秒）
+	SystemTime time.Duration // 使用的系统（内核态）CPU 时间
+	WallTime   time.Duration // 从执行程序到程序退出的实际时间
+	Memory     Size          // 使用的用户内存（底层类型为 uint64，单位字节）
+
+	// 与时间限制比较的 CPU 时间，以及实际使用的统计方式（见 Limit.Check）
+	CPUTime    time.Duration
+	TimePolicy TimePolicy
+
+	// 其他资源使用统计（来自 rusage 和 /proc/<pid>/io）
+	VoluntaryContextSwitches   int64 // 主动上下文切换次数（等待 I/O、睡眠等）
+	InvoluntaryContextSwitches int64 // 被动上下文切换次数（时间片用完、被抢占）
+	MajorPageFaults            int64 // 需要读取磁盘的缺页次数
+	MinorPageFaults            int64 // 不需要读取磁盘的缺页次数
+	ReadBytes                  Size  // 通过 read 等系统调用读取的字节数
+	WriteBytes                 Size  // 通过 write 等系统调用写入的字节数
+	Processes                  int   // 创建的进程数（包括程序本身，ptrace 以外的运行器通过 ProcessCounter 采样，可能偏少）
+
+	// 程序被运行器终止的阶段（目前仅由 container 设置）
+	KillPhase KillPhase
 
 	// 程序运行器的度量指标
 	SetUpTime   time.Duration // 设置时间
 	RunningTime time.Duration // 运行时间
 }
 
+// KillPhase 表示结束程序的终止阶段
+type KillPhase int
+
+// 终止阶段
+const (
+	KillPhaseNone   KillPhase = iota // 程序自行退出，未被终止
+	KillPhaseSignal                  // 程序在宽限期内收到终止信号（默认 SIGTERM）后退出
+	KillPhaseKill                    // 程序被 SIGKILL 终止
+)
+
+var killPhaseName = []string{
+	"none",
+	"signal",
+	"kill",
+}
+
+func (p KillPhase) String() string {
+	if p >= 0 && int(p) < len(killPhaseName) {
+		return killPhaseName[p]
+	}
+	return fmt.Sprintf("KillPhase(%d)", int(p))
+}
+
+// MarshalText 将终止阶段编码为名称
+func (p KillPhase) MarshalText() ([]byte, error) {
+	if p < 0 || int(p) >= len(killPhaseName) {
+		return nil, fmt.Errorf("invalid kill phase %d", int(p))
+	}
+	return []byte(killPhaseName[p]), nil
+}
+
+// UnmarshalText 从名称解析终止阶段
+func (p *KillPhase) UnmarshalText(b []byte) error {
+	for i, n := range killPhaseName {
+		if n == string(b) {
+			*p = KillPhase(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid kill phase %q", b)
+}
+
 
 /*
 	当一个类型实现了 String() 方法，它就自动实现了 fmt.Stringer 接口。这个接口的作用是：
@@ -41,3 +105,96 @@ func (r Result) String() string {
 		return fmt.Sprintf("Result[%v(%s %d)][%v %v][%v %v]", r.Status, r.Error, r.ExitStatus, r.Time, r.Memory, r.SetUpTime, r.RunningTime)
 	}
 }
+
+// resultJSON 是 Result 的 JSON 格式
+// 时间的单位为纳秒，内存的单位为字节
+type resultJSON struct {
+	Status      Status        `json:"status"`
+	StatusCode  int           `json:"statusCode"`
+	ExitStatus  int           `json:"exitStatus"`
+	Signal      string        `json:"signal,omitempty"` // 被信号终止时的信号名称，例如 "SIGKILL"
+	Error       string        `json:"error,omitempty"`
+	Time        time.Duration `json:"time"`
+	SystemTime  time.Duration `json:"systemTime"`
+	WallTime    time.Duration `json:"wallTime"`
+	CPUTime     time.Duration `json:"cpuTime"`
+	TimePolicy  TimePolicy    `json:"timePolicy"`
+	Memory      Size          `json:"memory"`
+	SetUpTime   time.Duration `json:"setUpTime"`
+	RunningTime time.Duration `json:"runningTime"`
+
+	VoluntaryContextSwitches   int64 `json:"voluntaryContextSwitches"`
+	InvoluntaryContextSwitches int64 `json:"involuntaryContextSwitches"`
+	MajorPageFaults            int64 `json:"majorPageFaults"`
+	MinorPageFaults            int64 `json:"minorPageFaults"`
+	ReadBytes                  Size  `json:"readBytes"`
+	WriteBytes                 Size  `json:"writeBytes"`
+	Processes                  int   `json:"processes"`
+
+	KillPhase KillPhase `json:"killPhase"`
+}
+
+// MarshalJSON 将完整的运行结果编码为 JSON
+// Result 内嵌了 Status，需要定义自己的 MarshalJSON，否则会使用 Status 的编码而只输出状态
+func (r Result) MarshalJSON() ([]byte, error) {
+	j := resultJSON{
+		Status:      r.Status,
+		StatusCode:  int(r.Status),
+		ExitStatus:  r.ExitStatus,
+		Error:       r.Error,
+		Time:        r.Time,
+		SystemTime:  r.SystemTime,
+		WallTime:    r.WallTime,
+		CPUTime:     r.CPUTime,
+		TimePolicy:  r.TimePolicy,
+		Memory:      r.Memory,
+		SetUpTime:   r.SetUpTime,
+		RunningTime: r.RunningTime,
+
+		VoluntaryContextSwitches:   r.VoluntaryContextSwitches,
+		InvoluntaryContextSwitches: r.InvoluntaryContextSwitches,
+		MajorPageFaults:            r.MajorPageFaults,
+		MinorPageFaults:            r.MinorPageFaults,
+		ReadBytes:                  r.ReadBytes,
+		WriteBytes:                 r.WriteBytes,
+		Processes:                  r.Processes,
+
+		KillPhase: r.KillPhase,
+	}
+	if r.Status == StatusSignalled {
+		j.Signal = unix.SignalName(syscall.Signal(r.ExitStatus))
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON 解析 MarshalJSON 输出的运行结果
+func (r *Result) UnmarshalJSON(b []byte) error {
+	var j resultJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*r = Result{
+		Status:      j.Status,
+		ExitStatus:  j.ExitStatus,
+		Error:       j.Error,
+		Time:        j.Time,
+		SystemTime:  j.SystemTime,
+		WallTime:    j.WallTime,
+		CPUTime:     j.CPUTime,
+		TimePolicy:  j.TimePolicy,
+		Memory:      j.Memory,
+		SetUpTime:   j.SetUpTime,
+		RunningTime: j.RunningTime,
+
+		VoluntaryContextSwitches:   j.VoluntaryContextSwitches,
+		InvoluntaryContextSwitches: j.InvoluntaryContextSwitches,
+		MajorPageFaults:            j.MajorPageFaults,
+		MinorPageFaults:            j.MinorPageFaults,
+		ReadBytes:                  j.ReadBytes,
+		WriteBytes:                 j.WriteBytes,
+		Processes:                  j.Processes,
+
+		KillPhase: j.KillPhase,
+	}
+	return nil
+}
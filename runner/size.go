@@ -1,6 +1,7 @@
 package runner
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 )
@@ -32,6 +33,9 @@ func (s Size) String() string {
 */	
 // Set 从字符串解析大小值
 func (s *Size) Set(str string) error {
+	if str == "" || str == "b" || str == "B" {
+		return fmt.Errorf("invalid size %q", str)
+	}
 	switch str[len(str)-1] {
 	case 'b', 'B':
 		str = str[:len(str)-1]
@@ -100,4 +104,41 @@ func (s Size) EiB() uint64 {
 	return uint64(s) >> 60
 }
 
+// MarshalText 将大小编码为 Set 可以解析的文本
+// 能被整除时使用最大的单位，例如 "256M"，否则为字节数
+func (s Size) MarshalText() ([]byte, error) {
+	t := uint64(s)
+	for _, u := range []struct {
+		shift  uint
+		suffix string
+	}{{30, "G"}, {20, "M"}, {10, "K"}} {
+		if t != 0 && t&(1<<u.shift-1) == 0 {
+			return []byte(strconv.FormatUint(t>>u.shift, 10) + u.suffix), nil
+		}
+	}
+	return []byte(strconv.FormatUint(t, 10)), nil
+}
+
+// UnmarshalText 使用 Set 解析大小
+func (s *Size) UnmarshalText(text []byte) error {
+	return s.Set(string(text))
+}
 
+// MarshalJSON 将大小编码为字节数
+func (s Size) MarshalJSON() ([]byte, error) {
+	return json.Marshal(uint64(s))
+}
+
+// UnmarshalJSON 解析字节数，也接受 Set 格式的字符串（例如 "256M"）
+func (s *Size) UnmarshalJSON(b []byte) error {
+	var n uint64
+	if err := json.Unmarshal(b, &n); err == nil {
+		*s = Size(n)
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return fmt.Errorf("invalid size %s", b)
+	}
+	return s.UnmarshalText([]byte(str))
+}
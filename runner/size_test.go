@@ -0,0 +1,53 @@
+package runner
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSizeText(t *testing.T) {
+	tests := []struct {
+		size Size
+		text string
+	}{
+		{0, "0"},
+		{1000, "1000"},
+		{1 << 10, "1K"},
+		{3 << 19, "1536K"},
+		{256 << 20, "256M"},
+		{2 << 30, "2G"},
+	}
+	for _, tc := range tests {
+		b, err := tc.size.MarshalText()
+		if err != nil || string(b) != tc.text {
+			t.Errorf("%d: expected %q, got %q %v", tc.size, tc.text, b, err)
+		}
+		var s Size
+		if err := s.UnmarshalText(b); err != nil || s != tc.size {
+			t.Errorf("%q: expected %d, got %d %v", b, tc.size, s, err)
+		}
+	}
+	for _, text := range []string{"", "B", "M", "1.5M", "-"} {
+		var s Size
+		if err := s.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("%q: expected error", text)
+		}
+	}
+}
+
+func TestSizeJSON(t *testing.T) {
+	b, err := json.Marshal(struct{ Memory Size }{256 << 20})
+	if err != nil || string(b) != `{"Memory":268435456}` {
+		t.Errorf("unexpected json %s %v", b, err)
+	}
+	for _, in := range []string{`268435456`, `"256M"`, `"256mb"`} {
+		var s Size
+		if err := json.Unmarshal([]byte(in), &s); err != nil || s != 256<<20 {
+			t.Errorf("%s: got %d %v", in, s, err)
+		}
+	}
+	var s Size
+	if err := json.Unmarshal([]byte(`true`), &s); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -1,5 +1,11 @@
 package runner
 
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
 // Status 是结果状态
 type Status int
 
@@ -23,6 +29,9 @@ const (
 
 	// 程序运行器错误
 	StatusRunnerError // 8 运行器错误
+
+	// 超出墙上时间限制（睡眠或等待输入而没有使用 CPU 时间）
+	StatusWallTimeLimitExceeded // 9 超出墙上时间限制
 )
 
 var (
@@ -36,6 +45,21 @@ var (
 		"被信号终止",
 		"非零退出状态",
 		"运行器错误",
+		"超出墙上时间限制",
+	}
+
+	// statusName 是结果状态稳定的英文名称，用于 JSON 和文本编码，不随显示文本变化
+	statusName = []string{
+		"Invalid",
+		"Normal",
+		"TimeLimitExceeded",
+		"MemoryLimitExceeded",
+		"OutputLimitExceeded",
+		"DisallowedSyscall",
+		"Signalled",
+		"NonzeroExitStatus",
+		"RunnerError",
+		"WallTimeLimitExceeded",
 	}
 )
 
@@ -50,3 +74,52 @@ func (t Status) String() string {
 func (t Status) Error() string {
 	return t.String()
 }
+
+// Name 返回结果状态稳定的英文名称，例如 "TimeLimitExceeded"
+// 未知的状态返回 "Invalid"
+func (t Status) Name() string {
+	i := int(t)
+	if i >= 0 && i < len(statusName) {
+		return statusName[i]
+	}
+	return statusName[0]
+}
+
+// MarshalText 将结果状态编码为名称
+func (t Status) MarshalText() ([]byte, error) {
+	return []byte(t.Name()), nil
+}
+
+// UnmarshalText 解析结果状态的名称，也接受十进制的状态码
+func (t *Status) UnmarshalText(text []byte) error {
+	s := string(text)
+	for i, n := range statusName {
+		if n == s {
+			*t = Status(i)
+			return nil
+		}
+	}
+	if i, err := strconv.Atoi(s); err == nil && i >= 0 && i < len(statusName) {
+		*t = Status(i)
+		return nil
+	}
+	return fmt.Errorf("invalid status %q", s)
+}
+
+// MarshalJSON 将结果状态编码为 JSON 字符串形式的名称
+func (t Status) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Name())
+}
+
+// UnmarshalJSON 解析 JSON 字符串形式的名称或数字形式的状态码
+func (t *Status) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var i int
+		if err := json.Unmarshal(b, &i); err != nil {
+			return fmt.Errorf("invalid status %s", b)
+		}
+		s = strconv.Itoa(i)
+	}
+	return t.UnmarshalText([]byte(s))
+}
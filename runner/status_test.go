@@ -0,0 +1,80 @@
+package runner
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStatusJSON(t *testing.T) {
+	for s := StatusInvalid; s <= StatusWallTimeLimitExceeded; s++ {
+		b, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Status
+		if err := json.Unmarshal(b, &got); err != nil || got != s {
+			t.Errorf("%s: expected %d, got %d %v", b, s, got, err)
+		}
+	}
+	if b, _ := json.Marshal(StatusTimeLimitExceeded); string(b) != `"TimeLimitExceeded"` {
+		t.Errorf("unexpected json %s", b)
+	}
+	// 名称之外也接受状态码
+	var s Status
+	if err := json.Unmarshal([]byte(`7`), &s); err != nil || s != StatusNonzeroExitStatus {
+		t.Errorf("unexpected status %d %v", s, err)
+	}
+	for _, in := range []string{`"Unknown"`, `10`, `-1`, `true`} {
+		if err := json.Unmarshal([]byte(in), &s); err == nil {
+			t.Errorf("%s: expected error", in)
+		}
+	}
+	// 作为 map 的键时使用文本编码
+	b, err := json.Marshal(map[Status]int{StatusNormal: 1})
+	if err != nil || string(b) != `{"Normal":1}` {
+		t.Errorf("unexpected json %s %v", b, err)
+	}
+}
+
+func TestResultJSON(t *testing.T) {
+	r := Result{
+		Status:      StatusSignalled,
+		ExitStatus:  9,
+		Error:       "killed",
+		Time:        1500 * time.Millisecond,
+		SystemTime:  200 * time.Millisecond,
+		WallTime:    1800 * time.Millisecond,
+		CPUTime:     1700 * time.Millisecond,
+		TimePolicy:  TimePolicyUserSystem,
+		Memory:      64 << 20,
+		SetUpTime:   time.Millisecond,
+		RunningTime: 2 * time.Second,
+
+		VoluntaryContextSwitches:   3,
+		InvoluntaryContextSwitches: 4,
+		MajorPageFaults:            1,
+		MinorPageFaults:            120,
+		ReadBytes:                  4096,
+		WriteBytes:                 12,
+		Processes:                  2,
+		KillPhase:                  KillPhaseKill,
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"status":"Signalled","statusCode":6,"exitStatus":9,"signal":"SIGKILL","error":"killed",` +
+		`"time":1500000000,"systemTime":200000000,"wallTime":1800000000,` +
+		`"cpuTime":1700000000,"timePolicy":"user+system","memory":67108864,` +
+		`"setUpTime":1000000,"runningTime":2000000000,` +
+		`"voluntaryContextSwitches":3,"involuntaryContextSwitches":4,"majorPageFaults":1,"minorPageFaults":120,` +
+		`"readBytes":4096,"writeBytes":12,"processes":2,"killPhase":"kill"}`
+	if string(b) != want {
+		t.Errorf("unexpected json\n got %s\nwant %s", b, want)
+	}
+	var got Result
+	if err := json.Unmarshal(b, &got); err != nil || got != r {
+		t.Errorf("unexpected result %v %v", got, err)
+	}
+}
@@ -0,0 +1,20 @@
+// Package unotify 实现了基于 seccomp 用户态通知（SECCOMP_RET_USER_NOTIF）的沙箱运行器
+//
+// 与 ptrace 运行器相比：
+//   - 需要检查的系统调用由过滤器返回 SECCOMP_RET_USER_NOTIF，由监督者 goroutine 通过
+//     SECCOMP_IOCTL_NOTIF_RECV / SECCOMP_IOCTL_NOTIF_SEND 处理，不需要 ptrace 停止
+//   - 读取目标进程内存前后都会通过 SECCOMP_IOCTL_NOTIF_ID_VALID 确认通知仍然有效
+//   - 检查依赖目标进程内存中的路径，SECCOMP_USER_NOTIF_FLAG_CONTINUE 会让内核重新读取内存，
+//     其他线程可以在检查后修改路径（TOCTOU）。因此被允许的路径相关系统调用都由监督者对检查过的路径
+//     代为执行：open / openat 打开后通过 SECCOMP_IOCTL_NOTIF_ADDFD 安装到目标进程中，
+//     stat、access、readlink、unlink、chmod、rename 等执行后把结果写回目标进程的内存
+//   - execve / execveat 无法代为执行，只允许子进程最初的 execve（此时路径不会被其他线程修改），
+//     目标程序之后的 execve 都会被拒绝
+//   - 只有不依赖内存内容的检查（按系统调用名称）才使用 SECCOMP_USER_NOTIF_FLAG_CONTINUE
+//   - 监督者以自己的凭据代为执行，目标进程的文件系统凭据或 umask 与监督者不同时拒绝路径相关的系统调用；
+//     命名管道、套接字等无法代为打开的文件同样被拒绝
+//
+// 文件访问策略与 ptrace 运行器相同，使用 ptrace.Handler（例如 filehandler.Handler）
+//
+// 需要 Linux 5.14 及以上内核（pidfd_getfd、SECCOMP_ADDFD_FLAG_SEND）
+package unotify
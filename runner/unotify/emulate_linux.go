@@ -0,0 +1,322 @@
+package unotify
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/zqzqsb/sandbox/runner/ptrace"
+)
+
+// opKind 是由监督者代为执行的系统调用类型
+type opKind int
+
+const (
+	opOpen     opKind = iota + 1 // open / openat
+	opStat                       // stat / lstat / newfstatat
+	opStatx                      // statx
+	opAccess                     // access / faccessat / faccessat2
+	opReadlink                   // readlink / readlinkat
+	opUnlink                     // unlink / unlinkat
+	opChmod                      // chmod
+	opRename                     // rename
+)
+
+// emulation 描述一次被允许、需要由监督者代为执行的系统调用
+//
+// 检查依赖目标进程内存中的路径，如果使用 SECCOMP_USER_NOTIF_FLAG_CONTINUE，内核会重新读取内存，
+// 其他线程可以在检查之后修改路径（TOCTOU）。因此监督者直接对检查过的路径执行系统调用，
+// 再把结果写回目标进程
+type emulation struct {
+	kind  opKind
+	path  string  // 检查过的绝对路径
+	path2 string  // rename 的新路径
+	flags int     // 打开标志或 *at 系统调用的标志位
+	mode  uint32  // 创建文件、chmod、access 的模式
+	mask  uint32  // statx 的 mask
+	addr  uintptr // 结果缓冲区在目标进程中的地址
+	size  int     // 结果缓冲区的大小（readlink）
+}
+
+// emulate 代为执行被允许的系统调用，结果保存在 resp 中
+//
+// 监督者以自己的凭据执行，因此只有目标进程的 fsuid、fsgid、附加组和 umask 与监督者相同时才代为执行，
+// 否则会绕过目标进程的权限检查，此时拒绝该系统调用
+//
+// 返回：
+//   - bool: 是否已经回复了通知（打开文件时通过 SECCOMP_ADDFD_FLAG_SEND 回复）
+func (s *supervisor) emulate(ctx *notifyContext, resp *seccompNotifResp) bool {
+	op := ctx.op
+	if !sameCredentials(ctx.Pid) {
+		s.handler.Debug("<credentials differ, ban syscall>")
+		resp.Error = -int32(ptrace.BanRet)
+		return false
+	}
+
+	var (
+		val int64
+		err error
+	)
+	switch op.kind {
+	case opOpen:
+		return s.emulateOpen(ctx, resp)
+
+	case opStat:
+		var st unix.Stat_t
+		if err = unix.Fstatat(unix.AT_FDCWD, targetPath(ctx.Pid, op.path), &st, op.flags); err == nil {
+			err = ctx.WriteMem(op.addr, unsafe.Slice((*byte)(unsafe.Pointer(&st)), unsafe.Sizeof(st)))
+		}
+
+	case opStatx:
+		var stx unix.Statx_t
+		if err = unix.Statx(unix.AT_FDCWD, targetPath(ctx.Pid, op.path), op.flags, int(op.mask), &stx); err == nil {
+			err = ctx.WriteMem(op.addr, unsafe.Slice((*byte)(unsafe.Pointer(&stx)), unsafe.Sizeof(stx)))
+		}
+
+	case opAccess:
+		err = unix.Faccessat(unix.AT_FDCWD, targetPath(ctx.Pid, op.path), op.mode, op.flags)
+
+	case opReadlink:
+		if op.size <= 0 {
+			err = unix.EINVAL
+			break
+		}
+		// 符号链接的内容不会超过 PATH_MAX
+		buf := make([]byte, min(op.size, unix.PathMax))
+		var n int
+		if n, err = unix.Readlink(targetPath(ctx.Pid, op.path), buf); err == nil {
+			err = ctx.WriteMem(op.addr, buf[:n])
+			val = int64(n)
+		}
+
+	case opUnlink:
+		err = unix.Unlinkat(unix.AT_FDCWD, targetPath(ctx.Pid, op.path), op.flags)
+
+	case opChmod:
+		err = unix.Fchmodat(unix.AT_FDCWD, targetPath(ctx.Pid, op.path), op.mode, 0)
+
+	case opRename:
+		err = unix.Rename(targetPath(ctx.Pid, op.path), targetPath(ctx.Pid, op.path2))
+	}
+	if err != nil {
+		resp.Error = -toErrno(err)
+		return false
+	}
+	resp.Val = val
+	return false
+}
+
+// emulateOpen 由监督者打开已经检查过的路径，并通过 SECCOMP_IOCTL_NOTIF_ADDFD 将描述符安装到目标进程中
+//
+// 这样目标进程实际打开的文件就是检查过的路径，即使其他线程在检查后修改了内存中的路径也不受影响。
+// /proc 与 /dev 中的路径由内核维护，目标进程无法替换其中的符号链接，直接打开（/proc/self 等替换为目标线程）；
+// 其他路径先解析符号链接，再以 O_NOFOLLOW 打开解析后的路径，检查后被替换为符号链接时拒绝
+//
+// 只代为打开普通文件和目录（/proc 与 /dev 中还包括字符设备和管道），其他类型（例如命名管道）直接拒绝；
+// 文件以 O_NONBLOCK 打开，即使检查后被替换为 FIFO 也不会阻塞监督者
+//
+// 返回：
+//   - bool: 是否已经回复了通知，否则回复 resp
+func (s *supervisor) emulateOpen(ctx *notifyContext, resp *seccompNotifResp) bool {
+	op := ctx.op
+	target := targetPath(ctx.Pid, op.path)
+
+	openPath, flags, magic := target, op.flags, isMagicPath(target)
+	if !magic {
+		resolved, err := filepath.EvalSymlinks(target)
+		if err != nil {
+			// 文件可能尚不存在（O_CREAT），检查其所在目录
+			resolved, err = filepath.EvalSymlinks(path.Dir(target))
+			resolved = filepath.Join(resolved, path.Base(target))
+		}
+		if err != nil {
+			resp.Error = -toErrno(err)
+			return false
+		}
+		// 指向 /proc 或 /dev 的符号链接（例如指向 /proc/self）在监督者中的解析结果与目标进程不同
+		if isMagicPath(resolved) {
+			s.handler.Debug("open: symlink to ", resolved)
+			resp.Error = -int32(ptrace.BanRet)
+			return false
+		}
+		// 目标进程指定了 O_NOFOLLOW 时应当打开原路径本身
+		flags |= unix.O_NOFOLLOW
+		if op.flags&unix.O_NOFOLLOW == 0 {
+			openPath = resolved
+		}
+
+		// 先检查文件类型，避免打开 FIFO 等文件产生副作用（例如唤醒等待的写入方）
+		var st unix.Stat_t
+		if err := unix.Lstat(openPath, &st); err == nil && !isEmulatedType(st.Mode, false) {
+			s.handler.Debug("open: file type ", st.Mode&unix.S_IFMT)
+			resp.Error = -int32(ptrace.BanRet)
+			return false
+		}
+	}
+
+	fd, err := unix.Open(openPath, flags|unix.O_CLOEXEC|unix.O_NONBLOCK, op.mode)
+	if err != nil {
+		if err == unix.ELOOP && openPath != target {
+			// 解析后的路径被替换为符号链接
+			err = ptrace.BanRet
+		}
+		resp.Error = -toErrno(err)
+		return false
+	}
+	defer unix.Close(fd)
+
+	// 检查后文件可能被替换
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil || !isEmulatedType(st.Mode, magic) {
+		resp.Error = -int32(ptrace.BanRet)
+		return false
+	}
+	if op.flags&unix.O_NONBLOCK == 0 {
+		fl, err := unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0)
+		if err == nil {
+			_, err = unix.FcntlInt(uintptr(fd), unix.F_SETFL, fl&^unix.O_NONBLOCK)
+		}
+		if err != nil {
+			resp.Error = -toErrno(err)
+			return false
+		}
+	}
+
+	addfd := seccompNotifAddfd{
+		ID:    ctx.req.ID,
+		Flags: unix.SECCOMP_ADDFD_FLAG_SEND,
+		Srcfd: uint32(fd),
+	}
+	if op.flags&unix.O_CLOEXEC != 0 {
+		addfd.NewfdFlags = unix.O_CLOEXEC
+	}
+	newFd, err := notifAddfd(s.listener, &addfd)
+	switch err {
+	case nil:
+		s.handler.Debug("open: installed fd ", newFd)
+		return true
+	case unix.ENOENT:
+		// 目标线程已经退出
+		return true
+	default:
+		// 内核不支持 SECCOMP_ADDFD_FLAG_SEND，无法安全地完成打开
+		s.handler.Debug("notif addfd: ", err)
+		resp.Error = -int32(ptrace.BanRet)
+		return false
+	}
+}
+
+// WriteMem 将 b 写入目标进程的内存，写入前检查通知是否仍然有效
+func (c *notifyContext) WriteMem(addr uintptr, b []byte) error {
+	f, err := os.OpenFile("/proc/"+strconv.Itoa(c.Pid)+"/mem", os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := notifIDValid(c.fd, c.req.ID); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(b, int64(addr)); err != nil {
+		// 地址无效时与内核一致返回 EFAULT
+		return unix.EFAULT
+	}
+	return nil
+}
+
+// toErrno 将代为执行时的错误转换为返回给目标进程的错误码
+func toErrno(err error) int32 {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return int32(errno)
+	}
+	return int32(ptrace.BanRet)
+}
+
+// isEmulatedType 判断文件类型是否可以由监督者代为打开
+// magic 表示路径位于 /proc 或 /dev 中，此时还允许字符设备（/dev/null）和管道（/proc/self/fd/0）
+func isEmulatedType(mode uint32, magic bool) bool {
+	switch mode & unix.S_IFMT {
+	case unix.S_IFREG, unix.S_IFDIR:
+		return true
+	case unix.S_IFCHR, unix.S_IFIFO:
+		return magic
+	}
+	return false
+}
+
+// targetPath 将路径中指向“当前进程”的部分替换为目标线程
+// 监督者以自己的身份解析路径，/proc/self、/dev/stdin 等会指向监督者自己
+func targetPath(pid int, p string) string {
+	aliases := [...][2]string{
+		{"/dev/fd", "/proc/self/fd"},
+		{"/dev/stdin", "/proc/self/fd/0"},
+		{"/dev/stdout", "/proc/self/fd/1"},
+		{"/dev/stderr", "/proc/self/fd/2"},
+		{"/proc/self", "/proc/" + strconv.Itoa(pid)},
+		{"/proc/thread-self", fmt.Sprintf("/proc/%d/task/%d", pid, pid)},
+	}
+	for _, a := range aliases {
+		if rest, ok := strings.CutPrefix(p, a[0]); ok && (rest == "" || rest[0] == '/') {
+			p = a[1] + rest
+		}
+	}
+	return p
+}
+
+// sameCredentials 判断线程 pid 的文件系统凭据和 umask 是否与监督者相同
+func sameCredentials(pid int) bool {
+	self, err := fsCredentials("self")
+	if err != nil {
+		return false
+	}
+	target, err := fsCredentials(strconv.Itoa(pid))
+	if err != nil {
+		return false
+	}
+	return self == target
+}
+
+// fsCredentials 从 /proc/<pid>/status 中读取 fsuid、fsgid、附加组和 umask
+func fsCredentials(pid string) (string, error) {
+	b, err := os.ReadFile("/proc/" + pid + "/status")
+	if err != nil {
+		return "", err
+	}
+	var ret []string
+	for _, l := range strings.Split(string(b), "\n") {
+		k, v, ok := strings.Cut(l, ":")
+		if !ok {
+			continue
+		}
+		f := strings.Fields(v)
+		switch k {
+		case "Uid", "Gid":
+			// 实际、有效、保存、文件系统
+			if len(f) != 4 {
+				return "", fmt.Errorf("invalid %s line %q", k, l)
+			}
+			ret = append(ret, k+"="+f[3])
+		case "Groups", "Umask":
+			ret = append(ret, k+"="+strings.Join(f, ","))
+		}
+	}
+	if len(ret) != 4 {
+		return "", fmt.Errorf("incomplete status of %s", pid)
+	}
+	return strings.Join(ret, " "), nil
+}
+
+// isMagicPath 判断路径是否位于 /proc 或 /dev 下（/dev/shm 是普通的 tmpfs，不包括在内）
+func isMagicPath(p string) bool {
+	under := func(dir string) bool { return p == dir || strings.HasPrefix(p, dir+"/") }
+	return (under("/proc") || under("/dev")) && !under("/dev/shm")
+}
@@ -0,0 +1,430 @@
+package unotify
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/zqzqsb/sandbox/pkg/seccomp/libseccomp"
+	"github.com/zqzqsb/sandbox/ptracer"
+	"github.com/zqzqsb/sandbox/runner/ptrace"
+)
+
+// maxPathLen 是从目标进程读取路径时的最大长度
+const maxPathLen = unix.PathMax
+
+// notifyContext 保存一次系统调用通知的上下文
+type notifyContext struct {
+	Pid int           // 发起系统调用的线程 ID
+	req *seccompNotif // 内核传递的通知
+	fd  int           // 监听描述符，用于 ID_VALID 检查
+
+	// 被允许的路径相关系统调用由监督者代为执行（见 supervisor.emulate）
+	op *emulation
+	// 被允许的 execve / execveat 无法代为执行，见 supervisor.initialExec
+	exec bool
+}
+
+// Arg 返回系统调用的第 i 个参数
+func (c *notifyContext) Arg(i int) uint {
+	return uint(c.req.Data.Args[i])
+}
+
+// GetString 从目标进程的内存中读取以 NUL 结尾的字符串
+//
+// 通过 /proc/<pid>/mem 读取，打开内存文件后和读取完成后都会检查通知是否仍然有效，
+// 以避免目标线程已经退出、PID 被复用时读取到其他进程的内存
+func (c *notifyContext) GetString(addr uintptr) (string, error) {
+	f, err := os.Open("/proc/" + strconv.Itoa(c.Pid) + "/mem")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := notifIDValid(c.fd, c.req.ID); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 0, 256)
+	pageSize := uintptr(os.Getpagesize())
+	for len(buf) < maxPathLen {
+		// 每次最多读取到页边界，避免跨越未映射的页导致整个读取失败
+		n := pageSize - addr%pageSize
+		if rest := uintptr(maxPathLen - len(buf)); n > rest {
+			n = rest
+		}
+		chunk := make([]byte, n)
+		read, err := f.ReadAt(chunk, int64(addr))
+		if read == 0 && err != nil {
+			return "", err
+		}
+		chunk = chunk[:read]
+		if i := bytes.IndexByte(chunk, 0); i >= 0 {
+			buf = append(buf, chunk[:i]...)
+			if err := notifIDValid(c.fd, c.req.ID); err != nil {
+				return "", err
+			}
+			return string(buf), nil
+		}
+		buf = append(buf, chunk...)
+		addr += uintptr(read)
+	}
+	return "", syscall.ENAMETOOLONG
+}
+
+// notifyHandler 实现了系统调用通知的检查逻辑，与 ptrace 运行器使用相同的 Handler
+type notifyHandler struct {
+	ShowDetails bool           // 是否显示详细的调试信息
+	Unsafe      bool           // 是否启用不安全模式（软禁用而不是直接杀死进程）
+	Handler     ptrace.Handler // 具体的系统调用处理器
+}
+
+// Debug 输出调试信息到标准错误输出
+func (h *notifyHandler) Debug(v ...interface{}) {
+	if h.ShowDetails {
+		fmt.Fprintln(os.Stderr, v...)
+	}
+}
+
+// pathArg 描述系统调用中的路径参数，含义与 ptrace 运行器相同
+type pathArg struct {
+	dirfd int  // 目录文件描述符
+	addr  uint // 路径在目标进程中的地址
+	flags uint // *at 系统调用的标志位（用于 AT_EMPTY_PATH）
+}
+
+// cwdPath 返回相对于当前工作目录的路径参数
+func cwdPath(addr uint) pathArg {
+	return pathArg{dirfd: unix.AT_FDCWD, addr: addr}
+}
+
+// atPath 返回 *at 系统调用的路径参数
+func atPath(dirfd, addr, flags uint) pathArg {
+	return pathArg{dirfd: int(int32(dirfd)), addr: addr, flags: flags}
+}
+
+// getString 读取路径并转换为绝对路径
+// 读取失败或 dirfd 无法解析时返回错误，调用者应拒绝该系统调用
+func (h *notifyHandler) getString(ctx *notifyContext, p pathArg) (string, error) {
+	s, err := ctx.GetString(uintptr(p.addr))
+	if err != nil {
+		return "", err
+	}
+	return ptrace.AbsPathAt(ctx.Pid, p.dirfd, s, p.flags&unix.AT_EMPTY_PATH != 0)
+}
+
+// allow 在检查通过时记录需要代为执行的系统调用
+func allow(ctx *notifyContext, action ptracer.TraceAction, op emulation) ptracer.TraceAction {
+	if action == ptracer.TraceAllow {
+		ctx.op = &op
+	}
+	return action
+}
+
+// checkStat 检查获取文件状态的操作
+// AT_EMPTY_PATH 且路径为空时与 fstat 相同，直接允许，由监督者通过 /proc/<pid>/fd/<dirfd> 代为执行
+func (h *notifyHandler) checkStat(ctx *notifyContext, p pathArg, name string, op emulation) ptracer.TraceAction {
+	s, err := ctx.GetString(uintptr(p.addr))
+	if err != nil {
+		h.Debug(name, ": failed to read path: ", err)
+		return ptracer.TraceBan
+	}
+	if s == "" && p.flags&unix.AT_EMPTY_PATH != 0 && p.dirfd != unix.AT_FDCWD {
+		h.Debug(name, ": fd ", p.dirfd)
+		op.path = fmt.Sprintf("/proc/%d/fd/%d", ctx.Pid, p.dirfd)
+		op.flags &^= unix.AT_EMPTY_PATH | unix.AT_SYMLINK_NOFOLLOW
+		return allow(ctx, ptracer.TraceAllow, op)
+	}
+	return h.checkPath(ctx, p, name, h.Handler.CheckStat, op)
+}
+
+// checkOpen 检查打开文件的操作是否允许
+// 允许时记录打开参数，由监督者代为打开文件
+func (h *notifyHandler) checkOpen(ctx *notifyContext, p pathArg, flags, mode uint) ptracer.TraceAction {
+	fn, err := h.getString(ctx, p)
+	if err != nil {
+		h.Debug("open: failed to read path: ", err)
+		return ptracer.TraceBan
+	}
+	isReadOnly := (flags&syscall.O_ACCMODE == syscall.O_RDONLY) &&
+		(flags&syscall.O_CREAT == 0) &&
+		(flags&syscall.O_EXCL == 0) &&
+		(flags&syscall.O_TRUNC == 0)
+
+	h.Debug("open: ", fn, flags&syscall.O_ACCMODE)
+	var action ptracer.TraceAction
+	if isReadOnly {
+		action = h.Handler.CheckRead(fn)
+	} else {
+		action = h.Handler.CheckWrite(fn)
+	}
+	return allow(ctx, action, emulation{kind: opOpen, path: fn, flags: int(flags), mode: uint32(mode)})
+}
+
+// checkPath 读取路径并交给 check 检查，允许时由监督者对检查过的路径执行 op
+func (h *notifyHandler) checkPath(ctx *notifyContext, p pathArg, name string, check func(string) ptracer.TraceAction, op emulation) ptracer.TraceAction {
+	fn, err := h.getString(ctx, p)
+	if err != nil {
+		h.Debug(name, ": failed to read path: ", err)
+		return ptracer.TraceBan
+	}
+	h.Debug(name, ": ", fn)
+	op.path = fn
+	return allow(ctx, check(fn), op)
+}
+
+// checkRename 检查重命名操作，新旧两个路径都需要写权限
+func (h *notifyHandler) checkRename(ctx *notifyContext) ptracer.TraceAction {
+	newPath, err := h.getString(ctx, cwdPath(ctx.Arg(1)))
+	if err != nil {
+		h.Debug("rename: failed to read path: ", err)
+		return ptracer.TraceBan
+	}
+	h.Debug("rename: to ", newPath)
+	if action := h.Handler.CheckWrite(newPath); action != ptracer.TraceAllow {
+		return action
+	}
+	return h.checkPath(ctx, cwdPath(ctx.Arg(0)), "rename", h.Handler.CheckWrite, emulation{kind: opRename, path2: newPath})
+}
+
+// checkExec 检查执行程序的操作
+func (h *notifyHandler) checkExec(ctx *notifyContext, p pathArg, name string) ptracer.TraceAction {
+	fn, err := h.getString(ctx, p)
+	if err != nil {
+		h.Debug(name, ": failed to read path: ", err)
+		return ptracer.TraceBan
+	}
+	h.Debug(name, ": ", fn)
+	action := h.Handler.CheckRead(fn)
+	ctx.exec = action == ptracer.TraceAllow
+	return action
+}
+
+// Handle 处理一次系统调用通知，分发规则与 ptrace 运行器保持一致
+//
+// 路径相关的系统调用被允许时记录在 ctx.op 中，由监督者代为执行
+func (h *notifyHandler) Handle(ctx *notifyContext) ptracer.TraceAction {
+	data := &ctx.req.Data
+	syscallName, err := libseccomp.ToSyscallName(uint(data.Nr))
+	h.Debug("syscall:", data.Nr, syscallName, err)
+	if err != nil {
+		h.Debug("invalid syscall no")
+		return ptracer.TraceKill
+	}
+
+	var (
+		read  = h.Handler.CheckRead
+		write = h.Handler.CheckWrite
+		stat  = h.Handler.CheckStat
+	)
+
+	action := ptracer.TraceKill
+	switch syscallName {
+	// 文件打开相关系统调用
+	case "open":
+		action = h.checkOpen(ctx, cwdPath(ctx.Arg(0)), ctx.Arg(1), ctx.Arg(2))
+	case "openat":
+		action = h.checkOpen(ctx, atPath(ctx.Arg(0), ctx.Arg(1), 0), ctx.Arg(2), ctx.Arg(3))
+
+	// 符号链接读取相关系统调用
+	case "readlink":
+		action = h.checkPath(ctx, cwdPath(ctx.Arg(0)), "readlink", read,
+			emulation{kind: opReadlink, addr: uintptr(ctx.Arg(1)), size: int(int32(ctx.Arg(2)))})
+	case "readlinkat":
+		action = h.checkPath(ctx, atPath(ctx.Arg(0), ctx.Arg(1), unix.AT_EMPTY_PATH), "readlinkat", read,
+			emulation{kind: opReadlink, addr: uintptr(ctx.Arg(2)), size: int(int32(ctx.Arg(3)))})
+
+	// 文件删除相关系统调用
+	case "unlink":
+		action = h.checkPath(ctx, cwdPath(ctx.Arg(0)), "unlink", write, emulation{kind: opUnlink})
+	case "unlinkat":
+		action = h.checkPath(ctx, atPath(ctx.Arg(0), ctx.Arg(1), 0), "unlinkat", write,
+			emulation{kind: opUnlink, flags: int(ctx.Arg(2))})
+
+	// 文件访问权限检查相关系统调用
+	case "access":
+		action = h.checkPath(ctx, cwdPath(ctx.Arg(0)), "access", stat,
+			emulation{kind: opAccess, mode: uint32(ctx.Arg(1))})
+	case "faccessat":
+		action = h.checkPath(ctx, atPath(ctx.Arg(0), ctx.Arg(1), 0), "faccessat", stat,
+			emulation{kind: opAccess, mode: uint32(ctx.Arg(2))})
+	case "faccessat2":
+		action = h.checkStat(ctx, atPath(ctx.Arg(0), ctx.Arg(1), ctx.Arg(3)), syscallName,
+			emulation{kind: opAccess, mode: uint32(ctx.Arg(2)), flags: int(ctx.Arg(3))})
+
+	// 文件状态查询相关系统调用
+	case "newfstatat":
+		action = h.checkStat(ctx, atPath(ctx.Arg(0), ctx.Arg(1), ctx.Arg(3)), syscallName,
+			emulation{kind: opStat, addr: uintptr(ctx.Arg(2)), flags: int(ctx.Arg(3))})
+	case "statx":
+		action = h.checkStat(ctx, atPath(ctx.Arg(0), ctx.Arg(1), ctx.Arg(2)), syscallName,
+			emulation{kind: opStatx, addr: uintptr(ctx.Arg(4)), flags: int(ctx.Arg(2)), mask: uint32(ctx.Arg(3))})
+	case "stat", "stat64":
+		action = h.checkPath(ctx, cwdPath(ctx.Arg(0)), syscallName, stat,
+			emulation{kind: opStat, addr: uintptr(ctx.Arg(1))})
+	case "lstat", "lstat64":
+		action = h.checkPath(ctx, cwdPath(ctx.Arg(0)), syscallName, stat,
+			emulation{kind: opStat, addr: uintptr(ctx.Arg(1)), flags: unix.AT_SYMLINK_NOFOLLOW})
+
+	// 程序执行相关系统调用
+	case "execve":
+		action = h.checkExec(ctx, cwdPath(ctx.Arg(0)), "execve")
+	case "execveat":
+		action = h.checkExec(ctx, atPath(ctx.Arg(0), ctx.Arg(1), ctx.Arg(4)), "execveat")
+
+	// 文件权限修改相关系统调用
+	case "chmod":
+		action = h.checkPath(ctx, cwdPath(ctx.Arg(0)), "chmod", write,
+			emulation{kind: opChmod, mode: uint32(ctx.Arg(1))})
+	case "rename":
+		action = h.checkRename(ctx)
+
+	// 其他系统调用
+	default:
+		action = h.Handler.CheckSyscall(syscallName)
+		if h.Unsafe && action == ptracer.TraceKill {
+			action = ptracer.TraceBan
+		}
+	}
+	return action
+}
+
+// supervisor 在独立的 goroutine 中接收并回复系统调用通知
+type supervisor struct {
+	handler *notifyHandler
+
+	pid      int // 子进程 PID（同时也是进程组 ID）
+	listener int // 监听描述符
+	stopFd   int // eventfd，用于通知 goroutine 退出
+
+	done     chan struct{} // goroutine 退出后关闭
+	execed   bool          // 子进程最初的 execve 是否已经放行
+	killed   bool          // 是否因禁止的系统调用杀死了子进程（在 done 关闭后读取）
+	killInfo string        // 被禁止的系统调用信息
+}
+
+// start 取得子进程的监听描述符并启动处理 goroutine
+// 在 SyncFunc 中调用，此时子进程已加载过滤器但尚未调用 execve
+func (s *supervisor) start(pid int) error {
+	listener, err := getListener(pid)
+	if err != nil {
+		return err
+	}
+	stopFd, err := unix.Eventfd(0, unix.EFD_CLOEXEC)
+	if err != nil {
+		unix.Close(listener)
+		return err
+	}
+	s.pid, s.listener, s.stopFd = pid, listener, stopFd
+	s.done = make(chan struct{})
+	go s.serve()
+	return nil
+}
+
+// stop 停止处理 goroutine 并关闭描述符
+func (s *supervisor) stop() {
+	if s.done == nil {
+		return
+	}
+	var one = [8]byte{1}
+	unix.Write(s.stopFd, one[:])
+	<-s.done
+	unix.Close(s.listener)
+	unix.Close(s.stopFd)
+}
+
+// serve 循环接收系统调用通知，直到收到停止信号或所有使用过滤器的进程退出
+func (s *supervisor) serve() {
+	defer close(s.done)
+
+	fds := []unix.PollFd{
+		{Fd: int32(s.listener), Events: unix.POLLIN},
+		{Fd: int32(s.stopFd), Events: unix.POLLIN},
+	}
+	var req seccompNotif
+	for {
+		fds[0].Revents, fds[1].Revents = 0, 0
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			s.handler.Debug("poll: ", err)
+			return
+		}
+		if fds[1].Revents != 0 {
+			return
+		}
+		if fds[0].Revents&unix.POLLIN == 0 {
+			// POLLHUP: 所有使用该过滤器的进程都已退出
+			return
+		}
+		if err := notifRecv(s.listener, &req); err != nil {
+			// ENOENT: 目标线程在接收前被信号中断或已退出
+			if err == unix.ENOENT || err == unix.EINTR {
+				continue
+			}
+			s.handler.Debug("notif recv: ", err)
+			return
+		}
+		s.handle(&req)
+	}
+}
+
+// handle 检查一次系统调用通知并回复
+func (s *supervisor) handle(req *seccompNotif) {
+	ctx := &notifyContext{Pid: int(req.Pid), req: req, fd: s.listener}
+	resp := seccompNotifResp{ID: req.ID}
+
+	action := ptracer.TraceKill
+	if req.Data.Arch == nativeArch {
+		action = s.handler.Handle(ctx)
+	}
+
+	switch action {
+	case ptracer.TraceAllow:
+		// 注意：SECCOMP_USER_NOTIF_FLAG_CONTINUE 会让内核重新读取用户内存，
+		// 因此只用于检查不依赖目标进程内存的系统调用
+		switch {
+		case ctx.op != nil:
+			if s.emulate(ctx, &resp) {
+				return
+			}
+		case ctx.exec && !s.initialExec(ctx):
+			s.handler.Debug("<ban execve after start>")
+			resp.Error = -int32(ptrace.BanRet)
+		default:
+			resp.Flags = unix.SECCOMP_USER_NOTIF_FLAG_CONTINUE
+		}
+
+	case ptracer.TraceBan:
+		s.handler.Debug("<soft ban syscall>")
+		resp.Error = -int32(ptrace.BanRet)
+
+	default:
+		name, _ := libseccomp.ToSyscallName(uint(req.Data.Nr))
+		s.killed = true
+		s.killInfo = fmt.Sprintf("disallowed syscall: %d(%s)", req.Data.Nr, name)
+		unix.Kill(-s.pid, unix.SIGKILL)
+		resp.Error = -int32(unix.EPERM)
+	}
+
+	if err := notifSend(s.listener, &resp); err != nil && err != unix.ENOENT {
+		s.handler.Debug("notif send: ", err)
+	}
+}
+
+// initialExec 判断 execve 是否是子进程最初的 execve
+//
+// execve 无法由监督者代为执行，只能让内核重新读取路径。子进程在 execve 之前只有一个线程，
+// 内存也不与其他进程共享，路径不会在检查后被修改；此后目标程序的 execve 都会被拒绝
+func (s *supervisor) initialExec(ctx *notifyContext) bool {
+	if s.execed || ctx.Pid != s.pid {
+		return false
+	}
+	s.execed = true
+	return true
+}
@@ -0,0 +1,137 @@
+package unotify
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"unsafe"
+
+	"github.com/elastic/go-seccomp-bpf/arch"
+	"golang.org/x/sys/unix"
+)
+
+// seccompData 对应内核的 struct seccomp_data
+type seccompData struct {
+	Nr                 int32
+	Arch               uint32
+	InstructionPointer uint64
+	Args               [6]uint64
+}
+
+// seccompNotif 对应内核的 struct seccomp_notif（SECCOMP_IOCTL_NOTIF_RECV）
+type seccompNotif struct {
+	ID    uint64
+	Pid   uint32
+	Flags uint32
+	Data  seccompData
+}
+
+// seccompNotifResp 对应内核的 struct seccomp_notif_resp（SECCOMP_IOCTL_NOTIF_SEND）
+type seccompNotifResp struct {
+	ID    uint64
+	Val   int64
+	Error int32
+	Flags uint32
+}
+
+// seccompNotifAddfd 对应内核的 struct seccomp_notif_addfd（SECCOMP_IOCTL_NOTIF_ADDFD）
+type seccompNotifAddfd struct {
+	ID         uint64
+	Flags      uint32
+	Srcfd      uint32
+	Newfd      uint32
+	NewfdFlags uint32
+}
+
+// nativeArch 是本机的 AUDIT_ARCH 值，通知中的架构与之不同时直接终止进程
+var nativeArch = func() uint32 {
+	info, err := arch.GetInfo("")
+	if err != nil {
+		return 0
+	}
+	return uint32(info.ID)
+}()
+
+// listenerLink 是用户态通知监听描述符在 /proc/<pid>/fd 中的链接目标
+const listenerLink = "anon_inode:seccomp notify"
+
+// getListener 从子进程中取得 seccomp 用户态通知的监听描述符
+//
+// 子进程通过 SECCOMP_FILTER_FLAG_NEW_LISTENER 加载过滤器后，监听描述符只存在于子进程中，
+// 这里在 /proc/<pid>/fd 中查找该描述符并通过 pidfd_getfd 复制到当前进程
+//
+// 参数：
+//   - pid: 子进程的 PID（此时子进程正在等待同步，尚未调用 execve）
+//
+// 返回：
+//   - int: 当前进程中的监听描述符（带有 close-on-exec 标志）
+//   - error: 找不到监听描述符或复制失败时返回错误
+func getListener(pid int) (int, error) {
+	pidfd, err := unix.PidfdOpen(pid, 0)
+	if err != nil {
+		return -1, fmt.Errorf("pidfd_open: %w", err)
+	}
+	defer unix.Close(pidfd)
+
+	dir := fmt.Sprintf("/proc/%d/fd", pid)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return -1, err
+	}
+	for _, e := range entries {
+		link, err := os.Readlink(dir + "/" + e.Name())
+		if err != nil || link != listenerLink {
+			continue
+		}
+		targetFd, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		fd, err := unix.PidfdGetfd(pidfd, targetFd, 0)
+		if err != nil {
+			return -1, fmt.Errorf("pidfd_getfd: %w", err)
+		}
+		return fd, nil
+	}
+	return -1, errors.New("seccomp notify listener not found")
+}
+
+// notifRecv 接收一个系统调用通知，调用前会清空 req（内核要求）
+func notifRecv(fd int, req *seccompNotif) error {
+	*req = seccompNotif{}
+	return ioctl(fd, unix.SECCOMP_IOCTL_NOTIF_RECV, unsafe.Pointer(req))
+}
+
+// notifSend 回复系统调用通知
+func notifSend(fd int, resp *seccompNotifResp) error {
+	return ioctl(fd, unix.SECCOMP_IOCTL_NOTIF_SEND, unsafe.Pointer(resp))
+}
+
+// notifIDValid 检查通知是否仍然有效（即目标线程仍在等待该通知的回复）
+// 在读取目标进程内存后调用，确保读取到的内容属于发起该系统调用的进程
+func notifIDValid(fd int, id uint64) error {
+	return ioctl(fd, unix.SECCOMP_IOCTL_NOTIF_ID_VALID, unsafe.Pointer(&id))
+}
+
+// notifAddfd 将当前进程的文件描述符安装到目标进程中
+// 带有 SECCOMP_ADDFD_FLAG_SEND 时同时以新描述符作为返回值回复通知
+//
+// 返回：
+//   - int: 目标进程中的文件描述符
+//   - error: 失败时返回错误（内核早于 5.14 时不支持 SECCOMP_ADDFD_FLAG_SEND，返回 EINVAL）
+func notifAddfd(fd int, addfd *seccompNotifAddfd) (int, error) {
+	r1, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(unix.SECCOMP_IOCTL_NOTIF_ADDFD), uintptr(unsafe.Pointer(addfd)))
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(r1), nil
+}
+
+func ioctl(fd int, req uint, arg unsafe.Pointer) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package unotify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/zqzqsb/sandbox/pkg/forkexec"
+	"github.com/zqzqsb/sandbox/runner"
+)
+
+// Run 启动进程并处理系统调用通知
+//
+// 过程：
+// 1. 子进程以 SECCOMP_FILTER_FLAG_NEW_LISTENER 加载过滤器
+// 2. 在同步阶段取得监听描述符并启动监督者 goroutine
+// 3. 等待子进程结束并检查资源使用
+//
+// 参数：
+//   - c: 上下文，用于控制进程的生命周期
+//
+// 返回：
+//   - runner.Result: 包含进程运行结果的信息
+func (r *Runner) Run(c context.Context) (result runner.Result) {
+	s := &supervisor{
+		handler: &notifyHandler{
+			ShowDetails: r.ShowDetails,
+			Unsafe:      r.Unsafe,
+			Handler:     r.Handler,
+		},
+	}
+
+	ch := &forkexec.Runner{
+		Args:          r.Args,
+		Env:           r.Env,
+		ExecFile:      r.ExecFile,
+		RLimits:       r.RLimits,
+		Files:         r.Files,
+		WorkDir:       r.WorkDir,
+		Seccomp:       r.Seccomp.SockFprog(),
+		SeccompNotify: true,
+		NoNewPrivs:    true,
+		SyncFunc: func(pid int) error {
+			if r.SyncFunc != nil {
+				if err := r.SyncFunc(pid); err != nil {
+					return err
+				}
+			}
+			// 子进程的 execve 需要监督者处理，因此必须在同步阶段启动监督者
+			return s.start(pid)
+		},
+	}
+
+	var (
+		wstatus unix.WaitStatus
+		rusage  unix.Rusage
+		status  = runner.StatusNormal
+		sTime   = time.Now()
+		fTime   time.Time
+	)
+
+	pgid, err := ch.Start()
+	r.println("Starts: ", pgid, err)
+	if err != nil {
+		s.stop()
+		result.Status = runner.StatusRunnerError
+		result.Error = err.Error()
+		return
+	}
+
+	ctx, cancel := r.Limit.WithWallTimeLimit(c)
+	defer cancel()
+
+	go func() {
+		<-ctx.Done()
+		killAll(pgid)
+	}()
+
+	defer func() {
+		killAll(pgid)
+		collectZombie(pgid)
+		s.stop()
+		// 因禁止的系统调用被终止
+		if s.killed {
+			result.Status = runner.StatusDisallowedSyscall
+			result.Error = s.killInfo
+		}
+		result.SetUpTime = fTime.Sub(sTime)
+		result.RunningTime = time.Since(fTime)
+	}()
+
+	fTime = time.Now()
+	counter := runner.CountProcesses(pgid)
+
+	// 在回收前读取 /proc/<pid>/io，等待失败时由 wait4 报告错误
+	var info unix.Siginfo
+	for unix.Waitid(unix.P_PID, pgid, &info, unix.WEXITED|unix.WNOWAIT, nil) == unix.EINTR {
+	}
+	wallTime := time.Since(fTime)
+	processes := counter.Stop()
+	readBytes, writeBytes, err := runner.ProcIO(pgid)
+	r.println("io: ", readBytes, writeBytes, err)
+
+	for {
+		_, err := unix.Wait4(pgid, &wstatus, 0, &rusage)
+		if err == unix.EINTR {
+			continue
+		}
+		r.println("wait4: ", wstatus)
+		if err != nil {
+			result.Status = runner.StatusRunnerError
+			result.Error = err.Error()
+			return
+		}
+
+		result = runner.Result{
+			WallTime:   wallTime,
+			ReadBytes:  readBytes,
+			WriteBytes: writeBytes,
+			Processes:  processes,
+		}
+		result.SetRusage(&rusage)
+
+		status = r.Limit.Check(&result)
+		result.Status = status
+		if status != runner.StatusNormal {
+			return
+		}
+
+		switch {
+		case wstatus.Exited():
+			result.ExitStatus = wstatus.ExitStatus()
+			if result.ExitStatus != 0 {
+				result.Status = runner.StatusNonzeroExitStatus
+			}
+			return
+
+		case wstatus.Signaled():
+			sig := wstatus.Signal()
+			switch sig {
+			case unix.SIGXCPU:
+				status = runner.StatusTimeLimitExceeded
+			case unix.SIGXFSZ:
+				status = runner.StatusOutputLimitExceeded
+			case unix.SIGSYS:
+				status = runner.StatusDisallowedSyscall
+			default:
+				status = runner.StatusSignalled
+			}
+			if sig == unix.SIGKILL && runner.WallTimeExceeded(ctx) {
+				status = runner.StatusWallTimeLimitExceeded
+			}
+			result.Status = status
+			result.ExitStatus = int(sig)
+			return
+		}
+	}
+}
+
+// killAll 终止指定进程组的所有进程
+func killAll(pgid int) {
+	unix.Kill(-pgid, unix.SIGKILL)
+}
+
+// collectZombie 回收指定进程组的所有僵尸进程
+func collectZombie(pgid int) {
+	var wstatus unix.WaitStatus
+	for {
+		if _, err := unix.Wait4(-pgid, &wstatus, unix.WALL|unix.WNOHANG, nil); err != unix.EINTR && err != nil {
+			break
+		}
+	}
+}
+
+// println 输出调试信息到标准错误
+func (r *Runner) println(v ...interface{}) {
+	if r.ShowDetails {
+		fmt.Fprintln(os.Stderr, v...)
+	}
+}
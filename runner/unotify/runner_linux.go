@@ -0,0 +1,48 @@
+package unotify
+
+import (
+	"github.com/zqzqsb/sandbox/pkg/rlimit"
+	"github.com/zqzqsb/sandbox/pkg/seccomp"
+	"github.com/zqzqsb/sandbox/runner"
+	"github.com/zqzqsb/sandbox/runner/ptrace"
+)
+
+// Runner 定义了使用 seccomp 用户态通知安全运行程序的规范
+type Runner struct {
+	// Args 定义子进程的命令行参数
+	Args []string
+
+	// Env 定义子进程的环境变量
+	Env []string
+
+	// WorkDir 定义子进程的工作目录
+	WorkDir string
+
+	// ExecFile 是要执行的文件的文件描述符（fexecve）
+	ExecFile uintptr
+
+	// Files 定义了子进程的文件描述符映射，索引对应新进程中的文件描述符编号
+	Files []uintptr
+
+	// RLimits 定义了通过 setrlimit 设置的资源限制
+	RLimits []rlimit.RLimit
+
+	// Limit 定义了运行结束后检查的资源限制
+	Limit runner.Limit
+
+	// Seccomp 定义了安全计算模式过滤器
+	// - 文件访问相关的系统调用需要设置为 ActionNotify
+	// - 允许的系统调用设置为 ActionAllow
+	// - 默认动作应该是 ActionNotify 或 ActionKill
+	Seccomp seccomp.Filter
+
+	// Handler 定义了系统调用的处理器，与 ptrace 运行器相同
+	Handler ptrace.Handler
+
+	// ShowDetails 控制是否显示详细的调试信息
+	// Unsafe 控制是否允许不安全的操作（软禁用而不是杀死进程）
+	ShowDetails, Unsafe bool
+
+	// SyncFunc 定义了进程同步函数，主要用于将进程添加到 cgroup 中
+	SyncFunc func(pid int) error
+}
@@ -0,0 +1,100 @@
+// Command helper 是 runner/unotify 测试使用的辅助程序
+//
+// 用法：
+//
+//	helper open <dir> <name>          打开 dir 后通过 openat(dirfd, name) 读取文件并输出内容
+//	helper stat <file>                通过 newfstatat 与 readlink 获取文件状态，输出大小与链接内容
+//	helper race <allowed> <banned> <n>  另一个线程不断修改路径，同时打开 n 次，输出读到其他内容的次数
+//	helper exec <file>                执行 file
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func main() {
+	if len(os.Args) < 3 {
+		fmt.Fprintln(os.Stderr, "usage: helper open|stat|race|exec ...")
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "open":
+		if len(os.Args) < 4 {
+			os.Exit(2)
+		}
+		dirfd, err := unix.Open(os.Args[2], unix.O_RDONLY|unix.O_DIRECTORY, 0)
+		check(err)
+		fd, err := unix.Openat(dirfd, os.Args[3], unix.O_RDONLY, 0)
+		check(err)
+		os.Stdout.Write(readAll(fd))
+
+	case "stat":
+		var st unix.Stat_t
+		check(unix.Fstatat(unix.AT_FDCWD, os.Args[2], &st, unix.AT_SYMLINK_NOFOLLOW))
+		buf := make([]byte, 256)
+		n, err := unix.Readlink(os.Args[2], buf)
+		check(err)
+		fmt.Print(st.Size, " ", string(buf[:n]))
+
+	case "race":
+		if len(os.Args) < 5 {
+			os.Exit(2)
+		}
+		allowed, banned := os.Args[2], os.Args[3]
+		if len(allowed) != len(banned) {
+			os.Exit(2)
+		}
+		n, err := strconv.Atoi(os.Args[4])
+		check(err)
+		allowedContent, err := os.ReadFile(allowed)
+		check(err)
+
+		// 路径缓冲区由两个线程共享，另一个线程在 allowed 与 banned 之间来回修改
+		buf := append([]byte(allowed), 0)
+		go func() {
+			for {
+				copy(buf, banned)
+				copy(buf, allowed)
+			}
+		}()
+		leaked, cwd := 0, unix.AT_FDCWD
+		for i := 0; i < n; i++ {
+			fd, _, errno := unix.Syscall6(unix.SYS_OPENAT, uintptr(cwd), uintptr(unsafe.Pointer(&buf[0])), unix.O_RDONLY, 0, 0, 0)
+			if errno != 0 {
+				continue
+			}
+			if !bytes.Equal(readAll(int(fd)), allowedContent) {
+				leaked++
+			}
+			unix.Close(int(fd))
+		}
+		fmt.Print(leaked)
+
+	case "exec":
+		check(unix.Exec(os.Args[2], os.Args[2:], nil))
+
+	default:
+		os.Exit(2)
+	}
+}
+
+func readAll(fd int) []byte {
+	buf := make([]byte, 4096)
+	n, err := unix.Read(fd, buf)
+	check(err)
+	return buf[:n]
+}
+
+func check(err error) {
+	if err != nil {
+		fmt.Print(err)
+		os.Exit(1)
+	}
+}
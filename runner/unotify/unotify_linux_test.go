@@ -0,0 +1,303 @@
+package unotify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/zqzqsb/sandbox/pkg/seccomp/libseccomp"
+	"github.com/zqzqsb/sandbox/ptracer"
+	"github.com/zqzqsb/sandbox/runner"
+)
+
+var notifySyscalls = []string{
+	"execve", "open", "openat", "unlink", "unlinkat", "readlink", "readlinkat", "newfstatat", "access", "faccessat",
+}
+
+// helperPath 是 testdata/helper 编译后的路径
+var helperPath string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "unotify_test")
+	if err != nil {
+		panic(err)
+	}
+	helperPath = filepath.Join(dir, "helper")
+	cmd := exec.Command("go", "build", "-o", helperPath, "./testdata/helper")
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Stderr.Write(out)
+		helperPath = ""
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testHandler 记录检查过的路径，并拒绝 ban 中的路径
+type testHandler struct {
+	mu      sync.Mutex
+	checked []string
+	ban     string
+	kill    string
+}
+
+func (h *testHandler) check(fn string) ptracer.TraceAction {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checked = append(h.checked, fn)
+	switch fn {
+	case h.ban:
+		return ptracer.TraceBan
+	case h.kill:
+		return ptracer.TraceKill
+	}
+	return ptracer.TraceAllow
+}
+
+func (h *testHandler) CheckRead(fn string) ptracer.TraceAction  { return h.check(fn) }
+func (h *testHandler) CheckWrite(fn string) ptracer.TraceAction { return h.check(fn) }
+func (h *testHandler) CheckStat(fn string) ptracer.TraceAction  { return h.check(fn) }
+func (h *testHandler) CheckSyscall(string) ptracer.TraceAction  { return ptracer.TraceAllow }
+
+func runCat(t *testing.T, h *testHandler, file string) (runner.Result, string) {
+	t.Helper()
+	return run(t, h, "/bin/cat", file)
+}
+
+// runHelper 运行 testdata/helper
+func runHelper(t *testing.T, h *testHandler, args ...string) (runner.Result, string) {
+	t.Helper()
+	if helperPath == "" {
+		t.Skip("helper not built")
+	}
+	return run(t, h, append([]string{helperPath}, args...)...)
+}
+
+func run(t *testing.T, h *testHandler, args ...string) (runner.Result, string) {
+	t.Helper()
+
+	b := libseccomp.Builder{
+		Notify:  notifySyscalls,
+		Default: libseccomp.ActionAllow,
+	}
+	filter, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	r := &Runner{
+		Args:    args,
+		Env:     []string{"PATH=/bin:/usr/bin"},
+		Files:   []uintptr{0, out.Fd(), out.Fd()},
+		Limit:   runner.Limit{TimeLimit: 1e9, MemoryLimit: 256 << 20},
+		Seccomp: filter,
+		Handler: h,
+	}
+	result := r.Run(context.Background())
+	content, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result, string(content)
+}
+
+func TestRunner(t *testing.T) {
+	dir := t.TempDir()
+	allowed := filepath.Join(dir, "allowed")
+	banned := filepath.Join(dir, "banned")
+	for _, f := range []string{allowed, banned} {
+		if err := os.WriteFile(f, []byte("content of "+f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("allow", func(t *testing.T) {
+		h := &testHandler{ban: banned}
+		result, out := runCat(t, h, allowed)
+		if result.Status != runner.StatusNormal {
+			t.Fatalf("unexpected result: %v", result)
+		}
+		if out != "content of "+allowed {
+			t.Errorf("unexpected output: %q", out)
+		}
+		if !h.contains(allowed) || !h.contains("/bin/cat") {
+			t.Errorf("path not checked: %v", h.checked)
+		}
+	})
+
+	t.Run("ban", func(t *testing.T) {
+		h := &testHandler{ban: banned}
+		result, out := runCat(t, h, banned)
+		if result.Status != runner.StatusNonzeroExitStatus {
+			t.Fatalf("unexpected result: %v", result)
+		}
+		if !strings.Contains(out, "Permission denied") {
+			t.Errorf("unexpected output: %q", out)
+		}
+	})
+
+	t.Run("fifo", func(t *testing.T) {
+		// 命名管道无法由监督者代为打开（打开会阻塞），直接拒绝
+		fifo := filepath.Join(dir, "fifo")
+		if err := unix.Mkfifo(fifo, 0644); err != nil {
+			t.Fatal(err)
+		}
+		h := &testHandler{}
+		result, out := runCat(t, h, fifo)
+		if result.Status != runner.StatusNonzeroExitStatus {
+			t.Fatalf("unexpected result: %v", result)
+		}
+		if !strings.Contains(out, "Permission denied") {
+			t.Errorf("unexpected output: %q", out)
+		}
+	})
+
+	t.Run("openat", func(t *testing.T) {
+		h := &testHandler{ban: banned}
+		result, out := runHelper(t, h, "open", dir, "allowed")
+		if result.Status != runner.StatusNormal {
+			t.Fatalf("unexpected result: %v %q", result, out)
+		}
+		if out != "content of "+allowed {
+			t.Errorf("unexpected output: %q", out)
+		}
+
+		h = &testHandler{ban: banned}
+		result, out = runHelper(t, h, "open", dir, "banned")
+		if result.Status != runner.StatusNonzeroExitStatus || out != "permission denied" {
+			t.Fatalf("unexpected result: %v %q", result, out)
+		}
+	})
+
+	t.Run("stat", func(t *testing.T) {
+		link := filepath.Join(dir, "link")
+		if err := os.Symlink("allowed", link); err != nil {
+			t.Fatal(err)
+		}
+		h := &testHandler{ban: banned}
+		result, out := runHelper(t, h, "stat", link)
+		if result.Status != runner.StatusNormal {
+			t.Fatalf("unexpected result: %v %q", result, out)
+		}
+		if want := fmt.Sprint(len("allowed"), " allowed"); out != want {
+			t.Errorf("unexpected output: %q, want %q", out, want)
+		}
+	})
+
+	t.Run("race", func(t *testing.T) {
+		// 两个路径长度相同，另一个线程在检查后修改路径也不能打开被禁止的文件
+		good, bad := filepath.Join(dir, "race_good"), filepath.Join(dir, "race_bad_")
+		for _, f := range []string{good, bad} {
+			if err := os.WriteFile(f, []byte("content of "+f), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		h := &testHandler{ban: bad}
+		result, out := runHelper(t, h, "race", good, bad, "2000")
+		if result.Status != runner.StatusNormal {
+			t.Fatalf("unexpected result: %v %q", result, out)
+		}
+		if out != "0" {
+			t.Errorf("banned file opened %s times", out)
+		}
+	})
+
+	t.Run("exec", func(t *testing.T) {
+		// execve 无法代为执行，只允许子进程最初的 execve
+		h := &testHandler{}
+		result, out := runHelper(t, h, "exec", "/bin/true")
+		if result.Status != runner.StatusNonzeroExitStatus || out != "permission denied" {
+			t.Fatalf("unexpected result: %v %q", result, out)
+		}
+	})
+
+	t.Run("kill", func(t *testing.T) {
+		h := &testHandler{kill: banned}
+		result, _ := runCat(t, h, banned)
+		if result.Status != runner.StatusDisallowedSyscall {
+			t.Fatalf("unexpected result: %v", result)
+		}
+	})
+}
+
+func (h *testHandler) contains(fn string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, c := range h.checked {
+		if c == fn {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWallTimeLimit(t *testing.T) {
+	b := libseccomp.Builder{
+		Notify:  notifySyscalls,
+		Default: libseccomp.ActionAllow,
+	}
+	filter, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{
+		Args:    []string{"/bin/sleep", "5"},
+		Env:     []string{"PATH=/bin:/usr/bin"},
+		Limit:   runner.Limit{TimeLimit: 1e9, MemoryLimit: 256 << 20, WallTimeLimit: 200 * time.Millisecond},
+		Seccomp: filter,
+		Handler: &testHandler{},
+	}
+	result := r.Run(context.Background())
+	if result.Status != runner.StatusWallTimeLimitExceeded {
+		t.Fatalf("unexpected result: %v", result)
+	}
+	if result.WallTime < 200*time.Millisecond || result.WallTime > 2*time.Second {
+		t.Errorf("unexpected wall time: %v", result.WallTime)
+	}
+}
+
+func TestProcesses(t *testing.T) {
+	b := libseccomp.Builder{
+		Notify:  notifySyscalls,
+		Default: libseccomp.ActionAllow,
+	}
+	filter, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 每个进程存活的时间足够被遍历到；子进程不能再调用 execve，因此只使用 shell 内建命令
+	r := &Runner{
+		Args:    []string{"/bin/sh", "-c", "f() { i=0; while [ $i -lt 20000 ]; do i=$((i+1)); done; }; f | f"},
+		Env:     []string{"PATH=/bin:/usr/bin"},
+		Limit:   runner.Limit{TimeLimit: 1e9, MemoryLimit: 256 << 20},
+		Seccomp: filter,
+		Handler: &testHandler{},
+	}
+	result := r.Run(context.Background())
+	if result.Status != runner.StatusNormal {
+		t.Fatalf("unexpected result: %v", result)
+	}
+	if result.Processes != 3 {
+		t.Errorf("expected 3 processes, got %d", result.Processes)
+	}
+}
@@ -21,13 +21,19 @@ const (
 	// - CLONE_NEWUSER: 用户命名空间隔离
 	// - CLONE_NEWUTS: UTS(主机名和域名)命名空间隔离
 	// - CLONE_NEWCGROUP: Cgroup 命名空间隔离
-	// 注意：不包括网络和 IPC 命名空间
+	// 注意：不包括网络和 IPC 命名空间（设置 Runner.Loopback 时隔离网络命名空间）
 	UnshareFlags = unix.CLONE_NEWNS | unix.CLONE_NEWPID | unix.CLONE_NEWUSER | unix.CLONE_NEWUTS | unix.CLONE_NEWCGROUP
 )
 
 // Run 启动一个带有命名空间隔离的进程
 // 参数 c 用于控制进程的生命周期
 func (r *Runner) Run(c context.Context) (result runner.Result) {
+	// 启用回环网络时额外隔离网络命名空间
+	cloneFlags := uintptr(UnshareFlags)
+	if r.Loopback {
+		cloneFlags |= unix.CLONE_NEWNET
+	}
+
 	// 配置 forkexec 运行器
 	ch := &forkexec.Runner{
 		Args:       r.Args,       // 命令行参数
@@ -38,7 +44,8 @@ func (r *Runner) Run(c context.Context) (result runner.Result) {
 		WorkDir:    r.WorkDir,    // 工作目录
 		Seccomp:    r.Seccomp.SockFprog(), // Seccomp 过滤器
 		NoNewPrivs: true,         // 禁止获取新特权
-		CloneFlags: UnshareFlags, // 命名空间隔离标志
+		CloneFlags: cloneFlags,   // 命名空间隔离标志
+		Loopback:   r.Loopback,   // 启用 lo 网卡
 		Mounts:     r.Mounts,     // 挂载点配置
 		HostName:   r.HostName,   // 主机名
 		DomainName: r.DomainName, // 域名
@@ -66,8 +73,8 @@ func (r *Runner) Run(c context.Context) (result runner.Result) {
 		return
 	}
 
-	// 创建可取消的上下文
-	ctx, cancel := context.WithCancel(c)
+	// 创建可取消的上下文，超出墙上时间限制时自动取消
+	ctx, cancel := r.Limit.WithWallTimeLimit(c)
 	defer cancel()
 
 	// 处理取消信号
@@ -85,6 +92,18 @@ func (r *Runner) Run(c context.Context) (result runner.Result) {
 	}()
 
 	fTime = time.Now()
+	counter := runner.CountProcesses(pgid)
+
+	// 先等待程序退出但不回收（WNOWAIT），在回收前读取 /proc/<pid>/io
+	// 等待失败时由下面的 wait4 报告错误
+	var info unix.Siginfo
+	for unix.Waitid(unix.P_PID, pgid, &info, unix.WEXITED|unix.WNOWAIT, nil) == unix.EINTR {
+	}
+	wallTime := time.Since(fTime)
+	processes := counter.Stop()
+	readBytes, writeBytes, err := runner.ProcIO(pgid)
+	r.println("io: ", readBytes, writeBytes, err)
+
 	for {
 		// 等待任意子进程状态改变
 		_, err := unix.Wait4(pgid, &wstatus, 0, &rusage)
@@ -99,21 +118,17 @@ func (r *Runner) Run(c context.Context) (result runner.Result) {
 		}
 
 		// 更新资源使用统计
-		userTime := time.Duration(rusage.Utime.Nano()) // 用户态 CPU 时间（纳秒）
-		userMem := runner.Size(rusage.Maxrss << 10)    // 最大常驻内存（字节）
-
-		// 检查是否超出资源限制
-		if userTime > r.Limit.TimeLimit {
-			status = runner.StatusTimeLimitExceeded // 超时
-		}
-		if userMem > r.Limit.MemoryLimit {
-			status = runner.StatusMemoryLimitExceeded // 超内存
-		}
 		result = runner.Result{
-			Status: status,
-			Time:   userTime,
-			Memory: userMem,
+			WallTime:   wallTime,
+			ReadBytes:  readBytes,
+			WriteBytes: writeBytes,
+			Processes:  processes,
 		}
+		result.SetRusage(&rusage)
+
+		// 按照时间统计策略检查是否超出资源限制
+		status = r.Limit.Check(&result)
+		result.Status = status
 		if status != runner.StatusNormal {
 			return
 		}
@@ -139,6 +154,9 @@ func (r *Runner) Run(c context.Context) (result runner.Result) {
 			default:
 				status = runner.StatusSignalled // 其他信号终止
 			}
+			if sig == unix.SIGKILL && runner.WallTimeExceeded(ctx) {
+				status = runner.StatusWallTimeLimitExceeded // 超出墙上时间限制被终止
+			}
 			result.Status = status
 			result.ExitStatus = int(sig)
 			return
@@ -37,6 +37,10 @@ type Runner struct {
 	// Mount syscalls
 	Mounts []mount.SyscallParams
 
+	// Loopback unshares the network namespace with only lo up, so that the
+	// program can listen and connect on 127.0.0.1 / ::1 but nothing else
+	Loopback bool
+
 	// hostname & domainname
 	HostName, DomainName string
 
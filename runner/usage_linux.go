@@ -0,0 +1,134 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetRusage 使用 wait4 返回的 rusage 设置 CPU 时间、内存、上下文切换和缺页次数
+func (r *Result) SetRusage(ru *unix.Rusage) {
+	r.Time = time.Duration(ru.Utime.Nano())
+	r.SystemTime = time.Duration(ru.Stime.Nano())
+	r.Memory = Size(ru.Maxrss << 10) // Maxrss 的单位为 KiB
+	r.VoluntaryContextSwitches = int64(ru.Nvcsw)
+	r.InvoluntaryContextSwitches = int64(ru.Nivcsw)
+	r.MajorPageFaults = int64(ru.Majflt)
+	r.MinorPageFaults = int64(ru.Minflt)
+}
+
+// ProcIO 读取 /proc/<pid>/io 中进程读写的字节数（rchar、wchar）
+// 包括所有线程和已经回收的子进程。进程退出后、被回收前仍然可以读取，
+// 因此运行器应先用 waitid(WNOWAIT) 等待进程退出，读取后再回收
+//
+// 参数：
+//   - pid: 进程 ID
+//
+// 返回：
+//   - read, write: 读取和写入的字节数
+//   - err: 读取或解析失败的错误
+func ProcIO(pid int) (read, write Size, err error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/io", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		k, v, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		var p *Size
+		switch k {
+		case "rchar":
+			p = &read
+		case "wchar":
+			p = &write
+		default:
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("/proc/%d/io: %s: %v", pid, k, err)
+		}
+		*p = Size(n)
+	}
+	return read, write, s.Err()
+}
+
+// ProcessCounter 定期遍历 /proc/<pid>/task/<tid>/children 统计进程树中出现过的进程数（包括根进程）
+//
+// 没有 ptrace 的运行器无法得知每一次 fork，两次遍历之间创建并退出的进程不会被统计，
+// 脱离进程树的孤儿进程（被 init 或 subreaper 收养）也不会被统计
+type ProcessCounter struct {
+	pid  int
+	seen map[int]bool
+	stop chan struct{}
+	done chan struct{}
+}
+
+// processCountInterval 是遍历进程树的间隔
+const processCountInterval = 10 * time.Millisecond
+
+// CountProcesses 开始统计 pid 的进程树，调用者应在回收 pid 之前调用 Stop
+func CountProcesses(pid int) *ProcessCounter {
+	c := &ProcessCounter{
+		pid:  pid,
+		seen: map[int]bool{pid: true},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func (c *ProcessCounter) run() {
+	defer close(c.done)
+
+	t := time.NewTicker(processCountInterval)
+	defer t.Stop()
+	for {
+		c.walk(c.pid)
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// walk 递归记录 pid 的所有子进程
+func (c *ProcessCounter) walk(pid int) {
+	tasks, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return
+	}
+	for _, t := range tasks {
+		b, err := os.ReadFile(fmt.Sprintf("/proc/%d/task/%s/children", pid, t.Name()))
+		if err != nil {
+			continue
+		}
+		for _, f := range strings.Fields(string(b)) {
+			child, err := strconv.Atoi(f)
+			if err != nil {
+				continue
+			}
+			c.seen[child] = true
+			c.walk(child)
+		}
+	}
+}
+
+// Stop 结束统计并返回进程数
+func (c *ProcessCounter) Stop() int {
+	close(c.stop)
+	<-c.done
+	return len(c.seen)
+}